@@ -0,0 +1,378 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ADIF 3.1 tokenizer / serializer
+// https://adif.org/314/ADIF_314.htm
+
+// ADIFField is a single data specifier: <NAME:LEN[:TYPE]>VALUE.
+type ADIFField struct {
+	Name  string `json:"name"` // 大文字に正規化
+	Type  string `json:"type,omitempty"`
+	Value string `json:"value"`
+}
+
+// ADIFRecord is an ordered list of fields terminated by <EOR>.
+type ADIFRecord struct {
+	Fields []ADIFField
+}
+
+// ADIFFile is a parsed ADIF document. Header is empty when the input has no
+// <EOH> (e.g. a single record sent by WSJT-X).
+type ADIFFile struct {
+	HeaderText string
+	Header     []ADIFField
+	Records    []*ADIFRecord
+}
+
+// get returns the value of the named field (case-insensitive), or "".
+func (r *ADIFRecord) get(name string) string {
+	name = strings.ToUpper(name)
+	for _, f := range r.Fields {
+		if f.Name == name {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+// parseADIF tokenizes an ADIF document. Field names are case-insensitive and
+// the length specifier is honoured, so values may contain '<' or multi-byte
+// characters. LEN counts characters (ADIF 3.1 Intl fields), which is the
+// same as bytes for plain ASCII data. A trailing record without <EOR> is
+// accepted.
+func parseADIF(s string) (*ADIFFile, error) {
+	file := &ADIFFile{}
+	rec := &ADIFRecord{}
+
+	// 先頭が '<' 以外ならヘッダーあり（ADIF仕様）
+	// ヘッダー終端がない場合はヘッダーなしとして扱う
+	inHeader := len(s) > 0 && s[0] != '<' && strings.Contains(strings.ToLower(s), "<eoh>")
+	if inHeader {
+		if lt := strings.IndexByte(s, '<'); lt >= 0 {
+			file.HeaderText = strings.TrimSpace(s[:lt])
+		}
+	}
+
+	pos := 0
+	for {
+		lt := strings.IndexByte(s[pos:], '<')
+		if lt < 0 {
+			break
+		}
+		pos += lt + 1
+
+		gt := strings.IndexByte(s[pos:], '>')
+		if gt < 0 {
+			return nil, errors.New("adif: unterminated data specifier")
+		}
+		spec := s[pos : pos+gt]
+		pos += gt + 1
+
+		parts := strings.Split(spec, ":")
+		name := strings.ToUpper(strings.TrimSpace(parts[0]))
+
+		switch {
+		case name == "EOH" && len(parts) == 1:
+			if inHeader {
+				file.Header = rec.Fields
+				rec = &ADIFRecord{}
+				inHeader = false
+			}
+			continue
+
+		case name == "EOR" && len(parts) == 1:
+			if len(rec.Fields) > 0 {
+				file.Records = append(file.Records, rec)
+			}
+			rec = &ADIFRecord{}
+			continue
+		}
+
+		if len(parts) < 2 || name == "" {
+			// 長さなしのタグは無視（コメント中の '<' 等）
+			continue
+		}
+
+		n, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || n < 0 {
+			continue
+		}
+
+		field := ADIFField{Name: name}
+		if len(parts) > 2 {
+			field.Type = strings.ToUpper(strings.TrimSpace(parts[2]))
+		}
+
+		end := advanceRunes(s, pos, n)
+		field.Value = s[pos:end]
+		pos = end
+
+		rec.Fields = append(rec.Fields, field)
+	}
+
+	if len(rec.Fields) > 0 && !inHeader {
+		file.Records = append(file.Records, rec)
+	}
+
+	return file, nil
+}
+
+// advanceRunes returns the byte offset n characters after pos, clamped to len(s).
+func advanceRunes(s string, pos, n int) int {
+	for i := 0; i < n && pos < len(s); i++ {
+		_, size := utf8.DecodeRuneInString(s[pos:])
+		pos += size
+	}
+	return pos
+}
+
+// writeADIFField appends one data specifier to b.
+func writeADIFField(b *strings.Builder, f ADIFField) {
+	b.WriteByte('<')
+	b.WriteString(strings.ToUpper(f.Name))
+	b.WriteByte(':')
+	b.WriteString(strconv.Itoa(utf8.RuneCountInString(f.Value)))
+	if f.Type != "" {
+		b.WriteByte(':')
+		b.WriteString(f.Type)
+	}
+	b.WriteByte('>')
+	b.WriteString(f.Value)
+}
+
+// String serializes the record followed by <EOR>. Empty fields are omitted.
+func (r *ADIFRecord) String() string {
+	var b strings.Builder
+	for _, f := range r.Fields {
+		if f.Value == "" {
+			continue
+		}
+		writeADIFField(&b, f)
+		b.WriteByte(' ')
+	}
+	b.WriteString("<EOR>")
+	return b.String()
+}
+
+// String serializes the document with an <EOH> header when present.
+func (f *ADIFFile) String() string {
+	var b strings.Builder
+	if f.HeaderText != "" || len(f.Header) > 0 {
+		if f.HeaderText != "" {
+			b.WriteString(f.HeaderText)
+			b.WriteByte('\n')
+		} else {
+			b.WriteString("HAMLAB Bridge ADIF export\n")
+		}
+		for _, h := range f.Header {
+			writeADIFField(&b, h)
+			b.WriteByte('\n')
+		}
+		b.WriteString("<EOH>\n")
+	}
+	for _, r := range f.Records {
+		b.WriteString(r.String())
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// ---- typed QSO model ----
+
+// QSO is the structured form of one ADIF record shared by the bridge, the
+// logbook uploaders and WebSocket events. Fields without a typed counterpart
+// (APP_* fields and anything else) are kept in Extra so that a record
+// survives a parse/serialize round trip.
+type QSO struct {
+	Call            string    `json:"call"`
+	Band            string    `json:"band,omitempty"`
+	BandRx          string    `json:"bandRx,omitempty"`
+	Mode            string    `json:"mode,omitempty"`
+	Submode         string    `json:"submode,omitempty"`
+	Freq            float64   `json:"freq,omitempty"`   // MHz
+	FreqRx          float64   `json:"freqRx,omitempty"` // MHz
+	TimeOn          time.Time `json:"timeOn,omitzero"`
+	TimeOff         time.Time `json:"timeOff,omitzero"`
+	Gridsquare      string    `json:"gridsquare,omitempty"`
+	MyGridsquare    string    `json:"myGridsquare,omitempty"`
+	RSTSent         string    `json:"rstSent,omitempty"`
+	RSTRcvd         string    `json:"rstRcvd,omitempty"`
+	Name            string    `json:"name,omitempty"`
+	QTH             string    `json:"qth,omitempty"`
+	Cnty            string    `json:"cnty,omitempty"`
+	State           string    `json:"state,omitempty"`
+	Country         string    `json:"country,omitempty"`
+	DXCC            string    `json:"dxcc,omitempty"`
+	Comment         string    `json:"comment,omitempty"`
+	Notes           string    `json:"notes,omitempty"`
+	TxPwr           string    `json:"txPwr,omitempty"`
+	PropMode        string    `json:"propMode,omitempty"`
+	StationCallsign string    `json:"stationCallsign,omitempty"`
+	Operator        string    `json:"operator,omitempty"`
+
+	Extra []ADIFField `json:"extra,omitempty"`
+}
+
+// qsoStringFields maps plain string ADIF fields to QSO struct fields, in the
+// order they are serialized.
+var qsoStringFields = []struct {
+	name string
+	ptr  func(q *QSO) *string
+}{
+	{"CALL", func(q *QSO) *string { return &q.Call }},
+	{"BAND", func(q *QSO) *string { return &q.Band }},
+	{"BAND_RX", func(q *QSO) *string { return &q.BandRx }},
+	{"MODE", func(q *QSO) *string { return &q.Mode }},
+	{"SUBMODE", func(q *QSO) *string { return &q.Submode }},
+	{"GRIDSQUARE", func(q *QSO) *string { return &q.Gridsquare }},
+	{"MY_GRIDSQUARE", func(q *QSO) *string { return &q.MyGridsquare }},
+	{"RST_SENT", func(q *QSO) *string { return &q.RSTSent }},
+	{"RST_RCVD", func(q *QSO) *string { return &q.RSTRcvd }},
+	{"NAME", func(q *QSO) *string { return &q.Name }},
+	{"QTH", func(q *QSO) *string { return &q.QTH }},
+	{"CNTY", func(q *QSO) *string { return &q.Cnty }},
+	{"STATE", func(q *QSO) *string { return &q.State }},
+	{"COUNTRY", func(q *QSO) *string { return &q.Country }},
+	{"DXCC", func(q *QSO) *string { return &q.DXCC }},
+	{"COMMENT", func(q *QSO) *string { return &q.Comment }},
+	{"NOTES", func(q *QSO) *string { return &q.Notes }},
+	{"TX_PWR", func(q *QSO) *string { return &q.TxPwr }},
+	{"PROP_MODE", func(q *QSO) *string { return &q.PropMode }},
+	{"STATION_CALLSIGN", func(q *QSO) *string { return &q.StationCallsign }},
+	{"OPERATOR", func(q *QSO) *string { return &q.Operator }},
+}
+
+// qsoParsedFields are converted to typed values and not kept in Extra.
+var qsoParsedFields = map[string]bool{
+	"QSO_DATE": true, "TIME_ON": true, "QSO_DATE_OFF": true, "TIME_OFF": true,
+	"FREQ": true, "FREQ_RX": true,
+}
+
+// qsoFromRecord converts an ADIF record into a QSO.
+func qsoFromRecord(r *ADIFRecord) *QSO {
+	q := &QSO{}
+
+	known := map[string]func(v string){}
+	for _, sf := range qsoStringFields {
+		p := sf.ptr(q)
+		known[sf.name] = func(v string) { *p = strings.TrimSpace(v) }
+	}
+
+	for _, f := range r.Fields {
+		if set, ok := known[f.Name]; ok {
+			set(f.Value)
+			continue
+		}
+		if qsoParsedFields[f.Name] {
+			continue
+		}
+		q.Extra = append(q.Extra, f)
+	}
+
+	q.Call = strings.ToUpper(q.Call)
+	q.Gridsquare = strings.ToUpper(q.Gridsquare)
+	q.MyGridsquare = strings.ToUpper(q.MyGridsquare)
+	q.Freq, _ = strconv.ParseFloat(strings.TrimSpace(r.get("FREQ")), 64)
+	q.FreqRx, _ = strconv.ParseFloat(strings.TrimSpace(r.get("FREQ_RX")), 64)
+
+	q.TimeOn = parseADIFDateTime(r.get("QSO_DATE"), r.get("TIME_ON"))
+	if r.get("TIME_OFF") != "" {
+		dateOff := r.get("QSO_DATE_OFF")
+		if dateOff == "" {
+			dateOff = r.get("QSO_DATE")
+		}
+		q.TimeOff = parseADIFDateTime(dateOff, r.get("TIME_OFF"))
+		if r.get("QSO_DATE_OFF") == "" && !q.TimeOff.IsZero() && q.TimeOff.Before(q.TimeOn) {
+			// 日付をまたいだ交信
+			q.TimeOff = q.TimeOff.Add(24 * time.Hour)
+		}
+	}
+
+	return q
+}
+
+// record converts the QSO back into an ADIF record.
+func (q *QSO) record() *ADIFRecord {
+	r := &ADIFRecord{}
+	for _, sf := range qsoStringFields {
+		if v := *sf.ptr(q); v != "" {
+			r.Fields = append(r.Fields, ADIFField{Name: sf.name, Value: v})
+		}
+		// 周波数と日時は MODE/SUBMODE の後に置く
+		if sf.name == "SUBMODE" {
+			if q.Freq > 0 {
+				r.Fields = append(r.Fields, ADIFField{Name: "FREQ", Value: formatADIFFreq(q.Freq)})
+			}
+			if q.FreqRx > 0 {
+				r.Fields = append(r.Fields, ADIFField{Name: "FREQ_RX", Value: formatADIFFreq(q.FreqRx)})
+			}
+			if !q.TimeOn.IsZero() {
+				on := q.TimeOn.UTC()
+				r.Fields = append(r.Fields,
+					ADIFField{Name: "QSO_DATE", Value: on.Format("20060102")},
+					ADIFField{Name: "TIME_ON", Value: on.Format("150405")},
+				)
+			}
+			if !q.TimeOff.IsZero() {
+				off := q.TimeOff.UTC()
+				r.Fields = append(r.Fields,
+					ADIFField{Name: "QSO_DATE_OFF", Value: off.Format("20060102")},
+					ADIFField{Name: "TIME_OFF", Value: off.Format("150405")},
+				)
+			}
+		}
+	}
+	r.Fields = append(r.Fields, q.Extra...)
+	return r
+}
+
+// adif serializes the QSO as a single ADIF record terminated by <EOR>.
+func (q *QSO) adif() string {
+	return q.record().String()
+}
+
+// parseQSOs parses an ADIF document into QSOs.
+func parseQSOs(s string) ([]*QSO, error) {
+	file, err := parseADIF(s)
+	if err != nil {
+		return nil, err
+	}
+	qsos := make([]*QSO, 0, len(file.Records))
+	for _, r := range file.Records {
+		qsos = append(qsos, qsoFromRecord(r))
+	}
+	return qsos, nil
+}
+
+// parseADIFDateTime combines an ADIF date (YYYYMMDD) and time (HHMM or
+// HHMMSS) into a UTC time. It returns the zero time when the date is invalid.
+func parseADIFDateTime(date, tm string) time.Time {
+	date = strings.TrimSpace(date)
+	tm = strings.TrimSpace(tm)
+	if len(date) != 8 {
+		return time.Time{}
+	}
+	switch len(tm) {
+	case 4:
+		tm += "00"
+	case 6:
+	default:
+		tm = "000000"
+	}
+	t, err := time.Parse("20060102150405", date+tm)
+	if err != nil {
+		return time.Time{}
+	}
+	return t.UTC()
+}
+
+func formatADIFFreq(mhz float64) string {
+	return strconv.FormatFloat(mhz, 'f', -1, 64)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseADIF(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		header  []ADIFField
+		records [][]ADIFField
+	}{
+		{
+			name: "WSJT-X record",
+			in:   "<call:5>JA1AB <gridsquare:4>PM95 <mode:3>FT8 <rst_sent:3>-10 <eor>",
+			records: [][]ADIFField{{
+				{Name: "CALL", Value: "JA1AB"},
+				{Name: "GRIDSQUARE", Value: "PM95"},
+				{Name: "MODE", Value: "FT8"},
+				{Name: "RST_SENT", Value: "-10"},
+			}},
+		},
+		{
+			name: "EOR in any case",
+			in:   "<CALL:4>K1AB<EOR><call:4>W1AW<eOr>",
+			records: [][]ADIFField{
+				{{Name: "CALL", Value: "K1AB"}},
+				{{Name: "CALL", Value: "W1AW"}},
+			},
+		},
+		{
+			name: "header is skipped",
+			in: "WSJT-X ADIF Export <call:4>NONE in text\n<adif_ver:5>3.1.4\n<programid:6>WSJT-X\n<EOH>\n" +
+				"<call:4>K1AB <eor>\n",
+			header: []ADIFField{
+				{Name: "CALL", Value: "NONE"}, // ヘッダー内のタグはヘッダーのフィールド
+				{Name: "ADIF_VER", Value: "3.1.4"},
+				{Name: "PROGRAMID", Value: "WSJT-X"},
+			},
+			records: [][]ADIFField{{{Name: "CALL", Value: "K1AB"}}},
+		},
+		{
+			name: "length covers '<', '>' and quotes",
+			in:   `<comment:19>a <b> "c" 'd' <eor>e<eor>`,
+			records: [][]ADIFField{{
+				{Name: "COMMENT", Value: `a <b> "c" 'd' <eor>`},
+			}},
+		},
+		{
+			name: "multi-byte values count characters",
+			in:   "<name:2>山田 <qth:5>東京都港区 <call:5>JA1AB <eor>",
+			records: [][]ADIFField{{
+				{Name: "NAME", Value: "山田"},
+				{Name: "QTH", Value: "東京都港区"},
+				{Name: "CALL", Value: "JA1AB"},
+			}},
+		},
+		{
+			name: "type specifier",
+			in:   "<freq:6:N>14.074<qso_date:8:d>20240102<eor>",
+			records: [][]ADIFField{{
+				{Name: "FREQ", Type: "N", Value: "14.074"},
+				{Name: "QSO_DATE", Type: "D", Value: "20240102"},
+			}},
+		},
+		{
+			name:    "tags without length and trailing record without EOR",
+			in:      "<br><call:4>K1AB",
+			records: [][]ADIFField{{{Name: "CALL", Value: "K1AB"}}},
+		},
+		{
+			name:    "length past the end is clamped",
+			in:      "<call:10>K1AB",
+			records: [][]ADIFField{{{Name: "CALL", Value: "K1AB"}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := parseADIF(tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(f.Header, tt.header) {
+				t.Errorf("header = %+v, want %+v", f.Header, tt.header)
+			}
+			var got [][]ADIFField
+			for _, r := range f.Records {
+				got = append(got, r.Fields)
+			}
+			if !reflect.DeepEqual(got, tt.records) {
+				t.Errorf("records = %+v, want %+v", got, tt.records)
+			}
+		})
+	}
+}
+
+func TestParseADIFUnterminated(t *testing.T) {
+	if _, err := parseADIF("<call:4"); err == nil {
+		t.Fatal("want error for an unterminated data specifier")
+	}
+}
+
+// TestADIFRoundTrip serializes parsed documents and parses them again.
+func TestADIFRoundTrip(t *testing.T) {
+	docs := []string{
+		"<call:5>JA1AB <name:2>山田 <comment:9>a<b>c\"d'e <app_wsjtx_x:1>1 <eor>",
+		"Export\n<adif_ver:5>3.1.4\n<eoh>\n<call:4>K1AB<eor><call:4>W1AW<EOR>",
+	}
+	for _, in := range docs {
+		f, err := parseADIF(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := f.String()
+		g, err := parseADIF(out)
+		if err != nil {
+			t.Fatalf("reparse %q: %v", out, err)
+		}
+		if !reflect.DeepEqual(f, g) {
+			t.Errorf("round trip of %q\nserialized %q\ngot  %+v\nwant %+v", in, out, g, f)
+		}
+	}
+}
+
+func TestQSORoundTrip(t *testing.T) {
+	in := "<call:5>ja1ab <gridsquare:4>pm95 <mode:3>FT8 <freq:9>14.075123 " +
+		"<qso_date:8>20240102 <time_on:6>235930 <time_off:4>0001 " +
+		"<name:4>山田太郎 <rst_sent:3>-10 <rst_rcvd:3>-12 <app_wsjtx_x:3>a<b <eor>"
+	qsos, err := parseQSOs(in)
+	if err != nil || len(qsos) != 1 {
+		t.Fatalf("parseQSOs = %v, %v", qsos, err)
+	}
+	q := qsos[0]
+
+	want := &QSO{
+		Call:       "JA1AB",
+		Gridsquare: "PM95",
+		Mode:       "FT8",
+		Freq:       14.075123,
+		TimeOn:     time.Date(2024, 1, 2, 23, 59, 30, 0, time.UTC),
+		TimeOff:    time.Date(2024, 1, 3, 0, 1, 0, 0, time.UTC), // 日付をまたぐ
+		Name:       "山田太郎",
+		RSTSent:    "-10",
+		RSTRcvd:    "-12",
+		Extra:      []ADIFField{{Name: "APP_WSJTX_X", Value: "a<b"}},
+	}
+	if !reflect.DeepEqual(q, want) {
+		t.Fatalf("qso = %+v\nwant  %+v", q, want)
+	}
+
+	again, err := parseQSOs(q.adif())
+	if err != nil || len(again) != 1 {
+		t.Fatalf("reparse = %v, %v", again, err)
+	}
+	if !reflect.DeepEqual(again[0], q) {
+		t.Errorf("round trip via %q\ngot  %+v\nwant %+v", q.adif(), again[0], q)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// defaultAllowedOrigins are the web apps allowed to use the WebSocket and
+// REST API when "allowed_origins" is not set.
+var defaultAllowedOrigins = []string{"https://hamlab.jp", "https://*.hamlab.jp"}
+
+// csrfToken protects the settings forms. It changes on every start, so a
+// page opened before a restart has to be reloaded.
+var csrfToken = newToken()
+
+// newToken returns a random 128-bit token in hex.
+func newToken() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func tokenEqual(a, b string) bool {
+	return a != "" && subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// allowedOrigins returns the configured origin allowlist.
+func allowedOrigins() []string {
+	configLock.RLock()
+	defer configLock.RUnlock()
+	if config.AllowedOrigins == nil {
+		return defaultAllowedOrigins
+	}
+	return config.AllowedOrigins
+}
+
+// originAllowed reports whether a browser Origin may connect. A request
+// without Origin comes from a native client and is allowed here; it still
+// needs the pairing token. "https://*.example.com" matches subdomains.
+func originAllowed(origin string) bool {
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	origin = strings.ToLower(u.Scheme + "://" + u.Host)
+	for _, a := range allowedOrigins() {
+		a = strings.ToLower(strings.TrimRight(strings.TrimSpace(a), "/"))
+		if a == origin {
+			return true
+		}
+		if scheme, host, ok := strings.Cut(a, "://*."); ok {
+			if u.Scheme == scheme && strings.HasSuffix(strings.ToLower(u.Host), "."+host) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// localHost reports whether the Host header names this machine, which
+// rejects DNS-rebinding requests for other host names.
+func localHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(strings.ToLower(host), "[]")
+	return host == "localhost" || host == "127.0.0.1" || host == "::1"
+}
+
+// withHostCheck rejects requests whose Host header is not the local host.
+func withHostCheck(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !localHost(r.Host) {
+			log.Printf("[AUTH] rejected host %q from %s", r.Host, r.RemoteAddr)
+			http.Error(w, "invalid host", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// pairingToken returns the per-install token WebSocket and API clients
+// must present.
+func pairingToken() string {
+	configLock.RLock()
+	defer configLock.RUnlock()
+	return config.PairingToken
+}
+
+// requestToken returns the pairing token of a request: the "token" query
+// parameter (browsers cannot set WebSocket headers) or a Bearer token.
+func requestToken(r *http.Request) string {
+	if t := r.URL.Query().Get("token"); t != "" {
+		return t
+	}
+	if t, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(t)
+	}
+	return ""
+}
+
+// clientAuthorized checks the Origin and pairing token of a WebSocket or
+// API request and writes the error response if it is rejected.
+func clientAuthorized(w http.ResponseWriter, r *http.Request) bool {
+	if !originAllowed(r.Header.Get("Origin")) {
+		log.Printf("[AUTH] rejected origin %q from %s", r.Header.Get("Origin"), r.RemoteAddr)
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return false
+	}
+	if !tokenEqual(requestToken(r), pairingToken()) {
+		log.Printf("[AUTH] invalid pairing token from %s (origin %q)", r.RemoteAddr, r.Header.Get("Origin"))
+		http.Error(w, "invalid pairing token", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// csrfValid checks the CSRF token of a settings form and, if the browser
+// sent one, that Origin is the settings UI itself.
+func csrfValid(r *http.Request) bool {
+	if o := r.Header.Get("Origin"); o != "" {
+		u, err := url.Parse(o)
+		if err != nil || !localHost(u.Host) {
+			return false
+		}
+	}
+	return tokenEqual(r.FormValue("csrf"), csrfToken)
+}
+
+// requireCSRF wraps a POST handler of the settings UI.
+func requireCSRF(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" && !csrfValid(r) {
+			log.Printf("[AUTH] invalid CSRF token for %s from %s", r.URL.Path, r.RemoteAddr)
+			http.Error(w, "invalid CSRF token (reload the settings page)", http.StatusForbidden)
+			return
+		}
+		h(w, r)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// setAllowedOrigins sets the configured origin allowlist for a test.
+func setAllowedOrigins(t *testing.T, origins []string) {
+	t.Helper()
+	configLock.Lock()
+	old := config.AllowedOrigins
+	config.AllowedOrigins = origins
+	configLock.Unlock()
+	t.Cleanup(func() {
+		configLock.Lock()
+		config.AllowedOrigins = old
+		configLock.Unlock()
+	})
+}
+
+func TestOriginAllowed(t *testing.T) {
+	tests := []struct {
+		allowed []string // nil = 既定値
+		origin  string
+		want    bool
+	}{
+		{nil, "", true},
+		{nil, "https://hamlab.jp", true},
+		{nil, "https://HamLab.jp", true},
+		{nil, "https://log.hamlab.jp", true},
+		{nil, "https://a.b.hamlab.jp", true},
+		{nil, "https://evilhamlab.jp", false},
+		{nil, "https://hamlab.jp.example.com", false},
+		{nil, "http://hamlab.jp", false},
+		{nil, "http://log.hamlab.jp", false},
+		{nil, "https://example.com", false},
+		{nil, "null", false},
+		{nil, "://bad", false},
+		{[]string{"http://localhost:3000/"}, "http://localhost:3000", true},
+		{[]string{"http://localhost:3000"}, "http://localhost:3001", false},
+		{[]string{"http://localhost:3000"}, "https://hamlab.jp", false},
+		{[]string{}, "https://hamlab.jp", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.origin, func(t *testing.T) {
+			setAllowedOrigins(t, tt.allowed)
+			if got := originAllowed(tt.origin); got != tt.want {
+				t.Errorf("originAllowed(%q) with %q = %v, want %v", tt.origin, tt.allowed, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocalHost(t *testing.T) {
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"localhost", true},
+		{"LOCALHOST:17801", true},
+		{"127.0.0.1:17800", true},
+		{"[::1]:17800", true},
+		{"::1", true},
+		{"attacker.example", false},
+		{"attacker.example:17800", false},
+		{"localhost.attacker.example", false},
+		{"127.0.0.2", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := localHost(tt.host); got != tt.want {
+			t.Errorf("localHost(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+}
+
+// The WebSocket and the journal API check the Host, the Origin and the
+// pairing token, in that order.
+func TestClientAuthorized(t *testing.T) {
+	setAllowedOrigins(t, nil)
+	h := wsServerHandler()
+	tests := []struct {
+		name   string
+		path   string
+		host   string // "" = 127.0.0.1:17800
+		origin string
+		auth   string // Authorization ヘッダー
+		want   int
+	}{
+		{"ws token", "/ws?token=" + testPairingToken, "", "", "", http.StatusBadRequest}, // 認証後、WebSocket でないため
+		{"ws no token", "/ws", "", "", "", http.StatusUnauthorized},
+		{"ws wrong token", "/ws?token=wrong", "", "", "", http.StatusUnauthorized},
+		{"ws bearer", "/ws", "", "", "Bearer " + testPairingToken, http.StatusBadRequest},
+		{"ws other host", "/ws?token=" + testPairingToken, "attacker.example", "", "", http.StatusForbidden},
+		{"ws other origin", "/ws?token=" + testPairingToken, "", "https://evilhamlab.jp", "", http.StatusForbidden},
+		{"api token", "/api/qsos", "", "https://hamlab.jp", "Bearer " + testPairingToken, http.StatusOK},
+		{"api no token", "/api/qsos", "", "https://hamlab.jp", "", http.StatusUnauthorized},
+		{"api wrong token", "/api/qsos", "", "", "Bearer wrong", http.StatusUnauthorized},
+		{"api empty bearer", "/api/qsos", "", "", "Bearer ", http.StatusUnauthorized},
+		{"api other host", "/api/qsos", "attacker.example:17800", "", "Bearer " + testPairingToken, http.StatusForbidden},
+		{"api http origin", "/api/qsos", "", "http://hamlab.jp", "Bearer " + testPairingToken, http.StatusForbidden},
+		{"api export no token", "/api/qsos/export.adi", "", "", "", http.StatusUnauthorized},
+		{"api qso no token", "/api/qsos/1", "", "", "", http.StatusUnauthorized},
+		{"schema other host", "/schema.json", "attacker.example", "", "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.path, nil)
+			r.Host = tt.host
+			if r.Host == "" {
+				r.Host = "127.0.0.1:17800"
+			}
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.auth != "" {
+				r.Header.Set("Authorization", tt.auth)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d (%s)", w.Code, tt.want, strings.TrimSpace(w.Body.String()))
+			}
+		})
+	}
+}
+
+// The settings forms need the CSRF token and, if the browser sends an
+// Origin, the settings UI as the Origin.
+func TestCSRFValid(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		csrf   string // "-" = フィールドなし
+		origin string
+		want   int
+	}{
+		{"token", "POST", csrfToken, "", http.StatusOK},
+		{"token from the UI", "POST", csrfToken, "http://127.0.0.1:17801", http.StatusOK},
+		{"token from localhost", "POST", csrfToken, "http://localhost:17801", http.StatusOK},
+		{"no token", "POST", "-", "", http.StatusForbidden},
+		{"empty token", "POST", "", "", http.StatusForbidden},
+		{"wrong token", "POST", "wrong", "", http.StatusForbidden},
+		{"token from another site", "POST", csrfToken, "https://attacker.example", http.StatusForbidden},
+		{"token from hamlab.jp", "POST", csrfToken, "https://hamlab.jp", http.StatusForbidden},
+		{"GET without token", "GET", "-", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			h := withHostCheck(requireCSRF(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+			}))
+			form := url.Values{"use_rig": {"on"}}
+			if tt.csrf != "-" {
+				form.Set("csrf", tt.csrf)
+			}
+			var r *http.Request
+			if tt.method == "POST" {
+				r = httptest.NewRequest("POST", "/settings", strings.NewReader(form.Encode()))
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			} else {
+				r = httptest.NewRequest("GET", "/settings?"+form.Encode(), nil)
+			}
+			r.Host = "127.0.0.1:17801"
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+			if called != (tt.want == http.StatusOK) {
+				t.Errorf("handler called = %v", called)
+			}
+		})
+	}
+
+	// 設定画面も Host を確認する
+	r := httptest.NewRequest("POST", "/settings", strings.NewReader("csrf="+csrfToken))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Host = "attacker.example"
+	w := httptest.NewRecorder()
+	withHostCheck(requireCSRF(func(http.ResponseWriter, *http.Request) {
+		t.Error("handler called for another host")
+	})).ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status for another host = %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
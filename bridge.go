@@ -1,167 +1,387 @@
 package main
 
 import (
-	"encoding/json"
+	"errors"
 	"log"
 	"net"
-
-	"regexp"
-
 	"strings"
+	"sync"
 	"time"
 )
 
-var reGrid = regexp.MustCompile(`(?i)<gridsquare:\d+>([A-Za-z0-9]+)`)
-var reCall = regexp.MustCompile(`(?i)<call:\d+>([A-Za-z0-9/]+)`)
-var reQSODate = regexp.MustCompile(`(?i)<qso_date:\d+>(\d{8})`)
+var qrzc *qrzCache // openAppData で読み込む
 
-var qrzc = newQRZCache(24 * time.Hour)
+// bridgeListener is a running UDP listener.
+type bridgeListener struct {
+	name string
+	conn *net.UDPConn
+}
 
-// startBridge starts the HAMLAB Bridge. It starts a WebSocket server on localhost:17800 and a UDP server on localhost:2333.
-// The WebSocket server listens for incoming WSJT-X/JTDX messages and broadcasts them to connected WebSocket clients.
-// The UDP server listens for incoming WSJT-X/JTDX messages and broadcasts them to connected WebSocket clients.
+var bridgeListeners []*bridgeListener
+var listenerErrors = map[int]string{} // 設定画面に表示するバインドエラー
+var bridgeListenersMu sync.Mutex
+
+// startBridge starts one UDP server per configured listener (by default
+// 127.0.0.1:2333). Each listener accepts both the native WSJT-X/JTDX binary
+// protocol and plain ADIF text, and broadcasts the resulting events to
+// connected WebSocket clients. Bind errors are logged and kept for the
+// settings UI; they do not stop the other listeners.
 func startBridge() {
+	configLock.RLock()
+	listeners := make([]UDPListenerConfig, len(config.Listeners))
+	copy(listeners, config.Listeners)
+	configLock.RUnlock()
 
-	addr, _ := net.ResolveUDPAddr("udp", "127.0.0.1:2333")
-	conn, _ := net.ListenUDP("udp", addr)
-	defer conn.Close()
+	bridgeListenersMu.Lock()
+	defer bridgeListenersMu.Unlock()
 
-	buf := make([]byte, 4096)
-	for {
-		n, _, _ := conn.ReadFromUDP(buf)
-		adif := string(buf[:n])
-		log.Println("[QRZ] adif :", adif)
+	listenerErrors = map[int]string{}
 
-		call := extractCall(adif)
+	for i, l := range listeners {
+		if strings.TrimSpace(l.Address) == "" {
+			continue
+		}
+
+		name := l.Name
+		if name == "" {
+			name = l.Address
+		}
 
-		if call == "" || !hasQSODate(adif) {
+		conn, err := openUDPListener(l)
+		if err != nil {
+			log.Printf("[UDP] listen error: %s (%s): %v", name, l.Address, err)
+			listenerErrors[i] = err.Error()
 			continue
 		}
+		log.Printf("[UDP] listening: %s (%s)", name, conn.LocalAddr())
 
-		var qrzQTH, qrzGrid string
-
-		configLock.RLock()
-		useQRZ := config.UseQRZ
-		useGeo := config.UseGeo
-		configLock.RUnlock()
-
-		qrzOperator := ""
-
-		log.Println("[BRIDGE] QRZ enabled:", useQRZ, "call:", call)
-		if useQRZ && call != "" {
-
-			var qrz *qrzCall
-			portable := isPortableCall(call)
-
-			// ① キャッシュ確認
-			if cached, ok := qrzc.get(call); ok {
-				log.Println("[QRZ] cache hit:", call)
-				qrz = cached
-			} else {
-				log.Println("[QRZ] cache miss, lookup:", call)
-				if ensureQRZLogin() == nil {
-					if r, err := qrzLookup(qrzKey, call); err == nil {
-						qrz = r
-						qrzc.set(call, r)
-						log.Println("[QRZ] cache store:", call)
-					} else {
-						log.Println("[BRIDGE] QRZ lookup error:", err)
-					}
-				}
-			}
+		bl := &bridgeListener{name: name, conn: conn}
+		bridgeListeners = append(bridgeListeners, bl)
+		go serveUDPListener(bl)
+	}
+}
 
-			if qrz != nil {
-				log.Println("[BRIDGE] QRZ used")
+// stopBridge closes all running UDP listeners.
+func stopBridge() {
+	bridgeListenersMu.Lock()
+	defer bridgeListenersMu.Unlock()
 
-				// ★ NAME → operator（/P でも使う）
-				fullName := strings.TrimSpace(qrz.Fname + " " + qrz.Name)
-				if fullName != "" {
-					qrzOperator = fullName
-				}
+	for _, bl := range bridgeListeners {
+		bl.conn.Close()
+	}
+	bridgeListeners = nil
+}
+
+// restartBridge restarts the UDP listeners with the current configuration.
+func restartBridge() {
+	log.Println("[UDP] restarting listeners...")
+	stopBridge()
+	startBridge()
+}
 
-				// ★ /P 等は QTH / Grid を使わない
-				if !portable {
-					qrzQTH = qrz.Addr2
+// getListenerErrors returns the bind error for each listener row, indexed
+// like Config.Listeners. Rows without an error are empty.
+func getListenerErrors(n int) []string {
+	bridgeListenersMu.Lock()
+	defer bridgeListenersMu.Unlock()
 
-					if usableQRZGrid(qrz.Grid) {
-						qrzGrid = qrz.Grid
-					}
-				}
+	errs := make([]string, n)
+	for i, e := range listenerErrors {
+		if i < n {
+			errs[i] = e
+		}
+	}
+	return errs
+}
+
+// openUDPListener binds a unicast socket, or joins the multicast group when
+// the address is a multicast address.
+func openUDPListener(l UDPListenerConfig) (*net.UDPConn, error) {
+	addr, err := net.ResolveUDPAddr("udp", strings.TrimSpace(l.Address))
+	if err != nil {
+		return nil, err
+	}
+
+	if addr.IP != nil && addr.IP.IsMulticast() {
+		var iface *net.Interface
+		if l.Interface != "" {
+			iface, err = net.InterfaceByName(l.Interface)
+			if err != nil {
+				return nil, err
 			}
 		}
+		return net.ListenMulticastUDP("udp", iface, addr)
+	}
+
+	return net.ListenUDP("udp", addr)
+}
+
+// serveUDPListener reads datagrams until the listener is closed.
+func serveUDPListener(bl *bridgeListener) {
+	buf := make([]byte, 65536)
+	for {
+		n, src, err := bl.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				log.Printf("[UDP] closed: %s", bl.name)
+				return
+			}
+			log.Printf("[UDP] read error: %s: %v", bl.name, err)
+			continue
+		}
+		data := buf[:n]
+
+		// 下流のアプリへそのまま転送
+		forwardDatagram(data, bl.conn, src)
+
+		if isWSJTXDatagram(data) {
+			handleWSJTXDatagram(bl.name, data, bl.conn, src)
+			continue
+		}
 
-		grid := extractGridFromADIF(adif)
-		finalGrid := betterGrid(grid, qrzGrid)
+		processADIF(bl.name, string(data))
+	}
+}
+
+// handleWSJTXDatagram decodes a WSJT-X NetworkMessage and dispatches it.
+// Status, Decode and QSO Logged are broadcast as typed events; Logged ADIF
+// is fed into the same QRZ/geo/logbook pipeline as plain ADIF datagrams.
+// The sender is remembered so that commands can be sent back to it.
+func handleWSJTXDatagram(source string, data []byte, conn *net.UDPConn, src *net.UDPAddr) {
+	m, err := decodeWSJTX(data)
+	if err != nil {
+		log.Println("[WSJTX] decode error:", err)
+		return
+	}
+
+	switch m.Type {
+	case WSJTXHeartbeat, WSJTXStatus, WSJTXDecode:
+		rememberWSJTXPeer(m, conn, src)
+	}
 
-		jcc := ""
-		if useGeo && len(finalGrid) >= 6 {
-			jcc, _ = geoLookup(finalGrid)
+	switch m.Type {
+	case WSJTXHeartbeat:
+		log.Printf("[WSJTX] heartbeat: id=%s version=%s schema=%d", m.ID, m.Heartbeat.Version, m.Heartbeat.MaxSchema)
+
+	case WSJTXStatus:
+		s := m.Status
+		broadcast(&WSJTXStatusEvent{
+			Envelope:             newEnvelope("wsjtx_status", source),
+			ID:                   m.ID,
+			DialFreq:             s.DialFreq,
+			Mode:                 s.Mode,
+			SubMode:              s.SubMode,
+			DXCall:               s.DXCall,
+			DXGrid:               s.DXGrid,
+			Report:               s.Report,
+			TxMode:               s.TxMode,
+			TxEnabled:            s.TxEnabled,
+			Transmitting:         s.Transmitting,
+			Decoding:             s.Decoding,
+			RxDF:                 s.RxDF,
+			TxDF:                 s.TxDF,
+			DECall:               s.DECall,
+			DEGrid:               s.DEGrid,
+			TxWatchdog:           s.TxWatchdog,
+			FastMode:             s.FastMode,
+			SpecialOperationMode: s.SpecialOperationMode,
+			FrequencyTolerance:   s.FrequencyTolerance,
+			TRPeriod:             s.TRPeriod,
+			ConfigurationName:    s.ConfigurationName,
+			TxMessage:            s.TxMessage,
+		})
+
+	case WSJTXDecode:
+		d := m.Decode
+		broadcast(&WSJTXDecodeEvent{
+			Envelope:      newEnvelope("wsjtx_decode", source),
+			ID:            m.ID,
+			New:           d.New,
+			Time:          d.Time,
+			SNR:           d.SNR,
+			DeltaTime:     d.DeltaTime,
+			DeltaFreq:     d.DeltaFreq,
+			Mode:          d.Mode,
+			Message:       d.Message,
+			LowConfidence: d.LowConfidence,
+			OffAir:        d.OffAir,
+		})
+
+	case WSJTXQSOLogged:
+		q := m.QSOLogged
+		ev := &QSOLoggedEvent{
+			Envelope:         newEnvelope("qso_logged", source),
+			ID:               m.ID,
+			DXCall:           q.DXCall,
+			DXGrid:           q.DXGrid,
+			TxFreq:           q.TxFreq,
+			Mode:             q.Mode,
+			ReportSent:       q.ReportSent,
+			ReportReceived:   q.ReportReceived,
+			TxPower:          q.TxPower,
+			Comments:         q.Comments,
+			Name:             q.Name,
+			OperatorCall:     q.OperatorCall,
+			MyCall:           q.MyCall,
+			MyGrid:           q.MyGrid,
+			ExchangeSent:     q.ExchangeSent,
+			ExchangeReceived: q.ExchangeReceived,
+			PropMode:         q.ADIFPropagationMode,
 		}
+		if !q.TimeOn.IsZero() {
+			ev.TimeOn = q.TimeOn.Format(time.RFC3339)
+		}
+		if !q.TimeOff.IsZero() {
+			ev.TimeOff = q.TimeOff.Format(time.RFC3339)
+		}
+		broadcast(ev)
+
+	case WSJTXLoggedADIF:
+		processADIF(source, m.ADIF)
+
+	case WSJTXClose:
+		log.Printf("[WSJTX] close: id=%s", m.ID)
+	}
+}
+
+// processADIF parses an ADIF payload (one or more records) and passes each
+// QSO to processQSO. source is the name of the listener it arrived on.
+func processADIF(source, adif string) {
+	log.Println("[QRZ] adif :", adif)
+
+	qsos, err := parseQSOs(adif)
+	if err != nil {
+		log.Println("[BRIDGE] ADIF parse error:", err)
+		return
+	}
 
-		payload := ADIFEvent{
-			Type: "adif",
-			Adif: adif,
+	for _, q := range qsos {
+		if q.Call == "" || q.TimeOn.IsZero() {
+			continue
 		}
+		processQSO(source, q)
+	}
+}
 
-		if jcc != "" {
-			payload.Geo = &struct {
-				JCC string `json:"jcc"`
-			}{
-				JCC: jcc,
+// processQSO enriches a logged QSO with QRZ and geo data, broadcasts it to
+// WebSocket clients and submits it to the online logbooks.
+func processQSO(source string, q *QSO) {
+	call := q.Call
+
+	var qrzQTH, qrzGrid, qrzState, qrzDXCC string
+
+	configLock.RLock()
+	useQRZ := config.UseQRZ
+	useGeo := config.UseGeo
+	enrichPolicy := config.Enrich
+	configLock.RUnlock()
+
+	qrzOperator := ""
+
+	log.Println("[BRIDGE] QRZ enabled:", useQRZ, "call:", call)
+	if useQRZ && call != "" {
+
+		var qrz *qrzCall
+		portable := isPortableCall(call)
+
+		// ① キャッシュ確認
+		if cached, ok := qrzc.get(call); ok {
+			log.Println("[QRZ] cache hit:", call)
+			qrz = cached
+		} else {
+			log.Println("[QRZ] cache miss, lookup:", call)
+			if ensureQRZLogin() == nil {
+				if r, err := qrzLookup(qrzKey, call); err == nil {
+					qrz = r
+					qrzc.set(call, r)
+					log.Println("[QRZ] cache store:", call)
+				} else {
+					log.Println("[BRIDGE] QRZ lookup error:", err)
+				}
 			}
 		}
 
-		if qrzQTH != "" || qrzGrid != "" || qrzOperator != "" {
-			payload.QRZ = &struct {
-				QTH      string `json:"qth"`
-				Grid     string `json:"grid"`
-				Operator string `json:"operator"`
-			}{
-				QTH:      qrzQTH,
-				Grid:     finalGrid,
-				Operator: qrzOperator,
+		if qrz != nil {
+			log.Println("[BRIDGE] QRZ used")
+
+			// ★ NAME → operator（/P でも使う）
+			fullName := strings.TrimSpace(qrz.Fname + " " + qrz.Name)
+			if fullName != "" {
+				qrzOperator = fullName
+			}
+
+			// ★ /P 等は QTH / Grid / STATE / DXCC を使わない
+			if !portable {
+				qrzQTH = qrz.Addr2
+				qrzState = qrz.State
+				qrzDXCC = qrz.DXCC
+
+				if usableQRZGrid(qrz.Grid) {
+					qrzGrid = qrz.Grid
+				}
 			}
 		}
+	}
 
-		b, _ := json.Marshal(payload)
-		broadcast(string(b))
+	grid := q.Gridsquare
+	finalGrid := betterGrid(grid, qrzGrid)
 
-		// Logbookへ非同期送信
-		go submitLogbookAsync(adif)
+	jcc := ""
+	if useGeo && len(finalGrid) >= 6 {
+		jcc, _ = geoLookup(finalGrid)
+	}
 
+	payload := &ADIFEvent{
+		Envelope: newEnvelope("adif", source),
+		Adif:     q.adif(),
+		QSO:      q,
 	}
-}
 
-// extractGridFromADIF takes an ADIF string and returns the grid extracted from it.
-// The grid is extracted by searching for a substring that matches the regular expression
-// `<GRIDSQUARE:\d+>([A-Za-z0-9]+)`. If a match is found, the second group of the match
-// (i.e. the grid itself) is returned. If no match is found, an empty string is returned.
-func extractGridFromADIF(adif string) string {
-	m := reGrid.FindStringSubmatch(adif)
-	if len(m) > 1 {
-		return strings.ToUpper(m[1])
+	if jcc != "" {
+		payload.Geo = &ADIFGeoInfo{
+			JCC: jcc,
+		}
 	}
-	return ""
-}
 
-// extractCall takes an ADIF string and returns the callsign extracted from it.
-// The callsign is extracted by searching for a substring that matches the regular expression
-// `<CALLSIGN:\d+>([A-Za-z0-9]+)`. If a match is found, the second group of the match
-// (i.e. the callsign itself) is returned and converted to uppercase. If no match is found, an empty string is returned.
-func extractCall(adif string) string {
-	m := reCall.FindStringSubmatch(adif)
-	if len(m) > 1 {
-		return strings.ToUpper(m[1])
+	if qrzQTH != "" || qrzGrid != "" || qrzOperator != "" {
+		payload.QRZ = &ADIFQRZInfo{
+			QTH:      qrzQTH,
+			Grid:     finalGrid,
+			Operator: qrzOperator,
+		}
 	}
-	return ""
-}
 
-// hasQSODate takes an ADIF string and returns true if the string contains a QSO date (in the format
-// <YYYYMMDD>), and false otherwise.
-// The QSO date is extracted by searching for a substring that matches the regular expression
-// `<\d{8}>`. If a match is found, true is returned. If no match is found, false is returned.
-func hasQSODate(adif string) bool {
-	return reQSODate.MatchString(adif)
+	broadcast(payload)
+
+	// 補完した情報をアップロード用ADIFへ反映
+	upload := enrichQSO(q, enrichment{
+		Name:  qrzOperator,
+		QTH:   qrzQTH,
+		Grid:  qrzGrid,
+		JCC:   jcc,
+		State: qrzState,
+		DXCC:  qrzDXCC,
+	}, enrichPolicy)
+
+	// ジャーナルへ記録（QSL確認の照合にも使う）
+	journalID := qsoJournal.append(&JournalEntry{
+		ReceivedAt: time.Now().UTC(),
+		Source:     source,
+		QSO:        q,
+		ADIF:       payload.Adif,
+		UploadADIF: upload.adif(),
+		Enrichment: &JournalEnrichment{
+			Operator: qrzOperator,
+			QTH:      qrzQTH,
+			Grid:     qrzGrid,
+			State:    qrzState,
+			DXCC:     qrzDXCC,
+			JCC:      jcc,
+		},
+	})
+
+	// Logbookへ非同期送信
+	go submitLogbookAsync(upload, journalID)
 }
 
 // betterGrid takes two grids and returns the better one.
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	LogbookCloudlog = "cloudlog"
+	LogbookWavelog  = "wavelog"
+)
+
+// cloudlogUploader sends QSOs to a self-hosted Cloudlog or Wavelog
+// instance through its /api/qso endpoint. Both share the same API.
+type cloudlogUploader struct {
+	typ  string
+	name string
+}
+
+func (u cloudlogUploader) Type() string { return u.typ }
+func (u cloudlogUploader) Name() string { return u.name }
+
+func (u cloudlogUploader) Fields() []UploaderField {
+	return []UploaderField{
+		{Key: "url", Label: "URL", Option: true, Required: true, Placeholder: "https://log.example.jp"},
+		{Key: "api_key", Label: "API Key（読み書き）", Secret: true, Required: true},
+		{Key: "station_profile_id", Label: "Station Profile ID", Option: true, Required: true, Placeholder: "1"},
+	}
+}
+
+// cloudlogRequest is the body of POST /api/qso.
+type cloudlogRequest struct {
+	Key              string `json:"key"`
+	StationProfileID string `json:"station_profile_id"`
+	Type             string `json:"type"` // "adif"
+	String           string `json:"string"`
+}
+
+// cloudlogResponse covers the replies of Cloudlog and Wavelog. Wavelog adds
+// import counters and per-record messages (e.g. duplicates).
+type cloudlogResponse struct {
+	Status      string   `json:"status"` // "created" / "failed" / "abort"
+	Reason      string   `json:"reason"`
+	ImportCount *int     `json:"adif_count"`
+	ErrorCount  int      `json:"adif_errors"`
+	Messages    []string `json:"messages"`
+}
+
+func (u cloudlogUploader) Upload(adif string, c LogbookConfig) (res LogbookResult) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[LOGBOOK] %s panic: %v", u.name, r)
+			res = LogbookResult{Service: u.typ, Status: LogbookTransient, Message: fmt.Sprintf("panic: %v", r)}
+		}
+	}()
+
+	log.Printf("[LOGBOOK] %s: sending...", u.name)
+
+	body, _ := json.Marshal(cloudlogRequest{
+		Key:              c.Credentials["api_key"],
+		StationProfileID: strings.TrimSpace(c.Options["station_profile_id"]),
+		Type:             "adif",
+		String:           adif,
+	})
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(cloudlogAPIURL(c.Options["url"]), "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("[LOGBOOK] %s error: %v", u.name, err)
+		return transientResult(u.typ, err)
+	}
+	defer resp.Body.Close()
+
+	responseText := readLogbookResponse(resp)
+	res = parseCloudlogResult(u.typ, resp, responseText)
+	log.Printf("[LOGBOOK] %s: %s (HTTP %d)", u.name, res, resp.StatusCode)
+	return res
+}
+
+// cloudlogAPIURL returns the QSO API endpoint for the configured instance URL.
+// Both "https://host/cloudlog" and a full ".../api/qso" URL are accepted.
+func cloudlogAPIURL(base string) string {
+	base = strings.TrimRight(strings.TrimSpace(base), "/")
+	if strings.HasSuffix(base, "/api/qso") {
+		return base
+	}
+	base = strings.TrimSuffix(base, "/index.php")
+	return base + "/index.php/api/qso"
+}
+
+// parseCloudlogResult classifies a Cloudlog/Wavelog JSON reply.
+func parseCloudlogResult(service string, resp *http.Response, body string) LogbookResult {
+	var cr cloudlogResponse
+	if err := json.Unmarshal([]byte(body), &cr); err != nil || cr.Status == "" {
+		r := classifyHTTPStatus(service, resp, body)
+		if r.Status == LogbookOK {
+			r.Status = LogbookTransient
+			r.Message = "unexpected response: " + summarizeResponse(body)
+		}
+		return r
+	}
+
+	r := LogbookResult{Service: service, HTTPStatus: resp.StatusCode, Message: cr.Reason}
+	if len(cr.Messages) > 0 {
+		r.Message = strings.Join(cr.Messages, "; ")
+	}
+	lower := strings.ToLower(r.Message)
+
+	switch {
+	case strings.Contains(lower, "duplicate"):
+		r.Status = LogbookDuplicate
+	case resp.StatusCode == http.StatusUnauthorized, strings.Contains(lower, "api key"),
+		strings.Contains(lower, "rights"), strings.Contains(lower, "station id"),
+		strings.Contains(lower, "station profile"):
+		// キー・ステーションプロファイルの誤りは設定の修正が必要
+		r.Status = LogbookAuthError
+	case cr.Status == "created" && cr.ErrorCount == 0 && (cr.ImportCount == nil || *cr.ImportCount > 0):
+		r.Status = LogbookOK
+	case resp.StatusCode >= 500:
+		r.Status = LogbookTransient
+	default:
+		r.Status = LogbookFatal
+	}
+	if r.Message == "" {
+		r.Message = cr.Status
+	}
+	return r
+}
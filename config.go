@@ -8,28 +8,54 @@ import (
 )
 
 type RigPortConfig struct {
-	Port string `json:"port"`
-	Baud int    `json:"baud"`
+	Port    string `json:"port"`
+	Baud    int    `json:"baud"`
+	Profile string `json:"profile,omitempty"`  // "auto"（既定）/ yaesu / yaesu-legacy / kenwood / elecraft / icom
+	CIVAddr byte   `json:"civ_addr,omitempty"` // 使う無線機の CI-V アドレス（0 = 自動）
 }
 
-type Config struct {
-	QRZUser string `json:"qrz_user"`
-	QRZPass string `json:"qrz_pass"`
+// UDPListenerConfig is a UDP endpoint the bridge receives WSJT-X/JTDX/ADIF
+// datagrams on. A multicast group address (e.g. 224.0.0.1:2237) joins the
+// group on Interface, or on the system default interface when empty.
+type UDPListenerConfig struct {
+	Name      string `json:"name"`      // ADIFEvent.source に載る名前
+	Address   string `json:"address"`   // host:port
+	Interface string `json:"interface"` // マルチキャスト受信インターフェース
+}
 
-	UseQRZ bool `json:"use_qrz"`
-	UseGeo bool `json:"use_geo"`
+// UDPForwardConfig is a downstream program every received datagram is
+// relayed to unchanged. Types is a comma separated list of message type
+// names (e.g. "decode,status,logged_adif"); empty relays everything.
+type UDPForwardConfig struct {
+	Target string `json:"target"` // host:port
+	Types  string `json:"types"`
+}
 
-	UseRig  bool   `json:"use_rig"`
-	RigPort string `json:"rig_port"`
-	RigBaud int    `json:"rig_baud"`
-	UsePTY  bool   `json:"use_pty"`
+// EnrichConfig holds, per ADIF field, how looked-up QRZ/geo data is merged
+// into the record uploaded to the online logbooks: "off", "fill" (only if
+// the field is empty) or "overwrite".
+type EnrichConfig struct {
+	Name  string `json:"name"`
+	QTH   string `json:"qth"`
+	Grid  string `json:"grid"`
+	Cnty  string `json:"cnty"` // JCC
+	State string `json:"state"`
+	DXCC  string `json:"dxcc"`
+}
 
-	// 複数ポート対応
-	RigPorts         []RigPortConfig `json:"rig_ports"`
-	RigBroadcastMode string          `json:"rig_broadcast_mode"` // "single" or "all"
-	SelectedRigIndex int             `json:"selected_rig_index"` // "single"モード時のインデックス
+// LogbookConfig is one online logbook QSOs are uploaded to. The keys of
+// Credentials and Options are defined by the Fields of the Uploader
+// registered for Type.
+type LogbookConfig struct {
+	Type        string            `json:"type"`
+	Enabled     bool              `json:"enabled"`
+	Credentials map[string]string `json:"credentials,omitempty"`
+	Options     map[string]string `json:"options,omitempty"`
+}
 
-	// Logbook連携
+// legacyLogbookConfig holds the per-service fields used before the
+// logbooks list. It is only read to migrate old config files.
+type legacyLogbookConfig struct {
 	LogbookQRZAPIKey      string `json:"logbook_qrz_apikey"`
 	LogbookQRZEnabled     bool   `json:"logbook_qrz_enabled"`
 	LogbookHamQTHCallsign string `json:"logbook_hamqth_callsign"`
@@ -49,6 +75,76 @@ type Config struct {
 	LogbookClubLogEnabled bool   `json:"logbook_clublog_enabled"`
 }
 
+// logbooks converts the legacy fields into the logbooks list.
+func (l legacyLogbookConfig) logbooks() []LogbookConfig {
+	return []LogbookConfig{
+		{Type: LogbookQRZ, Enabled: l.LogbookQRZEnabled,
+			Credentials: map[string]string{"api_key": l.LogbookQRZAPIKey}},
+		{Type: LogbookHamQTH, Enabled: l.LogbookHamQTHEnabled,
+			Credentials: map[string]string{"user": l.LogbookHamQTHUser, "pass": l.LogbookHamQTHPass},
+			Options:     map[string]string{"callsign": l.LogbookHamQTHCallsign}},
+		{Type: LogbookEQSL, Enabled: l.LogbookEQSLEnabled,
+			Credentials: map[string]string{"user": l.LogbookEQSLUser, "pass": l.LogbookEQSLPass}},
+		{Type: LogbookHRDLog, Enabled: l.LogbookHRDLogEnabled,
+			Credentials: map[string]string{"upload_code": l.LogbookHRDLogCode},
+			Options:     map[string]string{"callsign": l.LogbookHRDLogCallsign}},
+		{Type: LogbookClubLog, Enabled: l.LogbookClubLogEnabled,
+			Credentials: map[string]string{"email": l.LogbookClubLogEmail, "password": l.LogbookClubLogPass, "api_key": l.LogbookClubLogAPI},
+			Options:     map[string]string{"callsign": l.LogbookClubLogCall}},
+	}
+}
+
+type Config struct {
+	QRZUser string `json:"qrz_user"`
+	QRZPass string `json:"qrz_pass"`
+
+	UseQRZ bool `json:"use_qrz"`
+	UseGeo bool `json:"use_geo"`
+
+	// アップロード用ADIFへの補完ポリシー
+	Enrich EnrichConfig `json:"enrich"`
+
+	// UDP受信（複数対応）
+	Listeners []UDPListenerConfig `json:"listeners"`
+
+	// UDP転送（GridTracker / JTAlert / Log4OM 等へ）
+	Forwards []UDPForwardConfig `json:"forwards"`
+
+	UseRig  bool   `json:"use_rig"`
+	RigPort string `json:"rig_port"`
+	RigBaud int    `json:"rig_baud"`
+	UsePTY  bool   `json:"use_pty"`
+
+	// 複数ポート対応
+	RigPorts         []RigPortConfig `json:"rig_ports"`
+	RigBroadcastMode string          `json:"rig_broadcast_mode"` // "single" or "all"
+	SelectedRigIndex int             `json:"selected_rig_index"` // "single"モード時のインデックス
+
+	// rigctld 互換サーバー（ポート i は RigctldPort+i で待ち受け）
+	Rigctld     bool `json:"rigctld"`
+	RigctldPort int  `json:"rigctld_port"`
+
+	// ブリッジ経由で送信にした場合の最大送信時間（秒、超えると受信に戻す）
+	MaxTXSeconds int `json:"max_tx_seconds"`
+
+	// Logbook連携（アップローダーごとに1件）
+	Logbooks []LogbookConfig `json:"logbooks"`
+
+	// QSL確認の定期取得（LoTW / eQSL / QRZ / ClubLog）
+	QSLSync bool `json:"qsl_sync"`
+
+	// WebSocket / API への接続を許可する Origin（未設定は hamlab.jp）
+	AllowedOrigins []string `json:"allowed_origins,omitempty"`
+	// クライアントが提示するペアリングトークン（初回起動時に生成）
+	PairingToken string `json:"pairing_token"`
+}
+
+// maxListeners is the number of UDP listener rows shown in the settings UI.
+const maxListeners = 3
+
+// maxForwards is the number of UDP forwarding rows shown in the settings UI.
+const maxForwards = 3
+
 var (
 	config     Config
 	configLock sync.RWMutex
@@ -64,6 +160,28 @@ func loadConfig() {
 		_ = json.Unmarshal(b, &config)
 	}
 
+	// 後方互換性: 旧 logbook_* フィールドを Logbooks にマイグレーション
+	if config.Logbooks == nil && err == nil {
+		var legacy legacyLogbookConfig
+		if json.Unmarshal(b, &legacy) == nil {
+			config.Logbooks = legacy.logbooks()
+		}
+	}
+
+	// 登録済みアップローダーごとに1件（未設定のものは無効で追加）
+	for _, u := range uploaders {
+		found := false
+		for _, lc := range config.Logbooks {
+			if lc.Type == u.Type() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			config.Logbooks = append(config.Logbooks, LogbookConfig{Type: u.Type()})
+		}
+	}
+
 	if config.RigBaud == 0 {
 		config.RigBaud = 9600
 	}
@@ -88,10 +206,46 @@ func loadConfig() {
 		config.RigPorts = append(config.RigPorts, RigPortConfig{Baud: 9600})
 	}
 
+	// UDP受信: 未設定の場合は従来の 127.0.0.1:2333
+	if config.Listeners == nil {
+		config.Listeners = []UDPListenerConfig{
+			{Name: "WSJT-X", Address: "127.0.0.1:2333"},
+		}
+	}
+	for len(config.Listeners) < maxListeners {
+		config.Listeners = append(config.Listeners, UDPListenerConfig{})
+	}
+
+	for len(config.Forwards) < maxForwards {
+		config.Forwards = append(config.Forwards, UDPForwardConfig{})
+	}
+
+	// 補完ポリシー: 未設定は "fill"
+	for _, p := range []*string{
+		&config.Enrich.Name, &config.Enrich.QTH, &config.Enrich.Grid,
+		&config.Enrich.Cnty, &config.Enrich.State, &config.Enrich.DXCC,
+	} {
+		if *p == "" {
+			*p = EnrichFill
+		}
+	}
+
 	// デフォルト値
 	if config.RigBroadcastMode == "" {
 		config.RigBroadcastMode = "all"
 	}
+	if config.RigctldPort == 0 {
+		config.RigctldPort = rigctldDefaultPort
+	}
+	if config.MaxTXSeconds <= 0 {
+		config.MaxTXSeconds = defaultMaxTXSeconds
+	}
+
+	// ペアリングトークン: 未設定なら生成して保存
+	if config.PairingToken == "" {
+		config.PairingToken = newToken()
+		saveConfig()
+	}
 }
 
 // saveConfig saves the current configuration to a file named
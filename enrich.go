@@ -0,0 +1,69 @@
+package main
+
+import (
+	"log"
+	"strings"
+)
+
+// 補完ポリシー
+const (
+	EnrichOff       = "off"
+	EnrichFill      = "fill"
+	EnrichOverwrite = "overwrite"
+)
+
+// enrichment is the data the bridge looked up for a QSO.
+type enrichment struct {
+	Name  string // QRZ fname + name
+	QTH   string // QRZ addr2
+	Grid  string // QRZ grid（6桁以上のみ）
+	JCC   string // geo lookup
+	State string // QRZ state
+	DXCC  string // QRZ dxcc
+}
+
+// enrichQSO returns a copy of q with the looked-up data merged in according
+// to the per-field policies. q itself is not modified.
+func enrichQSO(q *QSO, e enrichment, p EnrichConfig) *QSO {
+	out := *q
+	out.Extra = append([]ADIFField(nil), q.Extra...)
+
+	applyEnrichPolicy(&out.Name, e.Name, p.Name)
+	applyEnrichPolicy(&out.QTH, e.QTH, p.QTH)
+	applyEnrichPolicy(&out.Cnty, e.JCC, p.Cnty)
+	applyEnrichPolicy(&out.State, e.State, p.State)
+	applyEnrichPolicy(&out.DXCC, e.DXCC, p.DXCC)
+
+	// GRIDSQUARE: fill は betterGrid による精度向上も含む
+	switch p.Grid {
+	case EnrichFill:
+		out.Gridsquare = betterGrid(out.Gridsquare, e.Grid)
+	case EnrichOverwrite:
+		if e.Grid != "" {
+			out.Gridsquare = e.Grid
+		}
+	}
+
+	if out.adif() != q.adif() {
+		log.Printf("[ENRICH] %s: name=%q qth=%q grid=%q cnty=%q state=%q dxcc=%q",
+			out.Call, out.Name, out.QTH, out.Gridsquare, out.Cnty, out.State, out.DXCC)
+	}
+
+	return &out
+}
+
+// applyEnrichPolicy writes v into *dst according to policy.
+func applyEnrichPolicy(dst *string, v, policy string) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return
+	}
+	switch policy {
+	case EnrichFill:
+		if strings.TrimSpace(*dst) == "" {
+			*dst = v
+		}
+	case EnrichOverwrite:
+		*dst = v
+	}
+}
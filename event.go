@@ -1,37 +1,145 @@
 package main
 
+// ADIFEvent is broadcast for every QSO received as ADIF, with the result
+// of the QRZ/geo lookup.
 type ADIFEvent struct {
-	Type string `json:"type"` // "adif"
-	Adif string `json:"adif"`
+	Envelope        // type: "adif", source: 受信したリスナー名
+	Adif     string `json:"adif"`
+	QSO      *QSO   `json:"qso,omitempty"`
 
-	QRZ *struct {
-		QTH      string `json:"qth"`
-		Grid     string `json:"grid"`
-		Operator string `json:"operator"`
-	} `json:"qrz,omitempty"`
+	QRZ *ADIFQRZInfo `json:"qrz,omitempty"`
+	Geo *ADIFGeoInfo `json:"geo,omitempty"`
+}
+
+// ADIFQRZInfo is what the QRZ.com lookup found for the station worked.
+type ADIFQRZInfo struct {
+	QTH      string `json:"qth"`
+	Grid     string `json:"grid"`
+	Operator string `json:"operator"`
+}
 
-	Geo *struct {
-		JCC string `json:"jcc"`
-	} `json:"geo,omitempty"`
+// ADIFGeoInfo is the JCC/JCG found from the grid locator.
+type ADIFGeoInfo struct {
+	JCC string `json:"jcc"`
 }
 
+// RigEvent is broadcast when the frequency or mode of a rig changes.
 type RigEvent struct {
-	Type string `json:"type"` // "rig"
-	Rig  string `json:"rig"`  // ICOM / YAESU / KENWOOD
-	Freq int64  `json:"freq,omitempty"`
-	Mode string `json:"mode,omitempty"`
+	Envelope          // type: "rig"
+	Rig      RigProto `json:"rig"`            // CAT / CI-V
+	Port     *int     `json:"port,omitempty"` // ポートインデックス
+	Freq     int64    `json:"freq,omitempty"` // Hz
+	Mode     string   `json:"mode,omitempty"`
+	Data     *bool    `json:"data,omitempty"` // mode がある場合のみ
+	PTT      *bool    `json:"ptt,omitempty"`  // 送信中
 }
 
-type Payload struct {
-	Adif string `json:"adif"`
+// RigConnectionEvent is broadcast when a rig port is opened, and when it
+// is lost (cable unplugged, rig powered off) or closed by a restart.
+type RigConnectionEvent struct {
+	Envelope        // type: "rigConnected" / "rigDisconnected"
+	Port     int    `json:"port"`
+	Device   string `json:"device"`
+	Reason   string `json:"reason,omitempty"` // rigDisconnected の理由
+}
 
-	QRZ *struct {
-		QTH      string `json:"qth"`
-		Grid     string `json:"grid"`
-		Operator string `json:"operator"`
-	} `json:"qrz,omitempty"`
+// PTYEvent is broadcast when the PTY paths of the rig ports change.
+type PTYEvent struct {
+	Envelope          // type: "pty"
+	Paths    []string `json:"paths"`
+}
+
+// WSJTXStatusEvent is broadcast for every WSJT-X/JTDX Status message.
+type WSJTXStatusEvent struct {
+	Envelope                    // type: "wsjtx_status", source: 受信したリスナー名
+	ID                   string `json:"id"`
+	DialFreq             uint64 `json:"dialFreq"`
+	Mode                 string `json:"mode"`
+	SubMode              string `json:"subMode,omitempty"`
+	DXCall               string `json:"dxCall"`
+	DXGrid               string `json:"dxGrid"`
+	Report               string `json:"report"`
+	TxMode               string `json:"txMode"`
+	TxEnabled            bool   `json:"txEnabled"`
+	Transmitting         bool   `json:"transmitting"`
+	Decoding             bool   `json:"decoding"`
+	RxDF                 uint32 `json:"rxDF"`
+	TxDF                 uint32 `json:"txDF"`
+	DECall               string `json:"deCall"`
+	DEGrid               string `json:"deGrid"`
+	TxWatchdog           bool   `json:"txWatchdog"`
+	FastMode             bool   `json:"fastMode"`
+	SpecialOperationMode uint8  `json:"specialOperationMode"`
+	FrequencyTolerance   uint32 `json:"frequencyTolerance,omitempty"`
+	TRPeriod             uint32 `json:"trPeriod,omitempty"`
+	ConfigurationName    string `json:"configurationName,omitempty"`
+	TxMessage            string `json:"txMessage,omitempty"`
+}
+
+// WSJTXDecodeEvent is broadcast for every decode reported by WSJT-X/JTDX.
+// Time is milliseconds since midnight UTC, as sent by WSJT-X.
+type WSJTXDecodeEvent struct {
+	Envelope              // type: "wsjtx_decode", source: 受信したリスナー名
+	ID            string  `json:"id"`
+	New           bool    `json:"new"`
+	Time          uint32  `json:"time"`
+	SNR           int32   `json:"snr"`
+	DeltaTime     float64 `json:"deltaTime"`
+	DeltaFreq     uint32  `json:"deltaFreq"`
+	Mode          string  `json:"mode"`
+	Message       string  `json:"message"`
+	LowConfidence bool    `json:"lowConfidence"`
+	OffAir        bool    `json:"offAir"`
+}
+
+// QSOLoggedEvent is broadcast when WSJT-X/JTDX sends a QSO Logged message.
+type QSOLoggedEvent struct {
+	Envelope                // type: "qso_logged", source: 受信したリスナー名
+	ID               string `json:"id"`
+	TimeOn           string `json:"timeOn,omitempty"`
+	TimeOff          string `json:"timeOff,omitempty"`
+	DXCall           string `json:"dxCall"`
+	DXGrid           string `json:"dxGrid"`
+	TxFreq           uint64 `json:"txFreq"`
+	Mode             string `json:"mode"`
+	ReportSent       string `json:"reportSent"`
+	ReportReceived   string `json:"reportReceived"`
+	TxPower          string `json:"txPower"`
+	Comments         string `json:"comments"`
+	Name             string `json:"name"`
+	OperatorCall     string `json:"operatorCall,omitempty"`
+	MyCall           string `json:"myCall,omitempty"`
+	MyGrid           string `json:"myGrid,omitempty"`
+	ExchangeSent     string `json:"exchangeSent,omitempty"`
+	ExchangeReceived string `json:"exchangeReceived,omitempty"`
+	PropMode         string `json:"propMode,omitempty"`
+}
+
+// LogbookResultEvent is broadcast after every upload attempt of an outbox
+// entry. Retry is true when the entry stays queued for another attempt.
+type LogbookResultEvent struct {
+	Envelope         // type: "logbookResult"
+	ID        string `json:"id"` // outbox エントリID
+	JournalID uint64 `json:"journalId,omitempty"`
+	Service   string `json:"service"`
+	Call      string `json:"call"`
+	TimeOn    string `json:"timeOn,omitempty"`
+	Status    string `json:"status"` // ok / duplicate / auth / rate_limited / transient / fatal
+	Message   string `json:"message,omitempty"`
+	Attempts  int    `json:"attempts"`
+	Retry     bool   `json:"retry"`
+	NextTry   string `json:"nextTry,omitempty"`
+}
 
-	Geo *struct {
-		JCC string `json:"jcc"`
-	} `json:"geo,omitempty"`
+// QSLConfirmedEvent is broadcast when a downloaded confirmation matches a
+// QSO the bridge has relayed.
+type QSLConfirmedEvent struct {
+	Envelope           // type: "qslConfirmed"
+	JournalID   uint64 `json:"journalId"`
+	Service     string `json:"service"` // lotw / eqsl / qrz / clublog
+	Call        string `json:"call"`
+	Band        string `json:"band,omitempty"`
+	Mode        string `json:"mode,omitempty"`
+	TimeOn      string `json:"timeOn"`
+	ConfirmedAt string `json:"confirmedAt"`
 }
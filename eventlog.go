@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const eventLogFile = "event_log.json"
+
+const (
+	eventRingSize    = 1000 // 全イベントの保持数（メモリのみ）
+	eventADIFRingLen = 200  // ADIF イベントの保持数（再起動後も保持）
+	eventSeqReserve  = 1000 // 一度に予約する seq の数
+)
+
+// wsEvent is a broadcast message with its sequence number.
+type wsEvent struct {
+	Seq uint64 `json:"seq"`
+	Msg string `json:"msg"`
+
+	topic string     // 購読トピック
+	rig   *rigFields // rig イベントのフィルタ用
+}
+
+// eventLog numbers every broadcast event and keeps the recent ones so that
+// a reconnecting client can resume from the last seq it has seen.
+//
+// Sequence numbers stay monotonic across restarts: the persisted Reserved
+// value is always ahead of every seq handed out, and a restart continues
+// from it.
+type eventLog struct {
+	mu       sync.Mutex
+	seq      uint64
+	reserved uint64
+	recent   []wsEvent // リングバッファ
+	head     int
+	adif     []wsEvent // 永続化する ADIF イベント（古い順）
+}
+
+// eventLogState is the content of "event_log.json".
+type eventLogState struct {
+	Reserved uint64    `json:"reserved"`
+	ADIF     []wsEvent `json:"adif"`
+}
+
+// events is opened by openAppData.
+var events *eventLog
+
+func eventLogPath() string {
+	return filepath.Join(appDataDir(), eventLogFile)
+}
+
+// newEventLog loads the persisted ADIF events and reserves the next block
+// of sequence numbers.
+func newEventLog() *eventLog {
+	l := &eventLog{}
+	var st eventLogState
+	if b, err := os.ReadFile(eventLogPath()); err == nil {
+		if err := json.Unmarshal(b, &st); err != nil {
+			log.Println("[WS] event log load error:", err)
+		}
+	}
+	l.seq = st.Reserved
+	l.adif = st.ADIF
+	for i, ev := range l.adif {
+		l.adif[i].topic, l.adif[i].rig = describeEvent(ev.Msg)
+		if ev.Seq > l.seq {
+			l.seq = ev.Seq
+		}
+	}
+	l.reserved = l.seq + eventSeqReserve
+	l.save()
+	return l
+}
+
+// save writes the reserved seq and the ADIF events. The caller must hold
+// l.mu (or own l exclusively).
+func (l *eventLog) save() {
+	b, _ := json.Marshal(eventLogState{Reserved: l.reserved, ADIF: l.adif})
+	tmp := eventLogPath() + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		log.Println("[WS] event log save error:", err)
+		return
+	}
+	if err := os.Rename(tmp, eventLogPath()); err != nil {
+		log.Println("[WS] event log save error:", err)
+	}
+}
+
+// add numbers m, encodes it and stores it. queue is called with the event
+// under the lock that assigns its seq, so events are queued in seq order
+// even when several goroutines broadcast at once.
+func (l *eventLog) add(m Message, queue func(wsEvent)) wsEvent {
+	env := m.envelope()
+	env.V = EventVersion
+	if env.TS.IsZero() {
+		env.TS = time.Now().UTC()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.seq++
+	env.Seq = l.seq
+	b, _ := json.Marshal(m)
+	topic, rig := describeEvent(string(b))
+	ev := wsEvent{Seq: l.seq, Msg: string(b), topic: topic, rig: rig}
+
+	if len(l.recent) < eventRingSize {
+		l.recent = append(l.recent, ev)
+	} else {
+		l.recent[l.head] = ev
+		l.head = (l.head + 1) % eventRingSize
+	}
+
+	persist := false
+	if topic == TopicADIF {
+		l.adif = append(l.adif, ev)
+		if len(l.adif) > eventADIFRingLen {
+			l.adif = l.adif[len(l.adif)-eventADIFRingLen:]
+		}
+		persist = true
+	}
+	if l.seq >= l.reserved {
+		l.reserved = l.seq + eventSeqReserve
+		persist = true
+	}
+	if persist {
+		l.save()
+	}
+	if queue != nil {
+		queue(ev)
+	}
+	return ev
+}
+
+// since returns the retained events after seq in order, the current seq
+// and whether events after seq may have been dropped from the buffer.
+func (l *eventLog) since(seq uint64) (list []wsEvent, last uint64, gap bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	seen := map[uint64]bool{}
+	oldest := l.seq + 1
+	for i := range l.recent {
+		ev := l.recent[(l.head+i)%len(l.recent)]
+		if i == 0 {
+			oldest = ev.Seq
+		}
+		if ev.Seq > seq {
+			list = append(list, ev)
+			seen[ev.Seq] = true
+		}
+	}
+	// リングから押し出された ADIF イベントを補う
+	for _, ev := range l.adif {
+		if ev.Seq > seq && !seen[ev.Seq] {
+			list = append(list, ev)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Seq < list[j].Seq })
+
+	return list, l.seq, seq < l.seq && oldest > seq+1
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"strings"
+	"sync"
+)
+
+// forwardTarget relays raw datagrams received by the bridge to a downstream
+// program (GridTracker, JTAlert, Log4OM ...). Datagrams the downstream program
+// sends back (e.g. Reply or Highlight Callsign) are relayed to the WSJT-X
+// instance that sent the last forwarded datagram.
+type forwardTarget struct {
+	index int
+	cfg   UDPForwardConfig
+	addr  *net.UDPAddr
+	conn  *net.UDPConn
+	types map[string]bool // 空=全て
+
+	mu      sync.Mutex
+	stats   ForwardStats
+	srcConn *net.UDPConn // 返信の送り先（最後に転送したデータグラムの送信元）
+	srcAddr *net.UDPAddr
+}
+
+// ForwardStats holds the per-target counters shown in the settings UI.
+type ForwardStats struct {
+	Sent      uint64
+	Filtered  uint64
+	Errors    uint64
+	Replies   uint64
+	LastError string
+}
+
+var forwardTargets []*forwardTarget
+var forwardErrors = map[int]string{}
+var forwardTargetsMu sync.RWMutex
+
+// startForwarders opens a socket for every configured forwarding target.
+func startForwarders() {
+	configLock.RLock()
+	forwards := make([]UDPForwardConfig, len(config.Forwards))
+	copy(forwards, config.Forwards)
+	listeners := make([]UDPListenerConfig, len(config.Listeners))
+	copy(listeners, config.Listeners)
+	configLock.RUnlock()
+
+	forwardTargetsMu.Lock()
+	defer forwardTargetsMu.Unlock()
+
+	forwardErrors = map[int]string{}
+
+	for i, f := range forwards {
+		target := strings.TrimSpace(f.Target)
+		if target == "" {
+			continue
+		}
+
+		addr, err := net.ResolveUDPAddr("udp", target)
+		if err != nil {
+			log.Printf("[FORWARD] resolve error: %s: %v", target, err)
+			forwardErrors[i] = err.Error()
+			continue
+		}
+
+		// 自分自身のリスナーへの転送はループになるため拒否
+		if isOwnListener(addr, listeners) {
+			log.Printf("[FORWARD] skip %s: same as a bridge listener", target)
+			forwardErrors[i] = "ブリッジ自身の受信ポートには転送できません"
+			continue
+		}
+
+		conn, err := net.ListenUDP("udp", nil)
+		if err != nil {
+			log.Printf("[FORWARD] socket error: %s: %v", target, err)
+			forwardErrors[i] = err.Error()
+			continue
+		}
+
+		ft := &forwardTarget{
+			index: i,
+			cfg:   f,
+			addr:  addr,
+			conn:  conn,
+			types: parseForwardTypes(f.Types),
+		}
+		forwardTargets = append(forwardTargets, ft)
+		go ft.relayReplies()
+
+		log.Printf("[FORWARD] → %s (types: %s)", target, forwardTypesLabel(f.Types))
+	}
+}
+
+// stopForwarders closes all forwarding sockets.
+func stopForwarders() {
+	forwardTargetsMu.Lock()
+	defer forwardTargetsMu.Unlock()
+
+	for _, ft := range forwardTargets {
+		ft.conn.Close()
+	}
+	forwardTargets = nil
+}
+
+// restartForwarders reopens the forwarding sockets with the current configuration.
+func restartForwarders() {
+	log.Println("[FORWARD] restarting...")
+	stopForwarders()
+	startForwarders()
+}
+
+// forwardDatagram relays a datagram received on conn from src to every
+// forwarding target whose type filter accepts it. The datagram is sent
+// unchanged.
+func forwardDatagram(data []byte, conn *net.UDPConn, src *net.UDPAddr) {
+	forwardTargetsMu.RLock()
+	defer forwardTargetsMu.RUnlock()
+
+	if len(forwardTargets) == 0 {
+		return
+	}
+
+	msgType := datagramTypeName(data)
+
+	for _, ft := range forwardTargets {
+		if len(ft.types) > 0 && !ft.types[msgType] {
+			ft.mu.Lock()
+			ft.stats.Filtered++
+			ft.mu.Unlock()
+			continue
+		}
+
+		_, err := ft.conn.WriteToUDP(data, ft.addr)
+
+		ft.mu.Lock()
+		if err != nil {
+			ft.stats.Errors++
+			ft.stats.LastError = err.Error()
+		} else {
+			ft.stats.Sent++
+			ft.srcConn = conn
+			ft.srcAddr = src
+		}
+		ft.mu.Unlock()
+	}
+}
+
+// relayReplies sends datagrams coming back from the downstream program to
+// the original sender, until the forwarding socket is closed.
+func (ft *forwardTarget) relayReplies() {
+	buf := make([]byte, 65536)
+	for {
+		n, from, err := ft.conn.ReadFromUDP(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("[FORWARD] read error: %s: %v", ft.addr, err)
+			}
+			return
+		}
+		if !from.IP.Equal(ft.addr.IP) || from.Port != ft.addr.Port {
+			continue
+		}
+
+		ft.mu.Lock()
+		srcConn, srcAddr := ft.srcConn, ft.srcAddr
+		ft.mu.Unlock()
+		if srcConn == nil || srcAddr == nil {
+			continue
+		}
+
+		if _, err := srcConn.WriteToUDP(buf[:n], srcAddr); err != nil {
+			log.Printf("[FORWARD] reply relay error: %s → %s: %v", ft.addr, srcAddr, err)
+			continue
+		}
+
+		ft.mu.Lock()
+		ft.stats.Replies++
+		ft.mu.Unlock()
+	}
+}
+
+// getForwardStatus returns the counters and configuration error for each
+// forwarding row, indexed like Config.Forwards.
+func getForwardStatus(n int) (stats []ForwardStats, errs []string) {
+	forwardTargetsMu.RLock()
+	defer forwardTargetsMu.RUnlock()
+
+	stats = make([]ForwardStats, n)
+	errs = make([]string, n)
+	for _, ft := range forwardTargets {
+		if ft.index < n {
+			ft.mu.Lock()
+			stats[ft.index] = ft.stats
+			ft.mu.Unlock()
+		}
+	}
+	for i, e := range forwardErrors {
+		if i < n {
+			errs[i] = e
+		}
+	}
+	return
+}
+
+// datagramTypeName returns the filter name of a datagram: the WSJT-X message
+// type name (e.g. "decode", "logged_adif") or "adif" for plain ADIF text.
+func datagramTypeName(data []byte) string {
+	if t, ok := wsjtxTypeOf(data); ok {
+		return t.String()
+	}
+	return "adif"
+}
+
+// parseForwardTypes parses a comma separated list of message type names.
+// An empty list (or "all") matches every datagram.
+func parseForwardTypes(s string) map[string]bool {
+	types := map[string]bool{}
+	for _, t := range strings.Split(s, ",") {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" {
+			continue
+		}
+		if t == "all" {
+			return map[string]bool{}
+		}
+		types[t] = true
+	}
+	return types
+}
+
+func forwardTypesLabel(s string) string {
+	if len(parseForwardTypes(s)) == 0 {
+		return "all"
+	}
+	return s
+}
+
+// isOwnListener reports whether addr is one of the bridge's own listeners.
+func isOwnListener(addr *net.UDPAddr, listeners []UDPListenerConfig) bool {
+	for _, l := range listeners {
+		if strings.TrimSpace(l.Address) == "" {
+			continue
+		}
+		la, err := net.ResolveUDPAddr("udp", strings.TrimSpace(l.Address))
+		if err != nil {
+			continue
+		}
+		if la.Port == addr.Port && (la.IP.Equal(addr.IP) || la.IP.IsUnspecified()) {
+			return true
+		}
+	}
+	return false
+}
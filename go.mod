@@ -6,6 +6,9 @@ require (
 	github.com/creack/pty v1.1.24
 	github.com/gorilla/websocket v1.5.3
 	go.bug.st/serial v1.6.4
+	go.etcd.io/bbolt v1.3.9
+	golang.org/x/crypto v0.22.0
+	golang.org/x/sync v0.5.0
 	golang.org/x/sys v0.19.0
 )
 
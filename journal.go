@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const journalFile = "journal.db"
+
+// bbolt バケット。qsos は追記のみで、送信・QSL状態は status に分けて持つ
+var (
+	journalQSOBucket    = []byte("qsos")
+	journalStatusBucket = []byte("status")
+)
+
+// JournalEntry is one QSO recorded by the bridge, with the result of the
+// QRZ/geo lookup, the upload status per service and QSL confirmations.
+type JournalEntry struct {
+	ID         uint64             `json:"id"`
+	ReceivedAt time.Time          `json:"receivedAt"`
+	Source     string             `json:"source,omitempty"`
+	QSO        *QSO               `json:"qso"`
+	ADIF       string             `json:"adif"`                 // 受信したADIF
+	UploadADIF string             `json:"uploadAdif,omitempty"` // 補完後のアップロード用ADIF
+	Enrichment *JournalEnrichment `json:"enrichment,omitempty"`
+
+	JournalStatus
+}
+
+// JournalEnrichment is what the QRZ/geo lookup found for the QSO.
+type JournalEnrichment struct {
+	Operator string `json:"operator,omitempty"`
+	QTH      string `json:"qth,omitempty"`
+	Grid     string `json:"grid,omitempty"`
+	State    string `json:"state,omitempty"`
+	DXCC     string `json:"dxcc,omitempty"`
+	JCC      string `json:"jcc,omitempty"`
+}
+
+// JournalStatus is the mutable part of an entry, stored separately so that
+// the QSO records themselves are never rewritten.
+type JournalStatus struct {
+	Uploads       map[string]JournalUpload `json:"uploads,omitempty"`       // service → 送信状態
+	Confirmations map[string]time.Time     `json:"confirmations,omitempty"` // service → QSL確認日時
+}
+
+// JournalUpload is the latest upload state of a QSO for one service.
+type JournalUpload struct {
+	Status    string    `json:"status"` // queued / LogbookStatus
+	Message   string    `json:"message,omitempty"`
+	Attempts  int       `json:"attempts,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// journal is the embedded QSO store in the app data dir. All methods
+// accept a nil journal (the database could not be opened) and do nothing.
+type journal struct {
+	db *bolt.DB
+}
+
+// qsoJournal is opened by openAppData (nil until then, or if it fails).
+var qsoJournal *journal
+
+// openJournal opens "journal.db", creating the buckets if needed.
+func openJournal() *journal {
+	path := filepath.Join(appDataDir(), journalFile)
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 2 * time.Second})
+	if err != nil {
+		log.Println("[JOURNAL] open error:", err)
+		return nil
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(journalQSOBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(journalStatusBucket)
+		return err
+	})
+	if err != nil {
+		log.Println("[JOURNAL] init error:", err)
+		db.Close()
+		return nil
+	}
+	return &journal{db: db}
+}
+
+func journalKey(id uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, id)
+	return k
+}
+
+// append stores a new entry and returns its ID (0 on failure).
+func (j *journal) append(e *JournalEntry) uint64 {
+	if j == nil {
+		return 0
+	}
+	err := j.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(journalQSOBucket)
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		e.ID = id
+		v, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		return b.Put(journalKey(id), v)
+	})
+	if err != nil {
+		log.Println("[JOURNAL] append error:", err)
+		return 0
+	}
+	return e.ID
+}
+
+// get returns the entry with the given ID, or nil.
+func (j *journal) get(id uint64) *JournalEntry {
+	if j == nil || id == 0 {
+		return nil
+	}
+	var e *JournalEntry
+	_ = j.db.View(func(tx *bolt.Tx) error {
+		e = readJournalEntry(tx, journalKey(id), tx.Bucket(journalQSOBucket).Get(journalKey(id)))
+		return nil
+	})
+	return e
+}
+
+// forEach calls fn for every entry, newest first, until fn returns false.
+func (j *journal) forEach(fn func(e *JournalEntry) bool) {
+	if j == nil {
+		return
+	}
+	_ = j.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(journalQSOBucket).Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			if e := readJournalEntry(tx, k, v); e != nil && !fn(e) {
+				break
+			}
+		}
+		return nil
+	})
+}
+
+// readJournalEntry decodes an entry and merges its status.
+func readJournalEntry(tx *bolt.Tx, k, v []byte) *JournalEntry {
+	if v == nil {
+		return nil
+	}
+	var e JournalEntry
+	if err := json.Unmarshal(v, &e); err != nil {
+		log.Println("[JOURNAL] decode error:", err)
+		return nil
+	}
+	if s := tx.Bucket(journalStatusBucket).Get(k); s != nil {
+		_ = json.Unmarshal(s, &e.JournalStatus)
+	}
+	return &e
+}
+
+// updateStatus applies fn to the status of an entry.
+func (j *journal) updateStatus(id uint64, fn func(s *JournalStatus)) {
+	if j == nil || id == 0 {
+		return
+	}
+	err := j.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(journalStatusBucket)
+		var s JournalStatus
+		if v := b.Get(journalKey(id)); v != nil {
+			_ = json.Unmarshal(v, &s)
+		}
+		fn(&s)
+		v, err := json.Marshal(&s)
+		if err != nil {
+			return err
+		}
+		return b.Put(journalKey(id), v)
+	})
+	if err != nil {
+		log.Println("[JOURNAL] status error:", err)
+	}
+}
+
+// setUpload records the upload state of an entry for a service.
+func (j *journal) setUpload(id uint64, service string, u JournalUpload) {
+	j.updateStatus(id, func(s *JournalStatus) {
+		if s.Uploads == nil {
+			s.Uploads = map[string]JournalUpload{}
+		}
+		s.Uploads[service] = u
+	})
+}
+
+// setConfirmation records a QSL confirmation of an entry.
+func (j *journal) setConfirmation(id uint64, service string, t time.Time) {
+	j.updateStatus(id, func(s *JournalStatus) {
+		if s.Confirmations == nil {
+			s.Confirmations = map[string]time.Time{}
+		}
+		s.Confirmations[service] = t
+	})
+}
+
+// oldest returns the QSO time of the oldest entry, or the zero time.
+func (j *journal) oldest() time.Time {
+	var t time.Time
+	j.forEach(func(e *JournalEntry) bool {
+		if e.QSO != nil && !e.QSO.TimeOn.IsZero() && (t.IsZero() || e.QSO.TimeOn.Before(t)) {
+			t = e.QSO.TimeOn
+		}
+		return true
+	})
+	return t
+}
+
+// journalFilter selects entries for the REST API. Zero values match all.
+type journalFilter struct {
+	Call   string // 部分一致
+	Band   string
+	Mode   string // MODE または SUBMODE
+	Source string
+	From   time.Time
+	To     time.Time
+	Offset int
+	Limit  int // 0 = 無制限
+}
+
+func (f *journalFilter) match(e *JournalEntry) bool {
+	q := e.QSO
+	if q == nil {
+		return false
+	}
+	if f.Call != "" && !strings.Contains(strings.ToUpper(q.Call), strings.ToUpper(f.Call)) {
+		return false
+	}
+	if f.Band != "" && !strings.EqualFold(q.Band, f.Band) {
+		return false
+	}
+	if f.Mode != "" && !strings.EqualFold(q.Mode, f.Mode) && !strings.EqualFold(q.Submode, f.Mode) {
+		return false
+	}
+	if f.Source != "" && e.Source != f.Source {
+		return false
+	}
+	if !f.From.IsZero() && q.TimeOn.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !q.TimeOn.Before(f.To) {
+		return false
+	}
+	return true
+}
+
+// search returns the entries matching f, newest first, and the total
+// number of matches.
+func (j *journal) search(f journalFilter) (list []*JournalEntry, total int) {
+	list = []*JournalEntry{}
+	j.forEach(func(e *JournalEntry) bool {
+		if !f.match(e) {
+			return true
+		}
+		total++
+		if total > f.Offset && (f.Limit == 0 || len(list) < f.Limit) {
+			list = append(list, e)
+		}
+		return true
+	})
+	return
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	journalDefaultLimit = 100
+	journalMaxLimit     = 1000
+)
+
+// registerJournalAPI adds the QSO journal endpoints to the WebSocket server.
+// Like the WebSocket, they need an allowed Origin and the pairing token:
+//
+//	GET /api/qsos             検索（call, band, mode, source, from, to, limit, offset）
+//	GET /api/qsos/{id}        1件取得
+//	GET /api/qsos/export.adi  検索結果をADIFで出力（enriched=1 で補完後のADIF）
+func registerJournalAPI(mux *http.ServeMux) {
+	mux.HandleFunc("OPTIONS /api/", handleJournalPreflight)
+	mux.HandleFunc("GET /api/qsos", journalAuth(handleJournalList))
+	mux.HandleFunc("GET /api/qsos/export.adi", journalAuth(handleJournalExport))
+	mux.HandleFunc("GET /api/qsos/{id}", journalAuth(handleJournalGet))
+}
+
+// journalAuth rejects API requests without an allowed Origin and the
+// pairing token.
+func journalAuth(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		setJournalCORS(w, r)
+		if !clientAuthorized(w, r) {
+			return
+		}
+		h(w, r)
+	}
+}
+
+// handleJournalPreflight answers CORS preflight requests, which browsers
+// send before a request with an Authorization header.
+func handleJournalPreflight(w http.ResponseWriter, r *http.Request) {
+	if !originAllowed(r.Header.Get("Origin")) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	setJournalCORS(w, r)
+	w.Header().Set("Access-Control-Allow-Methods", "GET")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleJournalList(w http.ResponseWriter, r *http.Request) {
+	f, err := parseJournalFilter(r)
+	if err != nil {
+		writeJournalError(w, http.StatusBadRequest, err)
+		return
+	}
+	if f.Limit == 0 {
+		f.Limit = journalDefaultLimit
+	}
+	if f.Limit > journalMaxLimit {
+		f.Limit = journalMaxLimit
+	}
+
+	list, total := qsoJournal.search(f)
+	writeJournalJSON(w, map[string]interface{}{
+		"total":  total,
+		"offset": f.Offset,
+		"qsos":   list,
+	})
+}
+
+func handleJournalGet(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJournalError(w, http.StatusBadRequest, fmt.Errorf("invalid id: %s", r.PathValue("id")))
+		return
+	}
+	e := qsoJournal.get(id)
+	if e == nil {
+		writeJournalError(w, http.StatusNotFound, fmt.Errorf("QSO %d not found", id))
+		return
+	}
+	writeJournalJSON(w, e)
+}
+
+func handleJournalExport(w http.ResponseWriter, r *http.Request) {
+	f, err := parseJournalFilter(r)
+	if err != nil {
+		writeJournalError(w, http.StatusBadRequest, err)
+		return
+	}
+	enriched := r.URL.Query().Get("enriched") == "1"
+
+	list, _ := qsoJournal.search(f)
+
+	file := &ADIFFile{
+		HeaderText: "HAMLAB Bridge journal export " + time.Now().UTC().Format(time.RFC3339),
+		Header: []ADIFField{
+			{Name: "ADIF_VER", Value: "3.1.4"},
+			{Name: "PROGRAMID", Value: "HAMLAB Bridge"},
+		},
+	}
+	// 古い順に出力
+	for i := len(list) - 1; i >= 0; i-- {
+		e := list[i]
+		q := e.QSO
+		if enriched && e.UploadADIF != "" {
+			if qs, err := parseQSOs(e.UploadADIF); err == nil && len(qs) > 0 {
+				q = qs[0]
+			}
+		}
+		file.Records = append(file.Records, q.record())
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="hamlab-bridge.adi"`)
+	_, _ = w.Write([]byte(file.String()))
+}
+
+// parseJournalFilter reads the search parameters. from/to accept
+// RFC 3339 or YYYY-MM-DD (UTC); to is exclusive.
+func parseJournalFilter(r *http.Request) (journalFilter, error) {
+	q := r.URL.Query()
+	f := journalFilter{
+		Call:   strings.TrimSpace(q.Get("call")),
+		Band:   strings.TrimSpace(q.Get("band")),
+		Mode:   strings.TrimSpace(q.Get("mode")),
+		Source: q.Get("source"),
+	}
+
+	var err error
+	if f.From, err = parseJournalTime(q.Get("from")); err != nil {
+		return f, fmt.Errorf("invalid from: %w", err)
+	}
+	if f.To, err = parseJournalTime(q.Get("to")); err != nil {
+		return f, fmt.Errorf("invalid to: %w", err)
+	}
+	if v := q.Get("limit"); v != "" {
+		if f.Limit, err = strconv.Atoi(v); err != nil || f.Limit < 0 {
+			return f, fmt.Errorf("invalid limit: %s", v)
+		}
+	}
+	if v := q.Get("offset"); v != "" {
+		if f.Offset, err = strconv.Atoi(v); err != nil || f.Offset < 0 {
+			return f, fmt.Errorf("invalid offset: %s", v)
+		}
+	}
+	return f, nil
+}
+
+func parseJournalTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, s)
+}
+
+// setJournalCORS allows the web apps in the origin allowlist to call the
+// API from the browser.
+func setJournalCORS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Origin")
+	if o := r.Header.Get("Origin"); o != "" && originAllowed(o) {
+		w.Header().Set("Access-Control-Allow-Origin", o)
+	}
+}
+
+func writeJournalJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeJournalError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
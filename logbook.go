@@ -9,59 +9,138 @@ import (
 	"time"
 )
 
-// submitLogbookAsync は非同期でADIFを各オンラインログサービスに送信します
-func submitLogbookAsync(adif string) {
+// Logbookサービス名（LogbookConfig.Type / outboxのエントリに保存される）
+const (
+	LogbookQRZ     = "qrz"
+	LogbookHamQTH  = "hamqth"
+	LogbookEQSL    = "eqsl"
+	LogbookHRDLog  = "hrdlog"
+	LogbookClubLog = "clublog"
+)
+
+// submitLogbookAsync はQSOを有効な各オンラインログサービス向けにoutboxへ登録します。
+// 実際の送信はoutboxワーカーが行い、失敗時は再送されます。送信状態はジャーナルにも記録されます。
+func submitLogbookAsync(q *QSO, journalID uint64) {
 	configLock.RLock()
-	qrzEnabled := config.LogbookQRZEnabled
-	qrzAPIKey := config.LogbookQRZAPIKey
-	hamqthEnabled := config.LogbookHamQTHEnabled
-	hamqthCallsign := config.LogbookHamQTHCallsign
-	hamqthUser := config.LogbookHamQTHUser
-	hamqthPass := config.LogbookHamQTHPass
-	eqslEnabled := config.LogbookEQSLEnabled
-	eqslUser := config.LogbookEQSLUser
-	eqslPass := config.LogbookEQSLPass
-	hrdlogEnabled := config.LogbookHRDLogEnabled
-	hrdlogCall := config.LogbookHRDLogCallsign
-	hrdlogCode := config.LogbookHRDLogCode
-	clublogEnabled := config.LogbookClubLogEnabled
-	clublogEmail := config.LogbookClubLogEmail
-	clublogPass := config.LogbookClubLogPass
-	clublogCall := config.LogbookClubLogCall
-	clublogAPI := config.LogbookClubLogAPI
+	var types []string
+	for _, lc := range config.Logbooks {
+		if lc.ready() {
+			types = append(types, lc.Type)
+		}
+	}
 	configLock.RUnlock()
 
-	// QRZ Logbook
-	if qrzEnabled && qrzAPIKey != "" {
-		go submitQRZLogbook(adif, qrzAPIKey)
+	for _, typ := range types {
+		qsoJournal.setUpload(journalID, typ, JournalUpload{Status: "queued", UpdatedAt: time.Now().UTC()})
+		outboxQ.enqueue(typ, q, journalID)
+	}
+}
+
+// logbookConfig returns the current configuration of the given service.
+func logbookConfig(service string) (LogbookConfig, bool) {
+	configLock.RLock()
+	defer configLock.RUnlock()
+
+	for _, lc := range config.Logbooks {
+		if lc.Type == service {
+			return lc, true
+		}
 	}
+	return LogbookConfig{}, false
+}
 
-	// HamQTH
-	if hamqthEnabled && hamqthCallsign != "" && hamqthUser != "" && hamqthPass != "" {
-		go submitHamQTH(adif, hamqthCallsign, hamqthUser, hamqthPass)
+// sendLogbook sends one ADIF record to the given service with the current
+// credentials and returns the classified reply.
+func sendLogbook(service, adif string) LogbookResult {
+	u, ok := lookupUploader(service)
+	if !ok {
+		return LogbookResult{Service: service, Status: LogbookFatal, Message: "unknown service: " + service}
+	}
+	lc, ok := logbookConfig(service)
+	if !ok || !lc.ready() {
+		return LogbookResult{Service: service, Status: LogbookFatal, Message: "service disabled or credentials missing"}
 	}
+	return u.Upload(adif, lc)
+}
+
+type qrzUploader struct{}
+
+func (qrzUploader) Type() string { return LogbookQRZ }
+func (qrzUploader) Name() string { return "QRZ.com Logbook" }
+func (qrzUploader) Fields() []UploaderField {
+	return []UploaderField{
+		{Key: "api_key", Label: "API Key", Secret: true, Required: true},
+	}
+}
+func (qrzUploader) Upload(adif string, c LogbookConfig) LogbookResult {
+	return submitQRZLogbook(adif, c.Credentials["api_key"])
+}
+
+type hamqthUploader struct{}
+
+func (hamqthUploader) Type() string { return LogbookHamQTH }
+func (hamqthUploader) Name() string { return "HamQTH" }
+func (hamqthUploader) Fields() []UploaderField {
+	return []UploaderField{
+		{Key: "callsign", Label: "Callsign", Option: true, Required: true},
+		{Key: "user", Label: "ユーザー名", Required: true},
+		{Key: "pass", Label: "パスワード", Secret: true, Required: true},
+	}
+}
+func (hamqthUploader) Upload(adif string, c LogbookConfig) LogbookResult {
+	return submitHamQTH(adif, c.Options["callsign"], c.Credentials["user"], c.Credentials["pass"])
+}
 
-	// eQSL
-	if eqslEnabled && eqslUser != "" && eqslPass != "" {
-		go submitEQSL(adif, eqslUser, eqslPass)
+type eqslUploader struct{}
+
+func (eqslUploader) Type() string { return LogbookEQSL }
+func (eqslUploader) Name() string { return "eQSL.cc" }
+func (eqslUploader) Fields() []UploaderField {
+	return []UploaderField{
+		{Key: "user", Label: "ユーザー名", Required: true},
+		{Key: "pass", Label: "パスワード", Secret: true, Required: true},
 	}
+}
+func (eqslUploader) Upload(adif string, c LogbookConfig) LogbookResult {
+	return submitEQSL(adif, c.Credentials["user"], c.Credentials["pass"])
+}
 
-	// HRDLog
-	if hrdlogEnabled && hrdlogCall != "" && hrdlogCode != "" {
-		go submitHRDLog(adif, hrdlogCall, hrdlogCode)
+type hrdlogUploader struct{}
+
+func (hrdlogUploader) Type() string { return LogbookHRDLog }
+func (hrdlogUploader) Name() string { return "HRDLog.net" }
+func (hrdlogUploader) Fields() []UploaderField {
+	return []UploaderField{
+		{Key: "callsign", Label: "Callsign", Option: true, Required: true},
+		{Key: "upload_code", Label: "Upload Code", Secret: true, Required: true},
 	}
+}
+func (hrdlogUploader) Upload(adif string, c LogbookConfig) LogbookResult {
+	return submitHRDLog(adif, c.Options["callsign"], c.Credentials["upload_code"])
+}
 
-	// ClubLog
-	if clublogEnabled && clublogEmail != "" && clublogPass != "" && clublogCall != "" {
-		go submitClubLog(adif, clublogEmail, clublogPass, clublogCall, clublogAPI)
+type clublogUploader struct{}
+
+func (clublogUploader) Type() string { return LogbookClubLog }
+func (clublogUploader) Name() string { return "ClubLog" }
+func (clublogUploader) Fields() []UploaderField {
+	return []UploaderField{
+		{Key: "email", Label: "Email", Required: true},
+		{Key: "password", Label: "Password", Secret: true, Required: true},
+		{Key: "callsign", Label: "Callsign", Option: true, Required: true},
+		{Key: "api_key", Label: "API Key (オプション)", Secret: true, Placeholder: "未入力時は430ssb.net経由"},
 	}
 }
+func (clublogUploader) Upload(adif string, c LogbookConfig) LogbookResult {
+	return submitClubLog(adif, c.Credentials["email"], c.Credentials["password"], c.Options["callsign"], c.Credentials["api_key"])
+}
 
 // submitQRZLogbook はQRZ.com Logbookへ送信します
-func submitQRZLogbook(adif, apikey string) {
+func submitQRZLogbook(adif, apikey string) (res LogbookResult) {
 	defer func() {
 		if r := recover(); r != nil {
 			log.Println("[LOGBOOK] QRZ panic:", r)
+			res = LogbookResult{Service: LogbookQRZ, Status: LogbookTransient, Message: fmt.Sprintf("panic: %v", r)}
 		}
 	}()
 
@@ -70,34 +149,29 @@ func submitQRZLogbook(adif, apikey string) {
 	log.Printf("[LOGBOOK] QRZ: ADIF=%s", adif)
 
 	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.PostForm("https://logbook.qrz.com/api", url.Values{
+	resp, err := client.PostForm(qrzLogbookURL, url.Values{
 		"KEY":    {apikey},
 		"ACTION": {"INSERT"},
 		"ADIF":   {adif},
 	})
 	if err != nil {
 		log.Println("[LOGBOOK] QRZ error:", err)
-		return
+		return transientResult(LogbookQRZ, err)
 	}
 	defer resp.Body.Close()
 
-	// レスポンスボディを読み取る
-	body := make([]byte, 1024)
-	n, _ := resp.Body.Read(body)
-	responseText := string(body[:n])
-
-	if resp.StatusCode == 200 {
-		log.Printf("[LOGBOOK] QRZ: success - response: %s", responseText)
-	} else {
-		log.Printf("[LOGBOOK] QRZ: failed, status: %d, response: %s", resp.StatusCode, responseText)
-	}
+	responseText := readLogbookResponse(resp)
+	res = parseQRZResult(resp, responseText)
+	log.Printf("[LOGBOOK] QRZ: %s (HTTP %d)", res, resp.StatusCode)
+	return res
 }
 
 // submitHamQTH はHamQTHへ送信します
-func submitHamQTH(adif, callsign, user, pass string) {
+func submitHamQTH(adif, callsign, user, pass string) (res LogbookResult) {
 	defer func() {
 		if r := recover(); r != nil {
 			log.Println("[LOGBOOK] HamQTH panic:", r)
+			res = LogbookResult{Service: LogbookHamQTH, Status: LogbookTransient, Message: fmt.Sprintf("panic: %v", r)}
 		}
 	}()
 
@@ -114,27 +188,22 @@ func submitHamQTH(adif, callsign, user, pass string) {
 	})
 	if err != nil {
 		log.Println("[LOGBOOK] HamQTH error:", err)
-		return
+		return transientResult(LogbookHamQTH, err)
 	}
 	defer resp.Body.Close()
 
-	// レスポンスボディを読み取る
-	body := make([]byte, 1024)
-	n, _ := resp.Body.Read(body)
-	responseText := string(body[:n])
-
-	if resp.StatusCode == 200 {
-		log.Printf("[LOGBOOK] HamQTH: success (response: %s)", responseText)
-	} else {
-		log.Printf("[LOGBOOK] HamQTH: failed, status: %d, response: %s", resp.StatusCode, responseText)
-	}
+	responseText := readLogbookResponse(resp)
+	res = parseTextResult(LogbookHamQTH, resp, responseText)
+	log.Printf("[LOGBOOK] HamQTH: %s (HTTP %d)", res, resp.StatusCode)
+	return res
 }
 
 // submitEQSL はeQSL.ccへ送信します
-func submitEQSL(adif, user, pass string) {
+func submitEQSL(adif, user, pass string) (res LogbookResult) {
 	defer func() {
 		if r := recover(); r != nil {
 			log.Println("[LOGBOOK] eQSL panic:", r)
+			res = LogbookResult{Service: LogbookEQSL, Status: LogbookTransient, Message: fmt.Sprintf("panic: %v", r)}
 		}
 	}()
 
@@ -152,27 +221,22 @@ func submitEQSL(adif, user, pass string) {
 	resp, err := client.Get(reqURL)
 	if err != nil {
 		log.Println("[LOGBOOK] eQSL error:", err)
-		return
+		return transientResult(LogbookEQSL, err)
 	}
 	defer resp.Body.Close()
 
-	// レスポンスボディを読み取る
-	body := make([]byte, 1024)
-	n, _ := resp.Body.Read(body)
-	responseText := string(body[:n])
-
-	if resp.StatusCode == 200 {
-		log.Printf("[LOGBOOK] eQSL: success (response: %s)", responseText)
-	} else {
-		log.Printf("[LOGBOOK] eQSL: failed, status: %d, response: %s", resp.StatusCode, responseText)
-	}
+	responseText := readLogbookResponse(resp)
+	res = parseEQSLResult(resp, responseText)
+	log.Printf("[LOGBOOK] eQSL: %s (HTTP %d)", res, resp.StatusCode)
+	return res
 }
 
 // submitHRDLog はHRDLog.netへ送信します
-func submitHRDLog(adif, callsign, uploadCode string) {
+func submitHRDLog(adif, callsign, uploadCode string) (res LogbookResult) {
 	defer func() {
 		if r := recover(); r != nil {
 			log.Println("[LOGBOOK] HRDLog panic:", r)
+			res = LogbookResult{Service: LogbookHRDLog, Status: LogbookTransient, Message: fmt.Sprintf("panic: %v", r)}
 		}
 	}()
 
@@ -188,27 +252,22 @@ func submitHRDLog(adif, callsign, uploadCode string) {
 	})
 	if err != nil {
 		log.Println("[LOGBOOK] HRDLog error:", err)
-		return
+		return transientResult(LogbookHRDLog, err)
 	}
 	defer resp.Body.Close()
 
-	// レスポンスボディを読み取る
-	body := make([]byte, 1024)
-	n, _ := resp.Body.Read(body)
-	responseText := string(body[:n])
-
-	if resp.StatusCode == 200 {
-		log.Printf("[LOGBOOK] HRDLog: success - response: %s", responseText)
-	} else {
-		log.Printf("[LOGBOOK] HRDLog: failed, status: %d, response: %s", resp.StatusCode, responseText)
-	}
+	responseText := readLogbookResponse(resp)
+	res = parseHRDLogResult(resp, responseText)
+	log.Printf("[LOGBOOK] HRDLog: %s (HTTP %d)", res, resp.StatusCode)
+	return res
 }
 
 // submitClubLog はClubLogへ送信します
-func submitClubLog(adif, email, password, callsign, apikey string) {
+func submitClubLog(adif, email, password, callsign, apikey string) (res LogbookResult) {
 	defer func() {
 		if r := recover(); r != nil {
 			log.Println("[LOGBOOK] ClubLog panic:", r)
+			res = LogbookResult{Service: LogbookClubLog, Status: LogbookTransient, Message: fmt.Sprintf("panic: %v", r)}
 		}
 	}()
 
@@ -253,18 +312,12 @@ func submitClubLog(adif, email, password, callsign, apikey string) {
 	resp, err := client.PostForm(targetURL, values)
 	if err != nil {
 		log.Println("[LOGBOOK] ClubLog error:", err)
-		return
+		return transientResult(LogbookClubLog, err)
 	}
 	defer resp.Body.Close()
 
-	// レスポンスボディを読み取る
-	body := make([]byte, 1024)
-	n, _ := resp.Body.Read(body)
-	responseText := string(body[:n])
-
-	if resp.StatusCode == 200 {
-		log.Printf("[LOGBOOK] ClubLog: success - response: %s", responseText)
-	} else {
-		log.Printf("[LOGBOOK] ClubLog: failed, status: %d, response: %s", resp.StatusCode, responseText)
-	}
+	responseText := readLogbookResponse(resp)
+	res = parseTextResult(LogbookClubLog, resp, responseText)
+	log.Printf("[LOGBOOK] ClubLog: %s (HTTP %d)", res, resp.StatusCode)
+	return res
 }
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LogbookStatus classifies the reply of a logbook service.
+type LogbookStatus string
+
+const (
+	LogbookOK          LogbookStatus = "ok"
+	LogbookDuplicate   LogbookStatus = "duplicate"    // 登録済み（成功扱い）
+	LogbookAuthError   LogbookStatus = "auth"         // 認証エラー（設定の修正が必要）
+	LogbookRateLimited LogbookStatus = "rate_limited" // 送信制限（時間をおいて再送）
+	LogbookTransient   LogbookStatus = "transient"    // 通信エラー・サーバーエラー（再送）
+	LogbookFatal       LogbookStatus = "fatal"        // 拒否された（再送しても解決しない）
+)
+
+// maxLogbookResponse limits how much of a response body is read.
+const maxLogbookResponse = 64 * 1024
+
+// LogbookResult is the outcome of one upload to one logbook service.
+// Message is the server's own explanation when it gave one.
+type LogbookResult struct {
+	Service    string
+	Status     LogbookStatus
+	Message    string
+	HTTPStatus int
+	RetryAfter time.Duration // 429/503 の Retry-After（なければ0）
+}
+
+// Done reports whether the QSO is in the logbook (newly or already).
+func (r LogbookResult) Done() bool {
+	return r.Status == LogbookOK || r.Status == LogbookDuplicate
+}
+
+// Retryable reports whether sending the same QSO again later may succeed.
+func (r LogbookResult) Retryable() bool {
+	return r.Status == LogbookTransient || r.Status == LogbookRateLimited
+}
+
+func (r LogbookResult) String() string {
+	if r.Message == "" {
+		return string(r.Status)
+	}
+	return fmt.Sprintf("%s: %s", r.Status, r.Message)
+}
+
+// transientResult is the result of a request that got no usable response.
+func transientResult(service string, err error) LogbookResult {
+	return LogbookResult{Service: service, Status: LogbookTransient, Message: err.Error()}
+}
+
+// readLogbookResponse reads at most maxLogbookResponse bytes of the body.
+func readLogbookResponse(resp *http.Response) string {
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, maxLogbookResponse))
+	return string(b)
+}
+
+// classifyHTTPStatus maps a non-200 HTTP status to a result. It is used
+// when the body carries no service-specific answer.
+func classifyHTTPStatus(service string, resp *http.Response, body string) LogbookResult {
+	r := LogbookResult{
+		Service:    service,
+		HTTPStatus: resp.StatusCode,
+		Message:    fmt.Sprintf("HTTP %d: %s", resp.StatusCode, summarizeResponse(body)),
+	}
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		r.Status = LogbookOK
+		r.Message = summarizeResponse(body)
+	case resp.StatusCode == http.StatusUnauthorized, resp.StatusCode == http.StatusForbidden:
+		r.Status = LogbookAuthError
+	case resp.StatusCode == http.StatusTooManyRequests:
+		r.Status = LogbookRateLimited
+		r.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+	case resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode >= 500:
+		r.Status = LogbookTransient
+		r.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+	default:
+		r.Status = LogbookFatal
+	}
+	return r
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as an HTTP date.
+func parseRetryAfter(v string) time.Duration {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0
+	}
+	if n, err := strconv.Atoi(v); err == nil && n > 0 {
+		return time.Duration(n) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+var qrzReplyKeyRe = regexp.MustCompile(`^[A-Z_]+$`)
+
+// parseQRZReply splits a QRZ Logbook API reply into its KEY=value pairs.
+// The values are not reliably URL-encoded (REASON may hold ';' or '&'), so
+// the reply is split by hand: an '&' only starts a new pair when an
+// upper-case key and '=' follow it.
+func parseQRZReply(body string) map[string]string {
+	v := map[string]string{}
+	last := ""
+	for _, part := range strings.Split(strings.TrimSpace(body), "&") {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok || !qrzReplyKeyRe.MatchString(key) {
+			if last != "" {
+				v[last] += "&" + part
+			}
+			continue
+		}
+		v[key] = value
+		last = key
+	}
+	for k, value := range v {
+		if u, err := url.QueryUnescape(value); err == nil {
+			value = u
+		}
+		v[k] = strings.TrimSpace(value)
+	}
+	return v
+}
+
+// parseQRZResult parses a QRZ Logbook API reply such as
+// "RESULT=OK&LOGID=123&COUNT=1" or "RESULT=FAIL&REASON=...duplicate".
+func parseQRZResult(resp *http.Response, body string) LogbookResult {
+	if resp.StatusCode != http.StatusOK {
+		return classifyHTTPStatus(LogbookQRZ, resp, body)
+	}
+
+	r := LogbookResult{Service: LogbookQRZ, HTTPStatus: resp.StatusCode}
+	v := parseQRZReply(body)
+	if v["RESULT"] == "" {
+		r.Status = LogbookTransient
+		r.Message = "unexpected response: " + summarizeResponse(body)
+		return r
+	}
+
+	reason := v["REASON"]
+	lower := strings.ToLower(reason)
+	r.Message = reason
+
+	switch strings.ToUpper(v["RESULT"]) {
+	case "OK", "REPLACE":
+		r.Status = LogbookOK
+		if id := v["LOGID"]; id != "" {
+			r.Message = "LOGID=" + id
+		}
+	case "AUTH":
+		r.Status = LogbookAuthError
+	default:
+		switch {
+		case strings.Contains(lower, "duplicate"):
+			r.Status = LogbookDuplicate
+		case strings.Contains(lower, "api key"), strings.Contains(lower, "access denied"),
+			strings.Contains(lower, "not authorized"), strings.Contains(lower, "subscription"):
+			r.Status = LogbookAuthError
+		case strings.Contains(lower, "too many"), strings.Contains(lower, "rate limit"):
+			r.Status = LogbookRateLimited
+		default:
+			r.Status = LogbookFatal
+		}
+	}
+	return r
+}
+
+var eqslResultRe = regexp.MustCompile(`(?i)Result:\s*(\d+)\s+out of\s+(\d+)\s+records? added`)
+var eqslErrorRe = regexp.MustCompile(`(?i)Error:\s*([^\n]+)`)
+var eqslWarningRe = regexp.MustCompile(`(?i)Warning:\s*([^\n]+)`)
+
+// parseEQSLResult parses the HTML page returned by importADIF.cfm, which
+// reports "Result: 1 out of 1 records added" or "Error: ..." as text.
+func parseEQSLResult(resp *http.Response, body string) LogbookResult {
+	if resp.StatusCode != http.StatusOK {
+		return classifyHTTPStatus(LogbookEQSL, resp, body)
+	}
+
+	r := LogbookResult{Service: LogbookEQSL, HTTPStatus: resp.StatusCode}
+	text := htmlToText(body)
+
+	if m := eqslErrorRe.FindStringSubmatch(text); m != nil {
+		r.Message = strings.TrimSpace(m[1])
+		lower := strings.ToLower(r.Message)
+		switch {
+		case strings.Contains(lower, "no match on eqsl_user"), strings.Contains(lower, "password"),
+			strings.Contains(lower, "pswd"):
+			r.Status = LogbookAuthError
+		case strings.Contains(lower, "busy"), strings.Contains(lower, "try again"):
+			r.Status = LogbookTransient
+		default:
+			r.Status = LogbookFatal
+		}
+		return r
+	}
+
+	if m := eqslResultRe.FindStringSubmatch(text); m != nil {
+		if m[1] != "0" {
+			r.Status = LogbookOK
+			r.Message = strings.TrimSpace(m[0])
+			return r
+		}
+		r.Message = strings.TrimSpace(m[0])
+		if w := eqslWarningRe.FindStringSubmatch(text); w != nil {
+			r.Message = strings.TrimSpace(w[1])
+		}
+		if strings.Contains(strings.ToLower(text), "duplicate") {
+			r.Status = LogbookDuplicate
+		} else {
+			r.Status = LogbookFatal
+		}
+		return r
+	}
+
+	r.Status = LogbookTransient
+	r.Message = "unexpected response: " + summarizeResponse(text)
+	return r
+}
+
+var hrdlogInsertRe = regexp.MustCompile(`(?is)<insert>\s*(\d+)\s*</insert>`)
+var hrdlogErrorRe = regexp.MustCompile(`(?is)<error>(.*?)</error>`)
+
+// parseHRDLogResult parses the XML returned by NewEntry.aspx:
+// <insert>1</insert> on success or <error>...</error>.
+func parseHRDLogResult(resp *http.Response, body string) LogbookResult {
+	if resp.StatusCode != http.StatusOK {
+		return classifyHTTPStatus(LogbookHRDLog, resp, body)
+	}
+
+	r := LogbookResult{Service: LogbookHRDLog, HTTPStatus: resp.StatusCode}
+
+	if m := hrdlogErrorRe.FindStringSubmatch(body); m != nil {
+		r.Message = strings.TrimSpace(m[1])
+		lower := strings.ToLower(r.Message)
+		switch {
+		case strings.Contains(lower, "duplicate"), strings.Contains(lower, "already"):
+			r.Status = LogbookDuplicate
+		case strings.Contains(lower, "upload code"), strings.Contains(lower, "uploadcode"),
+			strings.Contains(lower, "unknown user"), strings.Contains(lower, "callsign"):
+			r.Status = LogbookAuthError
+		default:
+			r.Status = LogbookFatal
+		}
+		return r
+	}
+
+	if m := hrdlogInsertRe.FindStringSubmatch(body); m != nil {
+		r.Message = "insert=" + m[1]
+		if m[1] == "0" {
+			r.Status = LogbookDuplicate
+		} else {
+			r.Status = LogbookOK
+		}
+		return r
+	}
+
+	r.Status = LogbookTransient
+	r.Message = "unexpected response: " + summarizeResponse(body)
+	return r
+}
+
+// parseTextResult parses the plain-text replies of ClubLog realtime.php and
+// HamQTH qso_realtime.php, which answer with the HTTP status (200 accepted,
+// 400 rejected, 403 login failed) plus a short reason.
+func parseTextResult(service string, resp *http.Response, body string) LogbookResult {
+	msg := summarizeResponse(body)
+	lower := strings.ToLower(msg)
+
+	r := LogbookResult{Service: service, HTTPStatus: resp.StatusCode, Message: msg}
+	switch {
+	case strings.Contains(lower, "dupe"), strings.Contains(lower, "duplicate"), strings.Contains(lower, "already exists"):
+		r.Status = LogbookDuplicate
+	case resp.StatusCode == http.StatusOK:
+		r.Status = LogbookOK
+	case resp.StatusCode == http.StatusBadRequest:
+		r.Status = LogbookFatal
+	default:
+		r = classifyHTTPStatus(service, resp, body)
+		if resp.StatusCode == http.StatusForbidden || strings.Contains(lower, "invalid login") ||
+			strings.Contains(lower, "wrong password") {
+			r.Status = LogbookAuthError
+			r.Message = msg
+		}
+	}
+	return r
+}
+
+var htmlTagRe = regexp.MustCompile(`(?s)<[^>]*>`)
+var htmlBreakRe = regexp.MustCompile(`(?i)<br\s*/?>`)
+
+// htmlToText drops tags so that markers split across elements can be matched.
+func htmlToText(s string) string {
+	s = htmlBreakRe.ReplaceAllString(s, "\n")
+	return htmlTagRe.ReplaceAllString(s, " ")
+}
+
+// summarizeResponse returns a single-line, length-limited version of a reply
+// for logs and the UI.
+func summarizeResponse(s string) string {
+	s = strings.Join(strings.Fields(htmlToText(s)), " ")
+	if r := []rune(s); len(r) > 200 {
+		s = string(r[:200]) + "…"
+	}
+	return s
+}
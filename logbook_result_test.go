@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseQRZResult(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   LogbookStatus
+		msg    string
+	}{
+		{"inserted", 200, "RESULT=OK&LOGID=130877825&COUNT=1", LogbookOK, "LOGID=130877825"},
+		{"replaced", 200, "RESULT=REPLACE&LOGID=130877825&COUNT=1\n", LogbookOK, "LOGID=130877825"},
+		{"duplicate", 200, "RESULT=FAIL&REASON=Unable to add QSO to database: duplicate&EXTENDED=", LogbookDuplicate,
+			"Unable to add QSO to database: duplicate"},
+		{"reason with ';'", 200, "RESULT=FAIL&REASON=Unable to add QSO to database: duplicate; QSO already in log&COUNT=0",
+			LogbookDuplicate, "Unable to add QSO to database: duplicate; QSO already in log"},
+		{"reason with '&'", 200, "RESULT=FAIL&REASON=wrong station_callsign & date for this logbook&COUNT=0", LogbookFatal,
+			"wrong station_callsign & date for this logbook"},
+		{"reason with '%'", 200, "RESULT=FAIL&REASON=log is 100% full", LogbookFatal, "log is 100% full"},
+		{"encoded reason", 200, "RESULT=FAIL&REASON=invalid+api+key%3A+ABCD", LogbookAuthError, "invalid api key: ABCD"},
+		{"auth", 200, "RESULT=AUTH&REASON=invalid api key&EXTENDED=", LogbookAuthError, "invalid api key"},
+		{"no subscription", 200, "RESULT=FAIL&REASON=This function requires an active subscription", LogbookAuthError,
+			"This function requires an active subscription"},
+		{"rate limited", 200, "RESULT=FAIL&REASON=Too many requests, slow down", LogbookRateLimited, "Too many requests, slow down"},
+		{"invalid QSO", 200, "RESULT=FAIL&REASON=Unable to add QSO to database: Invalid ADIF; missing call&COUNT=0", LogbookFatal,
+			"Unable to add QSO to database: Invalid ADIF; missing call"},
+		{"HTML page", 200, "<html><body>Service Unavailable</body></html>", LogbookTransient, ""},
+		{"empty", 200, "", LogbookTransient, ""},
+		{"server error", 503, "", LogbookTransient, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: tt.status, Header: http.Header{}}
+			r := parseQRZResult(resp, tt.body)
+			if r.Status != tt.want || (tt.msg != "" && r.Message != tt.msg) {
+				t.Errorf("parseQRZResult(%q) = %s, want %s: %s", tt.body, r, tt.want, tt.msg)
+			}
+			if r.Retryable() != (tt.want == LogbookTransient || tt.want == LogbookRateLimited) {
+				t.Errorf("Retryable = %v", r.Retryable())
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if got := parseRetryAfter("120"); got != 2*time.Minute {
+		t.Errorf("parseRetryAfter(120) = %s", got)
+	}
+	for _, v := range []string{"", "0", "-5", "soon"} {
+		if got := parseRetryAfter(v); got != 0 {
+			t.Errorf("parseRetryAfter(%q) = %s", v, got)
+		}
+	}
+	at := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	if got := parseRetryAfter(at); got < 59*time.Minute || got > time.Hour {
+		t.Errorf("parseRetryAfter(%q) = %s", at, got)
+	}
+}
@@ -0,0 +1,499 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/pkcs12"
+)
+
+const LogbookLoTW = "lotw"
+
+// lotwUploadURL is the LoTW endpoint TQ8 files are posted to.
+var lotwUploadURL = "https://lotw.arrl.org/lotw/upload"
+
+// lotwStationSignFields and lotwContactSignFields are the fields covered by
+// the signature, in the order of the TQSL "LOTW" 2.0 signing spec.
+var lotwStationSignFields = []string{
+	"AU_STATE", "CA_PROVINCE", "CN_PROVINCE", "CQZ", "FI_KUNTA", "GRIDSQUARE",
+	"IOTA", "ITUZ", "JA_CITY_GUN_KU", "JA_PREFECTURE", "RU_OBLAST",
+	"US_COUNTY", "US_PARK", "US_STATE",
+}
+
+var lotwContactSignFields = []string{
+	"CALL", "BAND", "BAND_RX", "FREQ", "FREQ_RX", "MODE", "PROP_MODE",
+	"QSO_DATE", "QSO_TIME", "SAT_NAME",
+}
+
+// lotwUploader signs QSOs with the user's LoTW callsign certificate (a .p12
+// exported from TQSL) and uploads them as a gzip'd TQ8 file. When no
+// certificate is configured, or it cannot be loaded, the tqsl command is run
+// in batch mode instead.
+type lotwUploader struct{}
+
+func (lotwUploader) Type() string { return LogbookLoTW }
+func (lotwUploader) Name() string { return "ARRL LoTW" }
+
+func (lotwUploader) Fields() []UploaderField {
+	return []UploaderField{
+		{Key: "station", Label: "Station Location（TQSL の名前）", Option: true, Required: true},
+		{Key: "cert_file", Label: "証明書ファイル（.p12）", Option: true, Placeholder: "TQSL でエクスポートした .p12"},
+		{Key: "cert_password", Label: "証明書パスワード", Secret: true},
+		{Key: "station_data", Label: "station_data のパス（オプション）", Option: true, Placeholder: defaultTQSLStationData()},
+		{Key: "tqsl_path", Label: "tqsl コマンド（オプション）", Option: true, Placeholder: "証明書ファイル未指定時に使用"},
+		{Key: "lotw_user", Label: "LoTW ユーザー名（QSL取得用）"},
+		{Key: "lotw_pass", Label: "LoTW パスワード（QSL取得用）", Secret: true},
+	}
+}
+
+func (lotwUploader) Upload(adif string, c LogbookConfig) (res LogbookResult) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Println("[LOGBOOK] LoTW panic:", r)
+			res = LogbookResult{Service: LogbookLoTW, Status: LogbookTransient, Message: fmt.Sprintf("panic: %v", r)}
+		}
+	}()
+
+	log.Println("[LOGBOOK] LoTW: sending...")
+
+	station := strings.TrimSpace(c.Options["station"])
+	password := c.Credentials["cert_password"]
+	tqslPath := strings.TrimSpace(c.Options["tqsl_path"])
+
+	if certFile := strings.TrimSpace(c.Options["cert_file"]); certFile != "" {
+		signer, err := loadLoTWSigner(certFile, password)
+		if err == nil {
+			res = uploadLoTWSigned(signer, adif, c.Options["station_data"], station)
+			log.Printf("[LOGBOOK] LoTW: %s", res)
+			return res
+		}
+		if tqslPath == "" {
+			log.Println("[LOGBOOK] LoTW certificate error:", err)
+			return LogbookResult{Service: LogbookLoTW, Status: LogbookAuthError, Message: err.Error()}
+		}
+		log.Printf("[LOGBOOK] LoTW certificate error: %v (fallback to tqsl)", err)
+	}
+
+	if tqslPath != "" {
+		res = runTQSL(tqslPath, station, adif)
+		log.Printf("[LOGBOOK] LoTW (tqsl): %s", res)
+		return res
+	}
+
+	return LogbookResult{Service: LogbookLoTW, Status: LogbookAuthError, Message: "certificate file or tqsl path is required"}
+}
+
+// uploadLoTWSigned builds, signs and posts the TQ8 for the QSOs in adif.
+func uploadLoTWSigned(signer *lotwSigner, adif, stationDataPath, stationName string) LogbookResult {
+	qsos, err := parseQSOs(adif)
+	if err != nil || len(qsos) == 0 {
+		return LogbookResult{Service: LogbookLoTW, Status: LogbookFatal, Message: "no QSO in ADIF"}
+	}
+
+	if strings.TrimSpace(stationDataPath) == "" {
+		stationDataPath = defaultTQSLStationData()
+	}
+	station, err := loadTQSLStation(stationDataPath, stationName)
+	if err != nil {
+		return LogbookResult{Service: LogbookLoTW, Status: LogbookAuthError, Message: err.Error()}
+	}
+
+	tq8, err := buildTQ8(signer, station, qsos)
+	if err != nil {
+		return LogbookResult{Service: LogbookLoTW, Status: LogbookFatal, Message: err.Error()}
+	}
+	return postLoTW(tq8)
+}
+
+// ---- certificate ----
+
+// lotwSigner is a LoTW callsign certificate and its private key.
+type lotwSigner struct {
+	cert *x509.Certificate
+	key  *rsa.PrivateKey
+}
+
+// loadLoTWSigner reads a PKCS#12 file exported from TQSL ("Save Callsign
+// Certificate File"). The file also holds the LoTW CA chain, so the user
+// certificate is the one matching the private key.
+func loadLoTWSigner(path, password string) (*lotwSigner, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	blocks, err := pkcs12.ToPEM(data, password)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open certificate: %w", err)
+	}
+
+	var key *rsa.PrivateKey
+	var certs []*x509.Certificate
+	for _, b := range blocks {
+		switch b.Type {
+		case "PRIVATE KEY":
+			k, err := x509.ParsePKCS1PrivateKey(b.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("unsupported private key: %w", err)
+			}
+			key = k
+		case "CERTIFICATE":
+			c, err := x509.ParseCertificate(b.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			certs = append(certs, c)
+		}
+	}
+	if key == nil {
+		return nil, errors.New("no private key in certificate file")
+	}
+
+	for _, c := range certs {
+		if pub, ok := c.PublicKey.(*rsa.PublicKey); ok && pub.Equal(&key.PublicKey) {
+			if time.Now().After(c.NotAfter) {
+				return nil, fmt.Errorf("certificate expired on %s", c.NotAfter.Format(time.DateOnly))
+			}
+			return &lotwSigner{cert: c, key: key}, nil
+		}
+	}
+	return nil, errors.New("no certificate matches the private key")
+}
+
+// sign returns the base64 SHA-1 RSA signature of data.
+func (s *lotwSigner) sign(data string) (string, error) {
+	sum := sha1.Sum([]byte(data))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA1, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return wrapBase64(sig), nil
+}
+
+// ---- station location ----
+
+// tqslStation is one <StationData> entry of TQSL's station_data file.
+type tqslStation struct {
+	Name   string             `xml:"name,attr"`
+	Fields []tqslStationField `xml:",any"`
+}
+
+type tqslStationField struct {
+	XMLName xml.Name
+	Value   string `xml:",chardata"`
+}
+
+func (s *tqslStation) get(name string) string {
+	for _, f := range s.Fields {
+		if strings.EqualFold(f.XMLName.Local, name) {
+			return strings.TrimSpace(f.Value)
+		}
+	}
+	return ""
+}
+
+// defaultTQSLStationData returns where TQSL keeps its station locations.
+func defaultTQSLStationData() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("APPDATA"), "TrustedQSL", "station_data")
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".tqsl", "station_data")
+}
+
+// loadTQSLStation returns the station location with the given name.
+func loadTQSLStation(path, name string) (*tqslStation, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read station_data: %w", err)
+	}
+	var file struct {
+		Stations []tqslStation `xml:"StationData"`
+	}
+	if err := xml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("cannot parse station_data: %w", err)
+	}
+	for i := range file.Stations {
+		if file.Stations[i].Name == name {
+			s := &file.Stations[i]
+			if s.get("CALL") == "" {
+				return nil, fmt.Errorf("station location %q has no callsign", name)
+			}
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("station location %q not found", name)
+}
+
+// ---- TQ8 ----
+
+// buildTQ8 returns the gzip'd GABBI document with the certificate, the
+// station and one signed contact record per QSO.
+func buildTQ8(signer *lotwSigner, station *tqslStation, qsos []*QSO) ([]byte, error) {
+	var b strings.Builder
+	writeGABBIField(&b, "TQSL_IDENT", "HAMLAB Bridge", "")
+	b.WriteByte('\n')
+
+	writeGABBIField(&b, "Rec_Type", "tCERT", "")
+	writeGABBIField(&b, "CERT_UID", "1", "")
+	writeGABBIField(&b, "CERTIFICATE", wrapBase64(signer.cert.Raw), "")
+	b.WriteString("<eor>\n\n")
+
+	writeGABBIField(&b, "Rec_Type", "tSTATION", "")
+	writeGABBIField(&b, "STATION_UID", "1", "")
+	writeGABBIField(&b, "CERT_UID", "1", "")
+	writeGABBIField(&b, "CALL", strings.ToUpper(station.get("CALL")), "")
+	if dxcc := station.get("DXCC"); dxcc != "" {
+		writeGABBIField(&b, "DXCC", dxcc, "")
+	}
+	var stationSign strings.Builder
+	for _, name := range lotwStationSignFields {
+		if v := strings.ToUpper(station.get(name)); v != "" {
+			writeGABBIField(&b, name, v, "")
+			stationSign.WriteString(v)
+		}
+	}
+	b.WriteString("<eor>\n\n")
+
+	for _, q := range qsos {
+		fields, err := lotwContactFields(q)
+		if err != nil {
+			return nil, err
+		}
+
+		writeGABBIField(&b, "Rec_Type", "tCONTACT", "")
+		writeGABBIField(&b, "STATION_UID", "1", "")
+		signData := stationSign.String()
+		for _, name := range lotwContactSignFields {
+			if v := fields[name]; v != "" {
+				writeGABBIField(&b, name, v, "")
+				signData += v
+			}
+		}
+		sig, err := signer.sign(signData)
+		if err != nil {
+			return nil, err
+		}
+		writeGABBIField(&b, "SIGN_LOTW_V2.0", sig, "6")
+		writeGABBIField(&b, "SIGNDATA", signData, "")
+		b.WriteString("<eor>\n\n")
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(b.String())); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// lotwContactFields returns the tCONTACT values of a QSO, upper-cased as
+// they are signed.
+func lotwContactFields(q *QSO) (map[string]string, error) {
+	if q.Call == "" || q.TimeOn.IsZero() {
+		return nil, errors.New("QSO without call or time")
+	}
+	if q.Band == "" {
+		return nil, fmt.Errorf("%s: BAND is required", q.Call)
+	}
+	mode := q.Mode
+	if q.Submode != "" {
+		mode = q.Submode // LoTW は FT4 等のサブモードをモードとして受け付ける
+	}
+	if mode == "" {
+		return nil, fmt.Errorf("%s: MODE is required", q.Call)
+	}
+
+	f := map[string]string{
+		"CALL":      q.Call,
+		"BAND":      q.Band,
+		"BAND_RX":   q.BandRx,
+		"MODE":      mode,
+		"PROP_MODE": q.PropMode,
+		"QSO_DATE":  q.TimeOn.UTC().Format("2006-01-02"),
+		"QSO_TIME":  q.TimeOn.UTC().Format("15:04:05Z"),
+	}
+	if q.Freq > 0 {
+		f["FREQ"] = formatADIFFreq(q.Freq)
+	}
+	if q.FreqRx > 0 {
+		f["FREQ_RX"] = formatADIFFreq(q.FreqRx)
+	}
+	for _, x := range q.Extra {
+		if strings.EqualFold(x.Name, "SAT_NAME") {
+			f["SAT_NAME"] = x.Value
+		}
+	}
+	for k, v := range f {
+		f[k] = strings.ToUpper(strings.TrimSpace(v))
+	}
+	return f, nil
+}
+
+// writeGABBIField writes one "<NAME:len[:type]>value" line.
+func writeGABBIField(b *strings.Builder, name, value, typ string) {
+	b.WriteByte('<')
+	b.WriteString(name)
+	b.WriteByte(':')
+	b.WriteString(strconv.Itoa(len(value)))
+	if typ != "" {
+		b.WriteByte(':')
+		b.WriteString(typ)
+	}
+	b.WriteByte('>')
+	b.WriteString(value)
+	b.WriteByte('\n')
+}
+
+// wrapBase64 encodes data as base64 in 64 character lines, as PEM does.
+func wrapBase64(data []byte) string {
+	enc := base64.StdEncoding.EncodeToString(data)
+	var b strings.Builder
+	for len(enc) > 64 {
+		b.WriteString(enc[:64])
+		b.WriteByte('\n')
+		enc = enc[64:]
+	}
+	b.WriteString(enc)
+	b.WriteByte('\n')
+	return b.String()
+}
+
+// ---- upload ----
+
+var lotwUploadResultRe = regexp.MustCompile(`\.UPL\.\s*(\w+)`)
+var lotwUploadMessageRe = regexp.MustCompile(`(?s)\.UPLMESSAGE\.\s*(.*?)\s*-->`)
+
+// postLoTW posts a TQ8 file to LoTW.
+func postLoTW(tq8 []byte) LogbookResult {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("upfile", "hamlab-bridge.tq8")
+	if err != nil {
+		return LogbookResult{Service: LogbookLoTW, Status: LogbookFatal, Message: err.Error()}
+	}
+	_, _ = fw.Write(tq8)
+	_ = mw.Close()
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(lotwUploadURL, mw.FormDataContentType(), &body)
+	if err != nil {
+		log.Println("[LOGBOOK] LoTW error:", err)
+		return transientResult(LogbookLoTW, err)
+	}
+	defer resp.Body.Close()
+
+	return parseLoTWResult(resp, readLogbookResponse(resp))
+}
+
+// parseLoTWResult parses the upload page, which carries the outcome in
+// "<!-- .UPL. accepted -->" and "<!-- .UPLMESSAGE. ... -->" comments.
+func parseLoTWResult(resp *http.Response, body string) LogbookResult {
+	if resp.StatusCode != http.StatusOK {
+		return classifyHTTPStatus(LogbookLoTW, resp, body)
+	}
+
+	r := LogbookResult{Service: LogbookLoTW, HTTPStatus: resp.StatusCode}
+	if m := lotwUploadMessageRe.FindStringSubmatch(body); m != nil {
+		r.Message = summarizeResponse(m[1])
+	}
+
+	m := lotwUploadResultRe.FindStringSubmatch(body)
+	if m == nil {
+		r.Status = LogbookTransient
+		r.Message = "unexpected response: " + summarizeResponse(body)
+		return r
+	}
+
+	lower := strings.ToLower(r.Message)
+	switch {
+	case strings.EqualFold(m[1], "accepted"):
+		r.Status = LogbookOK
+	case strings.Contains(lower, "duplicate"):
+		r.Status = LogbookDuplicate
+	case strings.Contains(lower, "certificate"), strings.Contains(lower, "signature"),
+		strings.Contains(lower, "expired"), strings.Contains(lower, "revoked"):
+		r.Status = LogbookAuthError
+	default:
+		r.Status = LogbookFatal
+	}
+	return r
+}
+
+// ---- tqsl fallback ----
+
+// runTQSL signs and uploads adif with the tqsl command in batch mode.
+// See "tqsl -h"; the exit status tells how the upload went. tqsl only
+// takes the key passphrase on its command line, where other users can read
+// it, so the key in TQSL must not have one.
+func runTQSL(path, station, adif string) LogbookResult {
+	f, err := os.CreateTemp("", "hamlab-lotw-*.adi")
+	if err != nil {
+		return LogbookResult{Service: LogbookLoTW, Status: LogbookTransient, Message: err.Error()}
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(adif)
+	f.Close()
+	if err != nil {
+		return LogbookResult{Service: LogbookLoTW, Status: LogbookTransient, Message: err.Error()}
+	}
+
+	args := []string{"-d", "-u", "-x", "-a", "compliant", "-l", station, f.Name()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, path, args...).CombinedOutput()
+
+	r := LogbookResult{Service: LogbookLoTW, Message: summarizeResponse(string(out))}
+	code := 0
+	if err != nil {
+		var ee *exec.ExitError
+		if !errors.As(err, &ee) {
+			r.Status = LogbookFatal
+			r.Message = err.Error()
+			return r
+		}
+		code = ee.ExitCode()
+	}
+
+	switch code {
+	case 0, 9: // 9: 一部が重複
+		r.Status = LogbookOK
+	case 8: // すべて重複または期間外
+		r.Status = LogbookDuplicate
+	case 3, 11: // LoTW の応答異常・接続エラー
+		r.Status = LogbookTransient
+	case 4, 5: // TQSL / tqsllib エラー（証明書・パスワード等）
+		r.Status = LogbookAuthError
+		r.Message = strings.TrimSpace(r.Message + " (tqsl needs a callsign certificate without a passphrase)")
+	default:
+		r.Status = LogbookFatal
+	}
+	if r.Message == "" {
+		r.Message = "tqsl exit status " + strconv.Itoa(code)
+	}
+	return r
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"compress/gzip"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// testdata/lotw_test.p12 は自己署名の証明書（CN=JA1TEST、パスワード "test"）
+const lotwTestCert = "testdata/lotw_test.p12"
+
+const lotwTestStationData = `<StationDataFile>
+<StationData name="Home">
+<CALL>JA1TEST</CALL>
+<DXCC>339</DXCC>
+<GRIDSQUARE>pm95</GRIDSQUARE>
+<CQZ>25</CQZ>
+<ITUZ>45</ITUZ>
+</StationData>
+</StationDataFile>
+`
+
+const lotwTestADIF = "<call:5>K1ABC <band:3>20m <mode:4>MFSK <submode:3>FT4 <freq:9>14.080000 " +
+	"<qso_date:8>20240102 <time_on:6>123000 <eor>"
+
+// setLoTWUploadURL points the uploads at a test server.
+func setLoTWUploadURL(t *testing.T, h http.HandlerFunc) {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	old := lotwUploadURL
+	lotwUploadURL = srv.URL
+	t.Cleanup(func() {
+		lotwUploadURL = old
+		srv.Close()
+	})
+}
+
+func TestLoTWUploadSigned(t *testing.T) {
+	stationData := filepath.Join(t.TempDir(), "station_data")
+	if err := os.WriteFile(stationData, []byte(lotwTestStationData), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	signer, err := loadLoTWSigner(lotwTestCert, "test")
+	if err != nil {
+		t.Fatalf("loadLoTWSigner: %v", err)
+	}
+
+	var tq8 *ADIFFile
+	setLoTWUploadURL(t, func(w http.ResponseWriter, r *http.Request) {
+		f, _, err := r.FormFile("upfile")
+		if err != nil {
+			t.Errorf("upfile: %v", err)
+			return
+		}
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Errorf("gzip: %v", err)
+			return
+		}
+		b, _ := io.ReadAll(zr)
+		if tq8, err = parseADIF(string(b)); err != nil {
+			t.Errorf("parse TQ8: %v", err)
+		}
+		io.WriteString(w, "<html><!-- .UPL. accepted --><!-- .UPLMESSAGE. 1 QSO accepted --></html>")
+	})
+
+	c := LogbookConfig{
+		Type:        LogbookLoTW,
+		Options:     map[string]string{"station": "Home", "cert_file": lotwTestCert, "station_data": stationData},
+		Credentials: map[string]string{"cert_password": "test"},
+	}
+	res := lotwUploader{}.Upload(lotwTestADIF, c)
+	if res.Status != LogbookOK || res.Message != "1 QSO accepted" {
+		t.Fatalf("Upload = %s", res)
+	}
+
+	if tq8 == nil || len(tq8.Records) != 3 {
+		t.Fatalf("TQ8 = %+v", tq8)
+	}
+	if got := tq8.Records[1].get("CALL"); got != "JA1TEST" {
+		t.Errorf("tSTATION CALL = %q", got)
+	}
+	contact := tq8.Records[2]
+	for name, want := range map[string]string{
+		"Rec_Type": "tCONTACT", "CALL": "K1ABC", "BAND": "20M", "MODE": "FT4",
+		"QSO_DATE": "2024-01-02", "QSO_TIME": "12:30:00Z",
+		// 局の GRIDSQUARE / CQZ / ITUZ と交信の項目の順
+		"SIGNDATA": "25PM9545K1ABC20M14.08FT42024-01-0212:30:00Z",
+	} {
+		if got := contact.get(name); got != want {
+			t.Errorf("tCONTACT %s = %q, want %q", name, got, want)
+		}
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(contact.get("SIGN_LOTW_V2.0"), "\n", ""))
+	if err != nil {
+		t.Fatalf("signature: %v", err)
+	}
+	sum := sha1.Sum([]byte(contact.get("SIGNDATA")))
+	if err := rsa.VerifyPKCS1v15(&signer.key.PublicKey, crypto.SHA1, sum[:], sig); err != nil {
+		t.Errorf("signature does not verify: %v", err)
+	}
+}
+
+func TestLoTWUploadResult(t *testing.T) {
+	tests := []struct {
+		body   string
+		status int
+		want   LogbookStatus
+	}{
+		{"<!-- .UPL. rejected --><!-- .UPLMESSAGE. Duplicate QSO -->", http.StatusOK, LogbookDuplicate},
+		{"<!-- .UPL. rejected --><!-- .UPLMESSAGE. Certificate has expired -->", http.StatusOK, LogbookAuthError},
+		{"<!-- .UPL. rejected --><!-- .UPLMESSAGE. Bad file -->", http.StatusOK, LogbookFatal},
+		{"<html>maintenance</html>", http.StatusOK, LogbookTransient},
+		{"", http.StatusServiceUnavailable, LogbookTransient},
+	}
+	for _, tt := range tests {
+		setLoTWUploadURL(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+			io.WriteString(w, tt.body)
+		})
+		if res := postLoTW([]byte("tq8")); res.Status != tt.want {
+			t.Errorf("%d %q = %s, want %s", tt.status, tt.body, res, tt.want)
+		}
+	}
+}
+
+func TestLoTWCertificateError(t *testing.T) {
+	c := LogbookConfig{
+		Type:        LogbookLoTW,
+		Options:     map[string]string{"station": "Home", "cert_file": lotwTestCert},
+		Credentials: map[string]string{"cert_password": "wrong"},
+	}
+	if res := (lotwUploader{}).Upload(lotwTestADIF, c); res.Status != LogbookAuthError {
+		t.Errorf("Upload with a wrong password = %s", res)
+	}
+}
+
+// The tqsl fallback must not put the certificate password on the command
+// line, where other users can read it.
+func TestLoTWTQSLFallback(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake tqsl is a shell script")
+	}
+	dir := t.TempDir()
+	argsFile := filepath.Join(dir, "args")
+	tqsl := filepath.Join(dir, "tqsl")
+	script := "#!/bin/sh\necho \"$@\" > " + argsFile + "\necho 'Final Status: Success(0)'\n"
+	if err := os.WriteFile(tqsl, []byte(script), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	c := LogbookConfig{
+		Type:        LogbookLoTW,
+		Options:     map[string]string{"station": "Home", "tqsl_path": tqsl},
+		Credentials: map[string]string{"cert_password": "secret"},
+	}
+	res := lotwUploader{}.Upload(lotwTestADIF, c)
+	if res.Status != LogbookOK {
+		t.Errorf("Upload = %s", res)
+	}
+	args, err := os.ReadFile(argsFile)
+	if err != nil {
+		t.Fatalf("tqsl was not run: %v", err)
+	}
+	f := strings.Fields(string(args))
+	if len(f) != 8 || strings.Join(f[:7], " ") != "-d -u -x -a compliant -l Home" || strings.Contains(string(args), "secret") {
+		t.Errorf("tqsl args = %q", f)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"time"
 )
 
 // main starts the HAMLAB Bridge. It loads the configuration from a file named
@@ -13,17 +14,33 @@ import (
 func main() {
 	log.Println("App data dir:", appDataDir())
 	loadConfig()
+	openAppData()
 
 	go startWebUI()
 
 	setupLaunchAgent()
 	go startWebSocket()
+	startForwarders()
 	go startBridge()
+	go outboxQ.run()
+	go startQSLSync()
 	go startRigWatcher()
+	startRigctld()
 
 	select {}
 }
 
+// openAppData loads the state kept in the app data dir. It runs in main
+// after the configuration is loaded, not at package initialization, so
+// that nothing touches the data dir before main starts.
+func openAppData() {
+	qrzc = newQRZCache(24 * time.Hour)
+	events = newEventLog()
+	qsoJournal = openJournal()
+	outboxQ = newOutbox()
+	qslIdx = newQSLIndex()
+}
+
 // appDataDir returns the path to the HAMLAB Bridge's app data directory.
 // The directory is created if it does not exist.
 // The function logs a fatal error if it cannot get the user's config directory or create the app data directory.
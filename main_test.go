@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+const testPairingToken = "test-pairing-token"
+
+// TestMain points the app data dir at a temporary directory, so that tests
+// never touch the user's data, and opens the stores main opens.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "hamlab-bridge-test")
+	if err != nil {
+		panic(err)
+	}
+	// os.UserConfigDir は OS ごとに別の環境変数を見る
+	os.Setenv("XDG_CONFIG_HOME", dir)
+	os.Setenv("HOME", dir)
+	os.Setenv("AppData", dir)
+
+	configLock.Lock()
+	config.PairingToken = testPairingToken
+	config.RigBroadcastMode = "all"
+	configLock.Unlock()
+
+	openAppData()
+	go broadcastWorker()
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
@@ -0,0 +1,123 @@
+package main
+
+import "time"
+
+// EventVersion is the version of the WebSocket message format, sent as "v"
+// in every message. It is incremented on incompatible changes only; new
+// fields and new message types keep the version.
+const EventVersion = 1
+
+// Envelope is the common header of every message the bridge sends over the
+// WebSocket. Seq is set on broadcast events only (see eventLog).
+type Envelope struct {
+	V      int       `json:"v"`
+	Type   string    `json:"type"`
+	Seq    uint64    `json:"seq,omitempty"`
+	TS     time.Time `json:"ts"`
+	Source string    `json:"source,omitempty"` // イベントの発生元（リスナー名など）
+}
+
+// Message is implemented by all messages sent to WebSocket clients.
+type Message interface {
+	envelope() *Envelope
+}
+
+func (e *Envelope) envelope() *Envelope { return e }
+
+// newEnvelope returns the header of a new message.
+func newEnvelope(typ, source string) Envelope {
+	return Envelope{V: EventVersion, Type: typ, TS: time.Now().UTC(), Source: source}
+}
+
+// Command is the common header of requests sent by WebSocket clients.
+// V is optional; clients written for this version may send it.
+type Command struct {
+	V    int    `json:"v,omitempty"`
+	Type string `json:"type"`
+}
+
+// ResumeCommand asks for the events broadcast after Since.
+type ResumeCommand struct {
+	Command        // type: "resume"
+	Since   uint64 `json:"since"`
+}
+
+// SubscribeCommand changes the topics sent to the client.
+type SubscribeCommand struct {
+	Command          // type: "subscribe" / "unsubscribe"
+	Topics  []string `json:"topics"`
+	Filter  wsFilter `json:"filter"`
+}
+
+// GetRigStateCommand asks for the state of one rig port, or of all ports
+// when Port is omitted.
+type GetRigStateCommand struct {
+	Command      // type: "getRigState"
+	Port    *int `json:"port,omitempty"`
+}
+
+// ResumedReply ends the replay started by a resume command.
+type ResumedReply struct {
+	Envelope        // type: "resumed"
+	Since    uint64 `json:"since"`
+	Last     uint64 `json:"last"`
+	Replayed int    `json:"replayed"`
+	Gap      bool   `json:"gap"`
+}
+
+// SubscribedReply lists the topics after subscribe / unsubscribe.
+type SubscribedReply struct {
+	Envelope          // type: "subscribed"
+	Topics   []string `json:"topics"`
+}
+
+// RigPortState is the state of one rig port.
+type RigPortState struct {
+	Port  int      `json:"port"`
+	Freq  int64    `json:"freq"`
+	Mode  RigMode  `json:"mode"`
+	Data  bool     `json:"data"`
+	PTT   bool     `json:"ptt"`
+	Proto RigProto `json:"proto"`
+}
+
+// RigStateReply answers getRigState for one port.
+type RigStateReply struct {
+	Envelope // type: "rigState"
+	RigPortState
+}
+
+// RigStatesReply answers getRigState for all ports, keyed by port index.
+type RigStatesReply struct {
+	Envelope                         // type: "rigStates"
+	States   map[string]RigPortState `json:"states"`
+}
+
+// WSJTXAckReply confirms that a wsjtx* command was sent to WSJT-X/JTDX.
+type WSJTXAckReply struct {
+	Envelope        // type: "wsjtxAck"
+	Command  string `json:"command"`
+	ID       string `json:"id"`
+}
+
+// RigAckReply reports the result of a setFreq / setMode / setVFO /
+// setSplit / setPTT command.
+type RigAckReply struct {
+	Envelope        // type: "rigAck"
+	Command  string `json:"command"`
+	Port     int    `json:"port"`
+	OK       bool   `json:"ok"`
+	Error    string `json:"error,omitempty"`
+}
+
+// ErrorReply reports a failed command.
+type ErrorReply struct {
+	Envelope        // type: "error"
+	Command  string `json:"command,omitempty"`
+	Error    string `json:"error"`
+}
+
+// newErrorReply returns the reply for a failed command.
+func newErrorReply(command string, err error) *ErrorReply {
+	return &ErrorReply{Envelope: newEnvelope("error", ""), Command: command, Error: err.Error()}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const outboxFile = "outbox.json"
+
+// outbox エントリの状態
+const (
+	OutboxPending = "pending"
+	OutboxFailed  = "failed" // 認証エラー・拒否（dead letter）。手動再送のみ
+)
+
+const (
+	outboxBaseDelay = 30 * time.Second
+	outboxMaxDelay  = time.Hour
+)
+
+// OutboxEntry is one QSO waiting to be uploaded to one logbook service.
+// Entries are removed once the upload succeeds.
+type OutboxEntry struct {
+	ID         string    `json:"id"`
+	JournalID  uint64    `json:"journal_id,omitempty"`
+	Service    string    `json:"service"`
+	Call       string    `json:"call"`
+	TimeOn     time.Time `json:"time_on"`
+	ADIF       string    `json:"adif"`
+	State      string    `json:"state"`
+	Attempts   int       `json:"attempts"`
+	NextTry    time.Time `json:"next_try"`
+	LastStatus string    `json:"last_status,omitempty"` // LogbookStatus
+	LastError  string    `json:"last_error,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// outbox is the persistent upload queue stored in the app data dir.
+type outbox struct {
+	mu       sync.Mutex
+	entries  []*OutboxEntry
+	inFlight map[string]bool
+	wake     chan struct{}
+}
+
+// outboxQ is opened by openAppData.
+var outboxQ *outbox
+
+func outboxPath() string {
+	return filepath.Join(appDataDir(), outboxFile)
+}
+
+// newOutbox returns an outbox loaded from "outbox.json".
+// Pending entries left over from a previous run are retried immediately.
+func newOutbox() *outbox {
+	o := &outbox{
+		inFlight: make(map[string]bool),
+		wake:     make(chan struct{}, 1),
+	}
+	o.load()
+	for _, e := range o.entries {
+		if e.State == OutboxPending {
+			e.NextTry = time.Time{}
+		}
+	}
+	return o
+}
+
+// load reads the outbox file. A missing or broken file yields an empty outbox.
+func (o *outbox) load() {
+	b, err := os.ReadFile(outboxPath())
+	if err != nil {
+		return
+	}
+	if err := json.Unmarshal(b, &o.entries); err != nil {
+		log.Println("[OUTBOX] load error:", err)
+	}
+}
+
+// save writes the outbox file atomically. The caller must hold o.mu.
+func (o *outbox) save() {
+	b, _ := json.MarshalIndent(o.entries, "", "  ")
+	tmp := outboxPath() + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		log.Println("[OUTBOX] save error:", err)
+		return
+	}
+	if err := os.Rename(tmp, outboxPath()); err != nil {
+		log.Println("[OUTBOX] save error:", err)
+	}
+}
+
+// enqueue adds one upload of q to service and wakes the worker.
+func (o *outbox) enqueue(service string, q *QSO, journalID uint64) {
+	e := &OutboxEntry{
+		ID:        newOutboxID(),
+		JournalID: journalID,
+		Service:   service,
+		Call:      q.Call,
+		TimeOn:    q.TimeOn,
+		ADIF:      q.adif(),
+		State:     OutboxPending,
+		CreatedAt: time.Now(),
+	}
+
+	o.mu.Lock()
+	o.entries = append(o.entries, e)
+	o.save()
+	o.mu.Unlock()
+
+	log.Printf("[OUTBOX] queued: %s %s (%s)", service, e.Call, e.ID)
+	o.kick()
+}
+
+// kick wakes the worker without blocking.
+func (o *outbox) kick() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run processes due entries until the process exits.
+func (o *outbox) run() {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		for _, e := range o.due() {
+			o.send(e)
+		}
+		select {
+		case <-ticker.C:
+		case <-o.wake:
+		}
+	}
+}
+
+// due returns copies of the pending entries whose retry time has come and
+// marks them in flight.
+func (o *outbox) due() []OutboxEntry {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	now := time.Now()
+	var list []OutboxEntry
+	for _, e := range o.entries {
+		if e.State == OutboxPending && !o.inFlight[e.ID] && !e.NextTry.After(now) {
+			o.inFlight[e.ID] = true
+			list = append(list, *e)
+		}
+	}
+	return list
+}
+
+// send uploads one entry, records the outcome and broadcasts it as a
+// logbookResult event.
+func (o *outbox) send(e OutboxEntry) {
+	res := sendLogbook(e.Service, e.ADIF)
+
+	o.mu.Lock()
+	delete(o.inFlight, e.ID)
+
+	idx := o.indexOf(e.ID)
+	if idx < 0 {
+		o.mu.Unlock()
+		return // 送信中に削除された
+	}
+	cur := o.entries[idx]
+	cur.Attempts++
+	cur.LastStatus = string(res.Status)
+	cur.LastError = ""
+
+	switch {
+	case res.Done():
+		o.entries = append(o.entries[:idx], o.entries[idx+1:]...)
+		log.Printf("[OUTBOX] done: %s %s (%s): %s", e.Service, e.Call, e.ID, res)
+
+	case res.Retryable():
+		d := outboxBackoff(cur.Attempts)
+		if res.RetryAfter > d {
+			d = res.RetryAfter
+		}
+		cur.LastError = res.Message
+		cur.NextTry = time.Now().Add(d)
+		log.Printf("[OUTBOX] retry %s %s at %s (attempt %d): %s", e.Service, e.Call, cur.NextTry.Format(time.TimeOnly), cur.Attempts, res)
+
+	default:
+		cur.State = OutboxFailed
+		cur.LastError = res.Message
+		log.Printf("[OUTBOX] failed permanently: %s %s (%s): %s", e.Service, e.Call, e.ID, res)
+	}
+
+	o.save()
+	ev := &LogbookResultEvent{
+		Envelope:  newEnvelope("logbookResult", ""),
+		ID:        e.ID,
+		JournalID: e.JournalID,
+		Service:   e.Service,
+		Call:      e.Call,
+		Status:    string(res.Status),
+		Message:   res.Message,
+		Attempts:  cur.Attempts,
+		Retry:     res.Retryable(),
+	}
+	if !e.TimeOn.IsZero() {
+		ev.TimeOn = e.TimeOn.UTC().Format(time.RFC3339)
+	}
+	if ev.Retry {
+		ev.NextTry = cur.NextTry.UTC().Format(time.RFC3339)
+	}
+	o.mu.Unlock()
+
+	qsoJournal.setUpload(e.JournalID, e.Service, JournalUpload{
+		Status:    ev.Status,
+		Message:   ev.Message,
+		Attempts:  ev.Attempts,
+		UpdatedAt: time.Now().UTC(),
+	})
+
+	broadcast(ev)
+}
+
+// outboxBackoff returns the delay before the next attempt: 30s, 1m, 2m ... up to 1h.
+func outboxBackoff(attempts int) time.Duration {
+	d := outboxBaseDelay
+	for i := 1; i < attempts && d < outboxMaxDelay; i++ {
+		d *= 2
+	}
+	if d > outboxMaxDelay {
+		d = outboxMaxDelay
+	}
+	return d
+}
+
+// retry makes the entry (or every entry when id is empty) due now,
+// including dead-lettered ones.
+func (o *outbox) retry(id string) {
+	o.mu.Lock()
+	for _, e := range o.entries {
+		if id == "" || e.ID == id {
+			e.State = OutboxPending
+			e.NextTry = time.Time{}
+		}
+	}
+	o.save()
+	o.mu.Unlock()
+
+	o.kick()
+}
+
+// remove deletes an entry from the outbox.
+func (o *outbox) remove(id string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if idx := o.indexOf(id); idx >= 0 {
+		o.entries = append(o.entries[:idx], o.entries[idx+1:]...)
+		o.save()
+	}
+}
+
+// list returns a snapshot of all entries, oldest first.
+func (o *outbox) list() []OutboxEntry {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	list := make([]OutboxEntry, 0, len(o.entries))
+	for _, e := range o.entries {
+		list = append(list, *e)
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].CreatedAt.Before(list[j].CreatedAt)
+	})
+	return list
+}
+
+// indexOf returns the index of the entry with the given id, or -1.
+// The caller must hold o.mu.
+func (o *outbox) indexOf(id string) int {
+	for i, e := range o.entries {
+		if e.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func newOutboxID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -2,7 +2,10 @@
 
 package main
 
-import "path/filepath"
+import (
+	"os"
+	"path/filepath"
+)
 
 func listSerialPorts() []string {
 	var ports []string
@@ -12,3 +15,10 @@ func listSerialPorts() []string {
 	}
 	return ports
 }
+
+// serialPortPresent reports whether the device node of a serial port
+// exists, i.e. the USB-serial adapter is plugged in.
+func serialPortPresent(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
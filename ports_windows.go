@@ -3,6 +3,8 @@
 package main
 
 import (
+	"strings"
+
 	"golang.org/x/sys/windows/registry"
 )
 
@@ -27,3 +29,14 @@ func listSerialPorts() []string {
 	}
 	return ports
 }
+
+// serialPortPresent reports whether a COM port is currently registered,
+// i.e. the USB-serial adapter is plugged in.
+func serialPortPresent(name string) bool {
+	for _, p := range listSerialPorts() {
+		if strings.EqualFold(p, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -4,25 +4,23 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"io"
 	"log"
 	"os"
-	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/creack/pty"
 	"go.bug.st/serial"
+	"golang.org/x/sync/errgroup"
 )
 
 var ptyPaths []string
 var ptyPathsMu sync.RWMutex
 
-// PTY再起動制御
-var ptyStopFlag bool
-var ptyStopMu sync.Mutex
-
 // GetPTYPaths returns the current PTY slave paths for external applications
 func GetPTYPaths() []string {
 	ptyPathsMu.RLock()
@@ -42,28 +40,10 @@ func GetPTYPath() string {
 	return ""
 }
 
-// stopRigWatcherWithPTY stops all running PTY watchers
+// stopRigWatcherWithPTY stops all running PTY watchers and waits until
+// their ports and PTYs are closed.
 func stopRigWatcherWithPTY() {
-	ptyStopMu.Lock()
-	ptyStopFlag = true
-	ptyStopMu.Unlock()
-
-	// 既存の接続を全てクローズ
-	currentRigPortsMu.Lock()
-	for _, port := range currentRigPorts {
-		if port != nil {
-			port.Close()
-		}
-	}
-	currentRigPortsMu.Unlock()
-
-	// 少し待機してgoroutineが終了するのを待つ
-	time.Sleep(500 * time.Millisecond)
-
-	ptyStopMu.Lock()
-	ptyStopFlag = false
-	ptyStopMu.Unlock()
-
+	stopRigWatchers()
 	log.Println("[RIG-PTY] stopped")
 }
 
@@ -75,7 +55,8 @@ func restartRigWatcherWithPTY() {
 }
 
 // startRigWatcherWithPTY starts the Rig watcher with PTY routing.
-// It creates a virtual PTY and routes data between multiple real COM ports and the PTY.
+// It creates a virtual PTY for each port and routes data between the real COM ports and the PTYs.
+// A PTY is kept until the watcher stops, while its COM port is reconnected.
 // External applications (WSJT-X, JTDX, etc.) connect to the PTY slave.
 // All enabled ports are monitored and forwarded to PTY regardless of broadcast mode.
 func startRigWatcherWithPTY() {
@@ -91,27 +72,18 @@ func startRigWatcherWithPTY() {
 	}
 
 	// 有効なポートをカウント
-	var enabledPorts []struct {
-		Index int
-		Port  string
-		Baud  int
-	}
+	enabledCount := 0
 	for i, rp := range rigPorts {
 		if rp.Port != "" {
-			baud := rp.Baud
-			if baud == 0 {
-				baud = 9600
+			if rp.Baud == 0 {
+				rigPorts[i].Baud = 9600
 			}
-			enabledPorts = append(enabledPorts, struct {
-				Index int
-				Port  string
-				Baud  int
-			}{i, rp.Port, baud})
-			log.Printf("[RIG-PTY] port[%d]: %s @ %d baud", i, rp.Port, baud)
+			log.Printf("[RIG-PTY] port[%d]: %s @ %d baud", i, rp.Port, rigPorts[i].Baud)
+			enabledCount++
 		}
 	}
 
-	if len(enabledPorts) == 0 {
+	if enabledCount == 0 {
 		log.Println("[RIG-PTY] no ports configured")
 		return
 	}
@@ -121,309 +93,235 @@ func startRigWatcherWithPTY() {
 	ptyPaths = make([]string, len(rigPorts))
 	ptyPathsMu.Unlock()
 
-	var wg sync.WaitGroup
-
-	// 各ポートごとに個別のPTYを作成
-	for _, ep := range enabledPorts {
-		// Create PTY pair for this port
-		ptmx, tty, err := pty.Open()
+	// 各ポートの PTY は停止まで作り直さない（COM ポートの抜き差しではパスが変わらない）
+	runRigWatchers(rigPorts, func(ctx context.Context, index int, rp RigPortConfig) {
+		b, err := openPTYBridge(index)
 		if err != nil {
-			log.Printf("[RIG-PTY-%d] PTY open error: %v", ep.Index, err)
-			continue
+			log.Printf("[RIG-PTY-%d] PTY open error: %v", index, err)
+			return
 		}
+		log.Printf("[RIG-PTY-%d] PTY created: %s (use this path in WSJT-X/JTDX/HAMLOG)", index, b.tty.Name())
+		b.serve(ctx, func() {
+			superviseRigPort(ctx, index, rp, b.runCOM)
+		})
+	})
+}
 
-		slavePath := tty.Name()
-
-		ptyPathsMu.Lock()
-		ptyPaths[ep.Index] = slavePath
-		ptyPathsMu.Unlock()
-
-		log.Printf("[RIG-PTY-%d] PTY created: %s", ep.Index, slavePath)
+// ptyBridge is the PTY of a rig port. It is created when the watcher
+// starts and kept while the COM port is unplugged and reopened, so that
+// applications keep the slave open and its path does not change. Data the
+// applications write while the COM port is closed is dropped.
+type ptyBridge struct {
+	index int
+	ptmx  *os.File    // マスター側（非ブロッキング）
+	tty   *os.File    // スレーブ側（アプリケーションが開いていなくてもパスを保つ）
+	out   chan []byte // COM → PTY（ブロック防止）
+
+	mu  sync.Mutex
+	com serial.Port // 開いている COM ポート（切断中は nil）
+}
 
-		// COMポートを開く
-		mode := &serial.Mode{
-			BaudRate: ep.Baud,
-			DataBits: 8,
-			Parity:   serial.NoParity,
-			StopBits: serial.OneStopBit,
-		}
+// openPTYBridge creates the PTY pair of a rig port.
+func openPTYBridge(index int) (*ptyBridge, error) {
+	ptmx, tty, err := pty.Open()
+	if err != nil {
+		return nil, err
+	}
+	if ptmx, err = pollablePTY(ptmx); err != nil {
+		tty.Close()
+		return nil, err
+	}
+	return &ptyBridge{index: index, ptmx: ptmx, tty: tty, out: make(chan []byte, 100)}, nil
+}
 
-		realCOM, err := serial.Open(ep.Port, mode)
-		if err != nil {
-			log.Printf("[RIG-PTY-%d] COM open error: %v", ep.Index, err)
-			ptmx.Close()
-			tty.Close()
-			continue
-		}
-		log.Printf("[RIG-PTY-%d] COM opened: %s → PTY: %s", ep.Index, ep.Port, slavePath)
+// serve publishes the PTY path and copies between the PTY and the COM
+// port while supervise runs. supervise must return when ctx is cancelled;
+// the PTY is closed after it has returned.
+func (b *ptyBridge) serve(ctx context.Context, supervise func()) {
+	ptyPathsMu.Lock()
+	if b.index < len(ptyPaths) {
+		ptyPaths[b.index] = b.tty.Name()
+	}
+	ptyPathsMu.Unlock()
+	// Broadcast all PTY paths to WebSocket clients
+	broadcastPTYPaths()
 
-		// グローバルに保存
-		currentRigPortsMu.Lock()
-		currentRigPorts[ep.Index] = realCOM
-		currentRigPortsMu.Unlock()
+	pctx, cancel := context.WithCancel(ctx)
+	var g errgroup.Group
+	g.Go(func() error {
+		b.writeLoop(pctx)
+		return nil
+	})
+	g.Go(func() error {
+		b.readLoop()
+		return nil
+	})
 
-		// 後方互換性: index 0 の場合は旧変数にもセット
-		if ep.Index == 0 {
-			currentRigPortMu.Lock()
-			currentRigPort = realCOM
-			currentRigPortMu.Unlock()
-		}
+	supervise()
 
-		wg.Add(1)
-		go func(index int, port string, com serial.Port, master *os.File, slave *os.File) {
-			defer wg.Done()
-			defer com.Close()
-			defer master.Close()
-			defer slave.Close()
-			defer func() {
-				currentRigPortsMu.Lock()
-				delete(currentRigPorts, index)
-				currentRigPortsMu.Unlock()
-				ptyPathsMu.Lock()
-				if index < len(ptyPaths) {
-					ptyPaths[index] = ""
-				}
-				ptyPathsMu.Unlock()
-				if index == 0 {
-					currentRigPortMu.Lock()
-					currentRigPort = nil
-					currentRigPortMu.Unlock()
-				}
-			}()
+	cancel()
+	b.ptmx.Close()
+	b.tty.Close()
+	_ = g.Wait()
 
-			runSinglePortPTYIndependent(index, port, com, master)
-		}(ep.Index, ep.Port, realCOM, ptmx, tty)
+	ptyPathsMu.Lock()
+	if b.index < len(ptyPaths) {
+		ptyPaths[b.index] = ""
 	}
-
-	// Broadcast all PTY paths to WebSocket clients
+	ptyPathsMu.Unlock()
 	broadcastPTYPaths()
-
-	log.Println("[RIG-PTY] Use these paths in WSJT-X/JTDX/HAMLOG")
-
-	wg.Wait()
 }
 
-// runSinglePortPTYIndependent handles a single COM port with its own independent PTY
-func runSinglePortPTYIndependent(index int, port string, com serial.Port, ptmx *os.File) {
-	var protoMu sync.Mutex
-	var proto RigProto
-	var catBuf strings.Builder
-
-	// PTY書き込み用チャネル（ブロック防止）
-	ptyWriteChan := make(chan []byte, 100)
-
-	// Goroutine: PTY書き込みワーカー
-	go func() {
-		for data := range ptyWriteChan {
-			_, err := ptmx.Write(data)
-			if err != nil {
-				log.Printf("[RIG-PTY-%d] PTY write error: %v", index, err)
-				return
-			}
-		}
-	}()
-
-	// Initial CI-V probe
-	go func() {
-		time.Sleep(300 * time.Millisecond)
-		protoMu.Lock()
-		currentProto := proto
-		protoMu.Unlock()
-		if currentProto == ProtoUnknown {
-			log.Printf("[RIG-PTY-%d] initial poll: CI-V", index)
-			civInitialPoll(com)
-		}
-		time.Sleep(700 * time.Millisecond)
-		protoMu.Lock()
-		if proto == ProtoUnknown {
-			proto = ProtoCAT
-			log.Printf("[RIG-PTY-%d] fallback to CAT", index)
-			startCATPollerPTYForPort(index, com)
-		}
-		protoMu.Unlock()
-	}()
+// attach routes the PTY to an open COM port until detach is called.
+func (b *ptyBridge) attach(com serial.Port) (detach func()) {
+	b.mu.Lock()
+	b.com = com
+	b.mu.Unlock()
+	return func() {
+		b.mu.Lock()
+		b.com = nil
+		b.mu.Unlock()
+	}
+}
 
-	// Goroutine: PTY → COM (commands from external app to this rig)
-	go func() {
-		buf := make([]byte, 256)
-		for {
-			n, err := ptmx.Read(buf)
-			if err != nil {
-				if err != io.EOF {
-					log.Printf("[RIG-PTY-%d] PTY read error: %v", index, err)
-				}
-				return
-			}
-			if n > 0 {
-				_, err := com.Write(buf[:n])
-				if err != nil {
-					log.Printf("[RIG-PTY-%d] COM write error: %v", index, err)
-					return
-				}
-			}
-		}
-	}()
+func (b *ptyBridge) current() serial.Port {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.com
+}
 
-	// Main loop: COM → PTY (responses from rig to external app)
+// readLoop copies commands from the applications to the COM port (PTY →
+// COM) until the PTY is closed.
+func (b *ptyBridge) readLoop() {
 	buf := make([]byte, 256)
 	for {
-		// 停止フラグチェック
-		ptyStopMu.Lock()
-		if ptyStopFlag {
-			ptyStopMu.Unlock()
-			log.Printf("[RIG-PTY-%d] stopping (restart requested)", index)
-			return
-		}
-		ptyStopMu.Unlock()
-
-		n, err := com.Read(buf)
+		n, err := b.ptmx.Read(buf)
 		if err != nil {
-			log.Printf("[RIG-PTY-%d] COM read error: %v", index, err)
+			if err != io.EOF && !errors.Is(err, os.ErrClosed) {
+				log.Printf("[RIG-PTY-%d] PTY read error: %v", b.index, err)
+			}
 			return
 		}
-		if n == 0 {
-			continue
+		com := b.current()
+		if n == 0 || com == nil {
+			continue // COM ポートの切断中は捨てる
 		}
-
-		data := buf[:n]
-
-		// Forward to PTY (pass-through, non-blocking)
-		dataCopy := make([]byte, n)
-		copy(dataCopy, data)
-		select {
-		case ptyWriteChan <- dataCopy:
-		default:
-			// バッファフル時は破棄（COMの読み取りをブロックしない）
+		notePTYWrite(b.index)
+		if _, err := com.Write(buf[:n]); err != nil && b.current() == com {
+			log.Printf("[RIG-PTY-%d] COM write error: %v", b.index, err)
 		}
+	}
+}
 
-		// Analyze data (mirror mode - same as direct connection)
-		protoMu.Lock()
-		if proto == ProtoUnknown {
-			proto = detectProto(data)
-			if proto != ProtoUnknown {
-				log.Printf("[RIG-PTY-%d] detected protocol: %s", index, proto)
-				if proto == ProtoCAT {
-					startCATPollerPTYForPort(index, com)
-				}
-			}
-		}
-		currentProto := proto
-		protoMu.Unlock()
-
-		switch currentProto {
-		case ProtoCIV:
-			handleCIVForPort(index, data)
-
-		case ProtoCAT:
-			catBuf.Write(data)
-			for {
-				full := catBuf.String()
-				idx := strings.Index(full, ";")
-				if idx < 0 {
-					break
+// writeLoop copies the rig's output queued by forward to the PTY (COM →
+// PTY) until ctx is cancelled.
+func (b *ptyBridge) writeLoop(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case data := <-b.out:
+			if _, err := b.ptmx.Write(data); err != nil {
+				if ctx.Err() != nil || errors.Is(err, os.ErrClosed) {
+					return
 				}
-				cmd := full[:idx+1]
-				rest := full[idx+1:]
-				catBuf.Reset()
-				catBuf.WriteString(rest)
-				handleCATCommandPTY(index, strings.TrimSuffix(cmd, ";"))
+				log.Printf("[RIG-PTY-%d] PTY write error: %v", b.index, err)
 			}
 		}
 	}
 }
 
-// startCATPollerPTYForPort starts CAT polling in PTY mode for a specific port.
-// If the rig doesn't respond within timeout, it falls back to polling mode
-// for older rigs that don't support AI1 (e.g., FT-817, FT-857, TS-2000).
-func startCATPollerPTYForPort(index int, s serial.Port) {
-	// CATプロトコル=YAESU/KENWOODなのでAI1を送信
-	_, _ = s.Write([]byte("AI1;FA;MD0;"))
-	log.Printf("[RIG-PTY-%d] CAT Auto Information enabled", index)
-
-	// Wait and check if AI1 is working
-	go func() {
-		time.Sleep(2 * time.Second)
-
-		// Check if we received any data
-		rigStatesMu.RLock()
-		state := rigStates[index]
-		hasData := state != nil && state.Freq > 0
-		rigStatesMu.RUnlock()
-
-		if !hasData {
-			// AI1 not working, start polling mode
-			log.Printf("[RIG-PTY-%d] AI1 not responding, starting polling mode (legacy rig support)", index)
-			startCATPollingLoopPTY(index, s)
-		}
-	}()
+// forward queues a chunk read from the rig for the PTY. It never blocks the
+// COM reader; the chunk is dropped when the queue is full.
+func (b *ptyBridge) forward(data []byte) {
+	dataCopy := make([]byte, len(data))
+	copy(dataCopy, data)
+	select {
+	case b.out <- dataCopy:
+	default:
+		// バッファフル時は破棄（COMの読み取りをブロックしない）
+	}
 }
 
-// startCATPollingLoopPTY starts a polling loop for older rigs in PTY mode.
-func startCATPollingLoopPTY(index int, s serial.Port) {
-	ticker := time.NewTicker(2 * time.Second)
-	defer ticker.Stop()
+// runCOM opens the COM port of the bridge and serves it until it fails or
+// ctx is cancelled. It is run by superviseRigPort.
+func (b *ptyBridge) runCOM(ctx context.Context, index int, rp RigPortConfig, opened func()) error {
+	port, baud := rp.Port, rp.Baud
+	mode := &serial.Mode{
+		BaudRate: baud,
+		DataBits: 8,
+		Parity:   serial.NoParity,
+		StopBits: serial.OneStopBit,
+	}
+
+	s, err := serial.Open(port, mode)
+	if err != nil {
+		log.Printf("[RIG-PTY-%d] COM open error: %v", index, err)
+		return err
+	}
+	com := &rigPort{Port: s, index: index, name: port}
+	// 抜去を検出するため 1 秒ごとに読み取りから戻る
+	_ = com.SetReadTimeout(time.Second)
+	log.Printf("[RIG-PTY-%d] COM opened: %s → PTY: %s", index, port, b.tty.Name())
 
-	for range ticker.C {
-		// Check if port is still valid
+	// グローバルに保存
+	currentRigPortsMu.Lock()
+	currentRigPorts[index] = com
+	currentRigPortsMu.Unlock()
+	defer func() {
 		currentRigPortsMu.Lock()
-		_, exists := currentRigPorts[index]
+		delete(currentRigPorts, index)
 		currentRigPortsMu.Unlock()
-		if !exists {
-			log.Printf("[RIG-PTY-%d] polling stopped (port closed)", index)
-			return
-		}
+	}()
 
-		_, _ = s.Write([]byte("FA;MD0;"))
+	rigStatesMu.Lock()
+	rigStates[index] = &RigState{Index: index}
+	rigStatesMu.Unlock()
+	d := rigDriverForProfile(rp.Profile)
+	setRigDriver(index, d, d == nil)
+	pinCIVAddr(index, rp.CIVAddr)
+	if d != nil {
+		logRigDriver(index, d, "profile")
 	}
-}
 
-// handleCATCommandPTY handles CAT commands for PTY mode
-func handleCATCommandPTY(index int, cmd string) {
-	if len(cmd) < 2 {
-		return
-	}
+	detach := b.attach(com)
+	defer detach()
+	opened()
 
-	if !shouldBroadcastFromPort(index) {
-		return
-	}
+	g, pctx := errgroup.WithContext(ctx)
 
-	switch {
-	case strings.HasPrefix(cmd, "IF"):
-		parseIFForPortPTY(index, cmd)
+	// 停止または失敗で COM を閉じ、読み取りを終わらせる
+	g.Go(func() error {
+		<-pctx.Done()
+		com.Close()
+		return nil
+	})
 
-	case strings.HasPrefix(cmd, "FA"):
-		if freq := parseCATFreq(cmd); freq > 0 {
-			updateRigStateForPort(index, freq, "", false, ProtoCAT)
-		}
+	// COM → PTY (responses from rig to external app), analyzed as in the
+	// direct connection
+	serveRigPort(pctx, g, index, com, b.forward)
 
-	case strings.HasPrefix(cmd, "MD"):
-		if mode, data := parseCATMode(cmd); mode != "" {
-			updateRigStateForPort(index, 0, mode, data, ProtoCAT)
-		}
+	err = g.Wait()
+	if ctx.Err() != nil {
+		log.Printf("[RIG-PTY-%d] stopping (restart requested)", index)
+		return errRigStopped
 	}
+	return err
 }
 
-// parseIFForPortPTY parses IF command for PTY mode
-func parseIFForPortPTY(index int, cmd string) {
-	if len(cmd) < 30 {
-		return
+// pollablePTY returns the PTY master in non-blocking mode, so that closing
+// it ends a pending Read. pty.Open leaves it blocking.
+func pollablePTY(ptmx *os.File) (*os.File, error) {
+	defer ptmx.Close()
+	fd, err := syscall.Dup(int(ptmx.Fd()))
+	if err != nil {
+		return nil, err
 	}
-
-	// 周波数
-	freqStr := cmd[5:16] // P2
-	var hz int64
-	for _, c := range freqStr {
-		if c < '0' || c > '9' {
-			return
-		}
-		hz = hz*10 + int64(c-'0')
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return nil, err
 	}
-
-	// モード
-	modeCode := cmd[26:27] // P6
-	mode, data := parseCATMode("MD0" + modeCode)
-
-	updateRigStateForPort(index, hz, mode, data, ProtoCAT)
+	return os.NewFile(uintptr(fd), ptmx.Name()), nil
 }
 
 // broadcastPTYPaths sends the PTY paths to WebSocket clients
@@ -433,10 +331,8 @@ func broadcastPTYPaths() {
 	copy(paths, ptyPaths)
 	ptyPathsMu.RUnlock()
 
-	ev := map[string]interface{}{
-		"type":  "pty",
-		"paths": paths,
-	}
-	b, _ := json.Marshal(ev)
-	broadcast(string(b))
+	broadcast(&PTYEvent{
+		Envelope: newEnvelope("pty", ""),
+		Paths:    paths,
+	})
 }
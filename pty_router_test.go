@@ -0,0 +1,101 @@
+//go:build darwin || linux
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// The PTY of a port keeps its path while the COM port is closed and
+// reopened, and drops what the application writes in between.
+func TestPTYBridgeReconnect(t *testing.T) {
+	const index = 94
+	b, err := openPTYBridge(index)
+	if err != nil {
+		t.Skipf("no PTY: %v", err)
+	}
+	ptyPathsMu.Lock()
+	saved := ptyPaths
+	ptyPaths = make([]string, index+1)
+	ptyPathsMu.Unlock()
+	t.Cleanup(func() {
+		ptyPathsMu.Lock()
+		ptyPaths = saved
+		ptyPathsMu.Unlock()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		b.serve(ctx, func() { <-ctx.Done() })
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	path := b.tty.Name()
+	waitFor(t, "PTY path", func() bool { return GetPTYPaths()[index] == path })
+
+	app, err := os.OpenFile(path, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer app.Close()
+	lines := make(chan string, 1)
+	go func() {
+		if s, err := bufio.NewReader(app).ReadString('\n'); err == nil {
+			lines <- s
+		}
+	}()
+
+	// COM ポートが閉じている間の書き込みは捨てる
+	if _, err := app.Write([]byte("FA;")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	for i := 0; i < 2; i++ {
+		com := newTestRigPort(t, index, RigState{})
+		detach := b.attach(com)
+		if _, err := app.Write([]byte("IF;")); err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, "command on COM", func() bool {
+			com.mu.Lock()
+			defer com.mu.Unlock()
+			return len(com.written) > 0
+		})
+		if got := strings.Join(com.take(), ""); got != "IF;" {
+			t.Errorf("connection %d: COM got %q, want \"IF;\"", i, got)
+		}
+		detach()
+		if got := GetPTYPaths()[index]; got != path {
+			t.Fatalf("PTY path after disconnect = %q, want %q", got, path)
+		}
+	}
+
+	// 無線機の応答は PTY へ（正規モードのため改行で終える）
+	b.forward([]byte("FA00014074000;\n"))
+	select {
+	case s := <-lines:
+		if s != "FA00014074000;\n" {
+			t.Errorf("application read %q", s)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out reading the PTY")
+	}
+
+	cancel()
+	<-done
+	if got := GetPTYPaths()[index]; got != "" {
+		t.Errorf("PTY path after stop = %q", got)
+	}
+}
@@ -20,11 +20,15 @@ type qrzResponse struct {
 }
 
 type qrzCall struct {
-	Call  string `xml:"call"`
-	Fname string `xml:"fname"`
-	Name  string `xml:"name"`
-	Addr2 string `xml:"addr2"`
-	Grid  string `xml:"grid"`
+	Call    string `xml:"call"`
+	Fname   string `xml:"fname"`
+	Name    string `xml:"name"`
+	Addr2   string `xml:"addr2"`
+	Grid    string `xml:"grid"`
+	State   string `xml:"state"`
+	County  string `xml:"county"`
+	DXCC    string `xml:"dxcc"`
+	Country string `xml:"country"`
 }
 
 var qrzKey string
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const qslIndexFile = "qsl_index.json"
+
+const (
+	qslSyncInterval = 6 * time.Hour
+	qslSyncDelay    = time.Minute      // 起動直後は送信処理を優先
+	qslMatchWindow  = 30 * time.Minute // 交信時刻の許容差（LoTW と同じ）
+	qslInitialSince = 30 * 24 * time.Hour
+)
+
+// QSLFetcher is implemented by uploaders whose service can report QSL
+// confirmations. FetchQSL returns the confirmed QSOs received since the
+// given time; the caller matches them against the QSO journal.
+type QSLFetcher interface {
+	FetchQSL(c LogbookConfig, since time.Time) ([]*QSO, error)
+}
+
+// qslIndex keeps where the next download of each service starts. The
+// confirmations themselves are stored in the QSO journal.
+type qslIndex struct {
+	mu    sync.Mutex
+	Since map[string]time.Time `json:"since"` // service → 次回の取得開始日時
+}
+
+// qslIdx is opened by openAppData.
+var qslIdx *qslIndex
+
+// qslSyncMu prevents the periodic and manual syncs from overlapping.
+var qslSyncMu sync.Mutex
+
+func qslIndexPath() string {
+	return filepath.Join(appDataDir(), qslIndexFile)
+}
+
+// newQSLIndex returns the index loaded from "qsl_index.json".
+func newQSLIndex() *qslIndex {
+	idx := &qslIndex{Since: map[string]time.Time{}}
+	if b, err := os.ReadFile(qslIndexPath()); err == nil {
+		if err := json.Unmarshal(b, idx); err != nil {
+			log.Println("[QSL] index load error:", err)
+		}
+	}
+	if idx.Since == nil {
+		idx.Since = map[string]time.Time{}
+	}
+	return idx
+}
+
+// save writes the index file. The caller must hold idx.mu.
+func (idx *qslIndex) save() {
+	b, _ := json.MarshalIndent(idx, "", "  ")
+	tmp := qslIndexPath() + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		log.Println("[QSL] index save error:", err)
+		return
+	}
+	if err := os.Rename(tmp, qslIndexPath()); err != nil {
+		log.Println("[QSL] index save error:", err)
+	}
+}
+
+// since returns where the next fetch for service starts: the last sync,
+// or 30 days before the oldest journaled QSO the first time.
+func (idx *qslIndex) since(service string) time.Time {
+	idx.mu.Lock()
+	t, ok := idx.Since[service]
+	idx.mu.Unlock()
+	if ok {
+		return t
+	}
+
+	t = time.Now().Add(-qslInitialSince)
+	if oldest := qsoJournal.oldest(); !oldest.IsZero() && oldest.Before(t) {
+		t = oldest
+	}
+	return t.UTC().Truncate(24 * time.Hour)
+}
+
+// apply matches confirmations from service against the journal, records
+// the new ones and returns the entries that became confirmed. The next
+// fetch starts one day before syncStart so that confirmations processed
+// late are not missed.
+func (idx *qslIndex) apply(service string, confirmed []*QSO, syncStart time.Time) []*JournalEntry {
+	var matched []*JournalEntry
+	seen := map[uint64]bool{}
+	for _, c := range confirmed {
+		e := qslMatch(c)
+		if e == nil || seen[e.ID] {
+			continue
+		}
+		if _, ok := e.Confirmations[service]; ok {
+			continue
+		}
+		seen[e.ID] = true
+
+		at := qslReceivedDate(c)
+		qsoJournal.setConfirmation(e.ID, service, at)
+		if e.Confirmations == nil {
+			e.Confirmations = map[string]time.Time{}
+		}
+		e.Confirmations[service] = at
+		matched = append(matched, e)
+	}
+
+	idx.mu.Lock()
+	idx.Since[service] = syncStart.UTC().Add(-24 * time.Hour)
+	idx.save()
+	idx.mu.Unlock()
+	return matched
+}
+
+// qslMatch returns the journaled QSO a confirmation belongs to: same call,
+// same band, compatible mode and start times within qslMatchWindow.
+func qslMatch(c *QSO) *JournalEntry {
+	var best *JournalEntry
+	var bestDiff time.Duration
+	qsoJournal.forEach(func(e *JournalEntry) bool {
+		q := e.QSO
+		if q == nil || !strings.EqualFold(q.Call, c.Call) {
+			return true
+		}
+		if q.Band != "" && c.Band != "" && !strings.EqualFold(q.Band, c.Band) {
+			return true
+		}
+		if !qslModeMatch(q, c) {
+			return true
+		}
+		diff := q.TimeOn.Sub(c.TimeOn)
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff > qslMatchWindow {
+			return true
+		}
+		if best == nil || diff < bestDiff {
+			best, bestDiff = e, diff
+		}
+		return true
+	})
+	return best
+}
+
+// qslModeMatch compares modes loosely: services report either the ADIF mode
+// (MFSK) or the submode (FT4) and LoTW reports mode groups such as "DATA".
+func qslModeMatch(q, c *QSO) bool {
+	cm := strings.ToUpper(c.Mode)
+	cs := strings.ToUpper(c.Submode)
+	if cm == "" || q.Mode == "" {
+		return true
+	}
+	for _, a := range []string{q.Mode, q.Submode} {
+		a = strings.ToUpper(a)
+		if a != "" && (a == cm || a == cs) {
+			return true
+		}
+	}
+	return cm == "DATA" || cm == "PHONE" || cm == "IMAGE"
+}
+
+// qslReceivedDate returns QSLRDATE (or LOTW/EQSL variants) of a
+// confirmation, or now when the service does not report it.
+func qslReceivedDate(c *QSO) time.Time {
+	for _, name := range []string{"QSLRDATE", "LOTW_QSLRDATE", "EQSL_QSLRDATE"} {
+		for _, f := range c.Extra {
+			if strings.EqualFold(f.Name, name) {
+				if t, err := time.Parse("20060102", f.Value); err == nil {
+					return t
+				}
+			}
+		}
+	}
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+// qslConfirmedField reports whether an ADIF record from a service download
+// carries a received confirmation ("Y" or "V"erified) in one of the given fields.
+func qslConfirmedField(q *QSO, names ...string) bool {
+	for _, f := range q.Extra {
+		for _, name := range names {
+			if !strings.EqualFold(f.Name, name) {
+				continue
+			}
+			switch strings.ToUpper(strings.TrimSpace(f.Value)) {
+			case "Y", "V":
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// startQSLSync periodically downloads confirmations while enabled.
+func startQSLSync() {
+	time.Sleep(qslSyncDelay)
+	for {
+		configLock.RLock()
+		enabled := config.QSLSync
+		configLock.RUnlock()
+
+		if enabled {
+			syncQSL()
+		}
+		time.Sleep(qslSyncInterval)
+	}
+}
+
+// syncQSL fetches confirmations from every enabled service that supports
+// it and broadcasts a qslConfirmed event for each newly confirmed QSO.
+func syncQSL() {
+	qslSyncMu.Lock()
+	defer qslSyncMu.Unlock()
+
+	for _, u := range uploaders {
+		f, ok := u.(QSLFetcher)
+		if !ok {
+			continue
+		}
+		lc, ok := logbookConfig(u.Type())
+		if !ok || !lc.Enabled {
+			continue
+		}
+
+		start := time.Now()
+		since := qslIdx.since(u.Type())
+		confirmed, err := f.FetchQSL(lc, since)
+		if errors.Is(err, errQSLNotConfigured) {
+			continue
+		}
+		if err != nil {
+			log.Printf("[QSL] %s: fetch error: %v", u.Name(), err)
+			continue
+		}
+
+		matched := qslIdx.apply(u.Type(), confirmed, start)
+		log.Printf("[QSL] %s: %d confirmations since %s, %d new", u.Name(), len(confirmed), since.Format(time.DateOnly), len(matched))
+
+		for _, e := range matched {
+			broadcast(&QSLConfirmedEvent{
+				Envelope:    newEnvelope("qslConfirmed", ""),
+				JournalID:   e.ID,
+				Service:     u.Type(),
+				Call:        e.QSO.Call,
+				Band:        e.QSO.Band,
+				Mode:        e.QSO.Mode,
+				TimeOn:      e.QSO.TimeOn.UTC().Format(time.RFC3339),
+				ConfirmedAt: e.Confirmations[u.Type()].Format(time.RFC3339),
+			})
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Service endpoints used for downloading confirmations.
+var (
+	lotwReportURL  = "https://lotw.arrl.org/lotwuser/lotwreport.adi"
+	eqslInboxURL   = "https://www.eqsl.cc/qslcard/DownloadInBox.cfm"
+	qrzLogbookURL  = "https://logbook.qrz.com/api"
+	clublogADIFURL = "https://clublog.org/getadif.php"
+)
+
+// maxQSLDownload limits the size of a downloaded ADIF file.
+const maxQSLDownload = 32 << 20
+
+// errQSLNotConfigured is returned when the credentials needed for
+// downloading confirmations are missing. It is not logged as an error.
+var errQSLNotConfigured = errors.New("QSL download not configured")
+
+// fetchQSLText sends req and returns the response body.
+func fetchQSLText(req *http.Request) (string, error) {
+	client := &http.Client{Timeout: 2 * time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxQSLDownload))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("HTTP %d: %s", resp.StatusCode, summarizeResponse(string(b)))
+	}
+	return string(b), nil
+}
+
+func getQSLText(rawURL string) (string, error) {
+	req, err := http.NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return "", err
+	}
+	return fetchQSLText(req)
+}
+
+func postQSLForm(rawURL string, values url.Values) (string, error) {
+	req, err := http.NewRequest("POST", rawURL, strings.NewReader(values.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return fetchQSLText(req)
+}
+
+// FetchQSL downloads LoTW QSL records (lotwreport.adi) confirmed since
+// the given date. It uses the LoTW web account, not the certificate.
+func (lotwUploader) FetchQSL(c LogbookConfig, since time.Time) ([]*QSO, error) {
+	user, pass := c.Credentials["lotw_user"], c.Credentials["lotw_pass"]
+	if user == "" || pass == "" {
+		return nil, errQSLNotConfigured
+	}
+
+	params := url.Values{
+		"login":         {user},
+		"password":      {pass},
+		"qso_query":     {"1"},
+		"qso_qsl":       {"yes"},
+		"qso_qsldetail": {"yes"},
+		"qso_qslsince":  {since.UTC().Format(time.DateOnly)},
+	}
+	body, err := getQSLText(lotwReportURL + "?" + params.Encode())
+	if err != nil {
+		return nil, err
+	}
+	// ログイン失敗時はHTMLページが返る
+	if !strings.Contains(strings.ToLower(body), "<eoh>") {
+		return nil, fmt.Errorf("unexpected response (login failed?): %s", summarizeResponse(body))
+	}
+
+	return confirmedQSOs(body, "QSL_RCVD")
+}
+
+var eqslDownloadLinkRe = regexp.MustCompile(`(?i)href\s*=\s*"([^"]+\.adi)"`)
+
+// FetchQSL downloads the eQSL inbox received since the given date. Every
+// card in the inbox confirms a QSO.
+func (eqslUploader) FetchQSL(c LogbookConfig, since time.Time) ([]*QSO, error) {
+	user, pass := c.Credentials["user"], c.Credentials["pass"]
+	if user == "" || pass == "" {
+		return nil, errQSLNotConfigured
+	}
+
+	params := url.Values{
+		"UserName":  {user},
+		"Password":  {pass},
+		"RcvdSince": {since.UTC().Format("20060102")},
+	}
+	page, err := getQSLText(eqslInboxURL + "?" + params.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	// 結果ページ内のリンクからADIFファイルを取得する
+	m := eqslDownloadLinkRe.FindStringSubmatch(page)
+	if m == nil {
+		text := htmlToText(page)
+		if e := eqslErrorRe.FindStringSubmatch(text); e != nil {
+			if reason := strings.ToLower(e[1]); strings.Contains(reason, "no records") || strings.Contains(reason, "no log entries") {
+				return nil, nil
+			}
+			return nil, errors.New(strings.TrimSpace(e[1]))
+		}
+		return nil, fmt.Errorf("unexpected response: %s", summarizeResponse(page))
+	}
+	base, _ := url.Parse(eqslInboxURL)
+	link, err := base.Parse(m[1])
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := getQSLText(link.String())
+	if err != nil {
+		return nil, err
+	}
+	return parseQSOs(body)
+}
+
+// FetchQSL downloads the confirmed QRZ Logbook entries modified since the
+// given date with the FETCH action.
+func (qrzUploader) FetchQSL(c LogbookConfig, since time.Time) ([]*QSO, error) {
+	key := c.Credentials["api_key"]
+	if key == "" {
+		return nil, errQSLNotConfigured
+	}
+
+	body, err := postQSLForm(qrzLogbookURL, url.Values{
+		"KEY":    {key},
+		"ACTION": {"FETCH"},
+		"OPTION": {"TYPE:ADIF,STATUS:CONFIRMED,MODSINCE:" + since.UTC().Format(time.DateOnly)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// ADIF= 以降はHTMLエスケープされたADIFで & を含むため分けて解析する
+	head, adif, _ := strings.Cut(body, "ADIF=")
+	v := parseQRZReply(strings.TrimRight(head, "&"))
+	switch strings.ToUpper(v["RESULT"]) {
+	case "OK":
+	case "FAIL":
+		if v["COUNT"] == "0" || strings.Contains(strings.ToLower(v["REASON"]), "no log entries") {
+			return nil, nil
+		}
+		return nil, errors.New(v["REASON"])
+	default:
+		return nil, fmt.Errorf("unexpected response: %s", summarizeResponse(body))
+	}
+
+	if i := strings.Index(adif, "&LOGIDS="); i >= 0 {
+		adif = adif[:i]
+	}
+	return parseQSOs(html.UnescapeString(adif))
+}
+
+// FetchQSL downloads the ClubLog log (getadif.php, API key required) and
+// returns the QSOs confirmed by a QSL card recorded in ClubLog (QSL_RCVD)
+// since the given date. getadif.php has no date filter, so the records are
+// filtered by QSLRDATE; those without one are kept and deduplicated by the
+// QSL index. LoTW confirmations in the log (LOTW_QSL_RCVD) are left to the
+// LoTW fetcher.
+func (clublogUploader) FetchQSL(c LogbookConfig, since time.Time) ([]*QSO, error) {
+	if c.Credentials["api_key"] == "" || c.Credentials["email"] == "" || c.Credentials["password"] == "" {
+		return nil, errQSLNotConfigured
+	}
+
+	body, err := postQSLForm(clublogADIFURL, url.Values{
+		"email":    {c.Credentials["email"]},
+		"password": {c.Credentials["password"]},
+		"call":     {c.Options["callsign"]},
+		"api":      {c.Credentials["api_key"]},
+	})
+	if err != nil {
+		return nil, err
+	}
+	confirmed, err := confirmedQSOs(body, "QSL_RCVD")
+	if err != nil {
+		return nil, err
+	}
+
+	since = since.UTC().Truncate(24 * time.Hour)
+	var recent []*QSO
+	for _, q := range confirmed {
+		if at, ok := qslRcvdDate(q); ok && at.Before(since) {
+			continue
+		}
+		recent = append(recent, q)
+	}
+	return recent, nil
+}
+
+// qslRcvdDate returns the QSLRDATE of a record, if it has a valid one.
+func qslRcvdDate(q *QSO) (time.Time, bool) {
+	for _, f := range q.Extra {
+		if strings.EqualFold(f.Name, "QSLRDATE") {
+			t, err := time.Parse("20060102", strings.TrimSpace(f.Value))
+			return t, err == nil
+		}
+	}
+	return time.Time{}, false
+}
+
+// confirmedQSOs parses an ADIF download and keeps the records confirmed in
+// one of the given fields.
+func confirmedQSOs(adif string, fields ...string) ([]*QSO, error) {
+	qsos, err := parseQSOs(adif)
+	if err != nil {
+		return nil, err
+	}
+	var confirmed []*QSO
+	for _, q := range qsos {
+		if qslConfirmedField(q, fields...) {
+			confirmed = append(confirmed, q)
+		}
+	}
+	return confirmed, nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// qslTestServer serves the fixture files in testdata/qsl by path and
+// records the form of each request.
+type qslTestServer struct {
+	*httptest.Server
+	files map[string]string // パス → testdata/qsl のファイル
+	forms []url.Values
+}
+
+// newQSLTestServer starts a server and points the URL variable u at path
+// on it.
+func newQSLTestServer(t *testing.T, u *string, path string, files map[string]string) *qslTestServer {
+	t.Helper()
+	s := &qslTestServer{files: files}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		s.forms = append(s.forms, r.Form)
+		name, ok := s.files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		b, err := os.ReadFile("testdata/qsl/" + name)
+		if err != nil {
+			t.Errorf("fixture: %v", err)
+		}
+		w.Write(b)
+	}))
+	old := *u
+	*u = s.URL + path
+	t.Cleanup(func() {
+		*u = old
+		s.Close()
+	})
+	return s
+}
+
+// qslCalls returns the callsigns of the fetched QSOs.
+func qslCalls(qsos []*QSO) []string {
+	var calls []string
+	for _, q := range qsos {
+		calls = append(calls, q.Call)
+	}
+	return calls
+}
+
+var qslTestSince = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestLoTWFetchQSL(t *testing.T) {
+	s := newQSLTestServer(t, &lotwReportURL, "/lotwuser/lotwreport.adi",
+		map[string]string{"/lotwuser/lotwreport.adi": "lotwreport.adi"})
+	c := LogbookConfig{Credentials: map[string]string{"lotw_user": "ja1test", "lotw_pass": "pw"}}
+
+	qsos, err := lotwUploader{}.FetchQSL(c, qslTestSince)
+	if err != nil {
+		t.Fatalf("FetchQSL: %v", err)
+	}
+	if got := qslCalls(qsos); !reflect.DeepEqual(got, []string{"K1ABC", "W1AW"}) {
+		t.Errorf("calls = %q", got)
+	}
+	if q := qsos[0]; q.Band != "20M" || q.Mode != "FT8" || !q.TimeOn.Equal(time.Date(2024, 1, 2, 12, 30, 0, 0, time.UTC)) {
+		t.Errorf("QSO = %+v", q)
+	}
+	if got := qslReceivedDate(qsos[0]); !got.Equal(time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("received = %s", got)
+	}
+	f := s.forms[0]
+	if f.Get("login") != "ja1test" || f.Get("qso_qsl") != "yes" || f.Get("qso_qslsince") != "2024-01-01" {
+		t.Errorf("query = %v", f)
+	}
+
+	// ログイン失敗時は HTML が返る
+	s.files["/lotwuser/lotwreport.adi"] = "eqsl_inbox_empty.html"
+	if _, err := (lotwUploader{}).FetchQSL(c, qslTestSince); err == nil {
+		t.Error("FetchQSL with a login page succeeded")
+	}
+
+	if _, err := (lotwUploader{}).FetchQSL(LogbookConfig{}, qslTestSince); err != errQSLNotConfigured {
+		t.Errorf("FetchQSL without credentials = %v", err)
+	}
+}
+
+func TestEQSLFetchQSL(t *testing.T) {
+	s := newQSLTestServer(t, &eqslInboxURL, "/qslcard/DownloadInBox.cfm", map[string]string{
+		"/qslcard/DownloadInBox.cfm":                 "eqsl_inbox.html",
+		"/qslcard/downloadedfiles/ja1test_12345.adi": "eqsl.adi",
+	})
+	c := LogbookConfig{Credentials: map[string]string{"user": "JA1TEST", "pass": "pw"}}
+
+	qsos, err := eqslUploader{}.FetchQSL(c, qslTestSince)
+	if err != nil {
+		t.Fatalf("FetchQSL: %v", err)
+	}
+	if got := qslCalls(qsos); !reflect.DeepEqual(got, []string{"JH1XYZ"}) {
+		t.Errorf("calls = %q", got)
+	}
+	if got := qslReceivedDate(qsos[0]); !got.Equal(time.Date(2024, 1, 7, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("received = %s", got)
+	}
+	if f := s.forms[0]; f.Get("UserName") != "JA1TEST" || f.Get("RcvdSince") != "20240101" {
+		t.Errorf("query = %v", f)
+	}
+
+	s.files["/qslcard/DownloadInBox.cfm"] = "eqsl_inbox_empty.html"
+	if qsos, err := (eqslUploader{}).FetchQSL(c, qslTestSince); err != nil || len(qsos) != 0 {
+		t.Errorf("FetchQSL of an empty inbox = %v, %v", qsos, err)
+	}
+}
+
+func TestQRZFetchQSL(t *testing.T) {
+	s := newQSLTestServer(t, &qrzLogbookURL, "/api", map[string]string{"/api": "qrz_fetch.txt"})
+	c := LogbookConfig{Credentials: map[string]string{"api_key": "KEY"}}
+
+	qsos, err := qrzUploader{}.FetchQSL(c, qslTestSince)
+	if err != nil {
+		t.Fatalf("FetchQSL: %v", err)
+	}
+	if len(qsos) != 1 || qsos[0].Call != "K1ABC" || qsos[0].Comment != "73 & tnx" {
+		t.Errorf("QSOs = %+v", qsos)
+	}
+	if f := s.forms[0]; f.Get("ACTION") != "FETCH" || f.Get("OPTION") != "TYPE:ADIF,STATUS:CONFIRMED,MODSINCE:2024-01-01" {
+		t.Errorf("form = %v", f)
+	}
+
+	s.files["/api"] = "qrz_fetch_empty.txt"
+	if qsos, err := (qrzUploader{}).FetchQSL(c, qslTestSince); err != nil || len(qsos) != 0 {
+		t.Errorf("FetchQSL of no entries = %v, %v", qsos, err)
+	}
+}
+
+func TestClubLogFetchQSL(t *testing.T) {
+	s := newQSLTestServer(t, &clublogADIFURL, "/getadif.php", map[string]string{"/getadif.php": "clublog.adi"})
+	c := LogbookConfig{
+		Credentials: map[string]string{"email": "ja1test@example.com", "password": "pw", "api_key": "KEY"},
+		Options:     map[string]string{"callsign": "JA1TEST"},
+	}
+
+	qsos, err := clublogUploader{}.FetchQSL(c, qslTestSince)
+	if err != nil {
+		t.Fatalf("FetchQSL: %v", err)
+	}
+	// W1AW は前回取得日より前の受領、JH1XYZ は LoTW の確認のみ、ZL1ABC は請求中
+	if got := qslCalls(qsos); !reflect.DeepEqual(got, []string{"K1ABC", "VK2ABC"}) {
+		t.Errorf("calls = %q", got)
+	}
+	if f := s.forms[0]; f.Get("call") != "JA1TEST" || f.Get("api") != "KEY" {
+		t.Errorf("form = %v", f)
+	}
+}
@@ -2,7 +2,7 @@ package main
 
 import (
 	"bytes"
-	"encoding/json"
+	"context"
 	"log"
 	"runtime"
 	"strings"
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"go.bug.st/serial"
+	"golang.org/x/sync/errgroup"
 )
 
 type RigProto string
@@ -39,6 +40,7 @@ type RigState struct {
 	Freq  int64
 	Mode  RigMode // USB / LSB / FM / CW / AM
 	Data  bool    // DATA ON / OFF
+	PTT   bool    // 送信中
 	Proto RigProto
 	Index int // ポートインデックス
 }
@@ -100,44 +102,16 @@ var lastActivePort int = -1
 var lastActivePortMu sync.Mutex
 var lastActiveTime time.Time // 最後にアクティブになった時刻
 
-var currentRigPort serial.Port
-var currentRigPortMu sync.Mutex
-
 // 複数ポート対応
 var currentRigPorts = make(map[int]serial.Port)
 var currentRigPortsMu sync.Mutex
 
-// 再起動制御
-var rigStopFlag bool
-var rigStopMu sync.Mutex
-
-var pendingMode string
-var pendingData bool
-
 // ---- public entry ----
 
-// stopRigWatcher stops all running rig watchers
+// stopRigWatcher stops all running rig watchers and waits until their
+// ports are closed.
 func stopRigWatcher() {
-	rigStopMu.Lock()
-	rigStopFlag = true
-	rigStopMu.Unlock()
-
-	// 既存の接続を全てクローズ
-	currentRigPortsMu.Lock()
-	for _, port := range currentRigPorts {
-		if port != nil {
-			port.Close()
-		}
-	}
-	currentRigPortsMu.Unlock()
-
-	// 少し待機してgoroutineが終了するのを待つ
-	time.Sleep(500 * time.Millisecond)
-
-	rigStopMu.Lock()
-	rigStopFlag = false
-	rigStopMu.Unlock()
-
+	stopRigWatchers()
 	log.Println("[RIG] stopped")
 }
 
@@ -189,16 +163,17 @@ func startRigWatcher() {
 	log.Printf("[RIG] broadcast mode: %s, selected index: %d", broadcastMode, selectedIndex)
 
 	// 各ポートの監視を開始
-	for i, rp := range rigPorts {
-		if rp.Port == "" {
-			continue
-		}
-		go startSingleRigWatcher(i, rp.Port, rp.Baud)
-	}
+	runRigWatchers(rigPorts, func(ctx context.Context, index int, rp RigPortConfig) {
+		superviseRigPort(ctx, index, rp, runRigPort)
+	})
 }
 
-// startSingleRigWatcher starts a Rig watcher for a single port.
-func startSingleRigWatcher(index int, port string, baud int) {
+// runRigPort opens a single rig port and watches it until it fails or ctx
+// is cancelled. It is run by superviseRigPort; the reader, the pollers and
+// the probe run in one errgroup, and the port is closed when any of them
+// fails or ctx is cancelled.
+func runRigPort(ctx context.Context, index int, rp RigPortConfig, opened func()) error {
+	port, baud := rp.Port, rp.Baud
 	if baud == 0 {
 		baud = 9600
 	}
@@ -215,13 +190,15 @@ func startSingleRigWatcher(index int, port string, baud int) {
 	s, err := serial.Open(port, mode)
 	if err != nil {
 		log.Printf("[RIG-%d] open error: %v", index, err)
-		return
+		return err
 	}
-	defer s.Close()
+	rs := &rigPort{Port: s, index: index, name: port}
+	// 抜去を検出するため 1 秒ごとに読み取りから戻る
+	_ = rs.SetReadTimeout(time.Second)
 
 	// グローバルに保存（設定変更時のAI1送信用）
 	currentRigPortsMu.Lock()
-	currentRigPorts[index] = s
+	currentRigPorts[index] = rs
 	currentRigPortsMu.Unlock()
 	defer func() {
 		currentRigPortsMu.Lock()
@@ -229,150 +206,102 @@ func startSingleRigWatcher(index int, port string, baud int) {
 		currentRigPortsMu.Unlock()
 	}()
 
-	// 後方互換性: index 0 の場合は旧変数にもセット
-	if index == 0 {
-		currentRigPortMu.Lock()
-		currentRigPort = s
-		currentRigPortMu.Unlock()
-		defer func() {
-			currentRigPortMu.Lock()
-			currentRigPort = nil
-			currentRigPortMu.Unlock()
-		}()
-	}
-
-	// ポートごとの状態を初期化
+	// ポートごとの状態を初期化（プロトコルの判別は開くたびにやり直す）
 	rigStatesMu.Lock()
 	rigStates[index] = &RigState{Index: index}
 	rigStatesMu.Unlock()
+	d := rigDriverForProfile(rp.Profile)
+	setRigDriver(index, d, d == nil)
+	pinCIVAddr(index, rp.CIVAddr)
+	if d != nil {
+		logRigDriver(index, d, "profile")
+	}
+	opened()
+
+	g, pctx := errgroup.WithContext(ctx)
+
+	// 停止または失敗でポートを閉じ、読み取りを終わらせる
+	g.Go(func() error {
+		<-pctx.Done()
+		rs.Close()
+		return nil
+	})
+	serveRigPort(pctx, g, index, rs, nil)
+
+	err = g.Wait()
+	if ctx.Err() != nil {
+		log.Printf("[RIG-%d] stopping (restart requested)", index)
+		return errRigStopped
+	}
+	return err
+}
 
-	buf := make([]byte, 256)
-
-	var protoMu sync.Mutex
-	var proto RigProto
-
-	var catBuf strings.Builder
-	var civBuf []byte    // CI-V用バッファ（分割受信対策）
-	var detectBuf []byte // プロトコル検出用バッファ（CI-V分割受信対策）
-
-	// --- 初期CI-V探査 ---
-	go func() {
-		time.Sleep(300 * time.Millisecond)
-		protoMu.Lock()
-		currentProto := proto
-		protoMu.Unlock()
-		if currentProto == ProtoUnknown {
-			log.Printf("[RIG-%d] initial poll: CI-V", index)
-			civInitialPoll(s)
-		}
-		time.Sleep(700 * time.Millisecond)
-		protoMu.Lock()
-		if proto == ProtoUnknown {
-			proto = ProtoCAT
-			log.Printf("[RIG-%d] fallback to CAT", index)
-			startCATPoller(index, s)
-		}
-		protoMu.Unlock()
-	}()
-
-	for {
-		// 停止フラグチェック
-		rigStopMu.Lock()
-		if rigStopFlag {
-			rigStopMu.Unlock()
-			log.Printf("[RIG-%d] stopping (restart requested)", index)
-			return
-		}
-		rigStopMu.Unlock()
-
-		n, err := s.Read(buf)
-		if err != nil {
-			log.Printf("[RIG-%d] read error: %v", index, err)
-			return
-		}
-		if n == 0 {
-			continue
-		}
-
-		data := buf[:n]
-
-		protoMu.Lock()
-		if proto == ProtoUnknown {
-			// プロトコル未確定時はバッファに蓄積して判定
-			detectBuf = append(detectBuf, data...)
-			proto = detectProto(detectBuf)
-			if proto != ProtoUnknown {
-				log.Printf("[RIG-%d] detected protocol: %s", index, proto)
-				if proto == ProtoCAT {
-					startCATPoller(index, s)
-				}
-				// 検出成功後、蓄積データを処理対象にする
-				data = detectBuf
-				detectBuf = nil
-			} else {
-				// まだ検出できない場合、バッファが大きくなりすぎないよう制限
-				if len(detectBuf) > 64 {
-					detectBuf = detectBuf[len(detectBuf)-32:]
-				}
-				protoMu.Unlock()
-				continue // 次の読み取りを待つ
+// serveRigPort runs the probe, the PTT poller and the reader of an open
+// rig port in g, whose context is ctx. Every chunk read from the rig is
+// passed to tap, if not nil, before it is parsed. The reader fails with
+// errRigRemoved when the device is unplugged.
+func serveRigPort(ctx context.Context, g *errgroup.Group, index int, s *rigPort, tap func([]byte)) {
+	g.Go(func() error {
+		pollRigPTT(ctx, index, s)
+		return nil
+	})
+
+	// --- 初期探査（プロファイル指定時はそのドライバーで開始） ---
+	g.Go(func() error {
+		if !sleepCtx(ctx, 300*time.Millisecond) {
+			return nil
+		}
+		if d := rigDriverFor(index); d != nil {
+			startRigPoller(ctx, g, index, s, d)
+			return nil
+		}
+		log.Printf("[RIG-%d] initial poll: CI-V", index)
+		civInitialPoll(index, s)
+		if !sleepCtx(ctx, 700*time.Millisecond) {
+			return nil
+		}
+		if d := rigDrivers[RigProfileYaesu]; claimRigDriver(index, d) {
+			logRigDriver(index, d, "fallback to CAT")
+			startRigPoller(ctx, g, index, s, d)
+		}
+		return nil
+	})
+
+	g.Go(func() error {
+		buf := make([]byte, 256)
+		stream := rigStream{index: index}
+
+		for {
+			n, err := s.Read(buf)
+			if ctx.Err() != nil {
+				return errRigStopped
 			}
-		}
-		currentProto := proto
-		protoMu.Unlock()
-
-		switch currentProto {
-		case ProtoCIV:
-			if !shouldBroadcastFromPort(index) {
-				break
+			if err != nil {
+				log.Printf("[RIG-%d] read error: %v", index, err)
+				return err
 			}
-			// CI-Vバッファに追加
-			civBuf = append(civBuf, data...)
-			// 完全なフレームを処理
-			for {
-				start := bytes.Index(civBuf, []byte{0xFE, 0xFE})
-				if start < 0 {
-					// FE FE がない場合、単独FEがあれば残す
-					lastFE := bytes.LastIndexByte(civBuf, 0xFE)
-					if lastFE >= 0 {
-						civBuf = civBuf[lastFE:] // 最後のFE以降を残す
-					} else {
-						civBuf = nil
-					}
-					break
-				}
-				if start > 0 {
-					civBuf = civBuf[start:] // FE FE の前のゴミを除去
+			if n == 0 {
+				if !serialPortPresent(s.name) {
+					log.Printf("[RIG-%d] %s removed", index, s.name)
+					return errRigRemoved
 				}
-				end := bytes.IndexByte(civBuf[2:], 0xFD) // FE FE の後から FD を探す
-				if end < 0 {
-					break // FD がまだ来ていない
-				}
-				frame := civBuf[:2+end+1]
-				parseCIVFrameForPort(index, frame)
-				civBuf = civBuf[2+end+1:]
+				continue
+			}
+			if tap != nil {
+				tap(buf[:n])
 			}
 
-		case ProtoCAT:
-			catBuf.Write(data)
-
-			for {
-				full := catBuf.String()
-				idx := strings.Index(full, ";")
-				if idx < 0 {
-					break
-				}
-
-				cmd := full[:idx+1]
-				rest := full[idx+1:]
-
-				catBuf.Reset()
-				catBuf.WriteString(rest)
-
-				handleCATCommandForPort(index, strings.TrimSuffix(cmd, ";"), s)
+			d, frames, found := stream.feed(buf[:n])
+			if found {
+				logRigDriver(index, d, "detected")
+				startRigPoller(ctx, g, index, s, d)
+			}
+			for _, f := range frames {
+				d.Parse(index, f)
+				noteRigReply(index, f)
 			}
 		}
-	}
+	})
 }
 
 // detectProto determines the protocol of the given byte slice.
@@ -401,128 +330,59 @@ func detectProto(b []byte) RigProto {
 }
 
 // civInitialPoll sends initial poll commands to the given serial port.
-// The commands are 'FE FE [to] [from] CMD FD', where 'to' and 'from' are
-// the broadcast address (0x00), CMD is the command (0x03 for freq,
+// The commands are 'FE FE [to] [from] CMD FD', where 'to' is the pinned
+// address of the port, or the broadcast address (0x00) with 'from' 0x00
+// when none is pinned, CMD is the command (0x03 for freq,
 // 0x04 for mode), and FD is the frame delimiter (0xFD).
 // The function sends the commands with a 50ms delay in between.
 // This is to ensure that the commands are sent in sequence and
 // the rig has enough time to process them.
-func civInitialPoll(s serial.Port) {
-	// FE FE [to] [from] CMD FD
-	// to/from は 0x00（ブロードキャスト）でOK
+func civInitialPoll(index int, s serial.Port) {
 	// 一部のリグ（IC-7300/IC-9700で確認）は複数のコマンドを投げても最後のコマンドにしか応答しないため、
 	// 短い間隔をあけて順番に投げる
-	s.Write([]byte{0xFE, 0xFE, 0x00, 0x00, 0x03, 0xFD}) // freq
-	time.Sleep(50 * time.Millisecond)
-	s.Write([]byte{0xFE, 0xFE, 0x00, 0x00, 0x04, 0xFD}) // mode
-	time.Sleep(50 * time.Millisecond)
-}
-
-// handleCIV parses the given byte slice as a CI-V frame.
-// It extracts the individual frames from the byte slice, and
-// passes them to parseCIVFrame for further processing.
-// The function will continue to parse the byte slice until no
-// more frames can be found.
-// The CI-V frame is defined as follows: FE FE to cmd ... FD.
-// The cmd is a single byte that represents the command.
-// The frame is terminated by a single FD byte.
-// The function will skip any invalid frames.
-func handleCIV(b []byte) {
-	// CI-V frame: FE FE to from cmd ... FD
-	for {
-		start := bytes.Index(b, []byte{0xFE, 0xFE})
-		if start < 0 {
-			return
-		}
-		end := bytes.IndexByte(b[start:], 0xFD)
-		if end < 0 {
-			return
-		}
-		frame := b[start : start+end+1]
-		parseCIVFrame(frame)
-		b = b[start+end+1:]
+	for _, b := range (icomDriver{}).Start(index) {
+		s.Write(b)
+		time.Sleep(50 * time.Millisecond)
 	}
 }
 
-// handleCIVForPort handles CI-V data for a specific port index
-func handleCIVForPort(index int, b []byte) {
-	if !shouldBroadcastFromPort(index) {
-		return
-	}
-	for {
-		start := bytes.Index(b, []byte{0xFE, 0xFE})
-		if start < 0 {
-			return
-		}
-		end := bytes.IndexByte(b[start:], 0xFD)
-		if end < 0 {
-			return
+// parseCIVFrameForPort parses CI-V frame for a specific port. The state
+// of every radio on the bus is kept apart; only the port's radio is
+// broadcast.
+func parseCIVFrameForPort(index int, f []byte) {
+	// 制御コマンド用のアドレス・応答は配信対象外のポートでも記録する
+	ours := noteCIVFrame(index, f)
+
+	// PTT は TX タイムアウトのため配信対象外のポートでも記録する
+	if len(f) >= 8 && f[4] == 0x1C {
+		if ours {
+			parseCIVPTT(index, f)
 		}
-		frame := b[start : start+end+1]
-		parseCIVFrameForPort(index, frame)
-		b = b[start+end+1:]
+		return
 	}
-}
 
-// parseCIVFrameForPort parses CI-V frame for a specific port
-func parseCIVFrameForPort(index int, f []byte) {
-	//log.Printf("CI-V PORT %d RAW: % X (len=%d)", index, f, len(f))
 	if len(f) < 7 {
 		return
 	}
 
-	cmd := f[4]
-
-	switch cmd {
+	var freq int64
+	var mode RigMode
+	var data bool
+	switch f[4] {
 	case 0x00, 0x03:
-		if freq := parseCIVFreq(f); freq > 0 {
-			updateRigStateForPort(index, freq, "", false, ProtoCIV)
-		}
+		freq = parseCIVFreq(f)
 	case 0x01, 0x04:
-		if mode, data := parseCIVMode(f); mode != "" {
-			updateRigStateForPort(index, 0, string(mode), data, ProtoCIV)
-		}
+		mode, data = parseCIVMode(f)
 	}
-}
-
-// parseCIVFrame parses the given byte slice as a CI-V frame.
-// It extracts the individual frames from the byte slice, and
-// passes them to parseCIVFreq or parseCIVMode for further processing.
-// The function will continue to parse the byte slice until no
-// more frames can be found.
-// The CI-V frame is defined as follows: FE FE to cmd ... FD.
-// The cmd is a single byte that represents the command.
-// The frame is terminated by a single FD byte.
-// The function will skip any invalid frames.
-func parseCIVFrame(f []byte) {
-	if len(f) < 7 {
+	if freq <= 0 && mode == "" {
 		return
 	}
-
-	cmd := f[4]
-
-	switch cmd {
-	case 0x00, 0x03:
-		if freq := parseCIVFreq(f); freq > 0 {
-			//broadcastRig("ICOM", freq, "", false)
-			rigMu.Lock()
-			rigState.Freq = freq
-			rigState.Proto = ProtoCIV
-			rigMu.Unlock()
-			broadcastRigState()
-		}
-	case 0x01, 0x04:
-		if mode, data := parseCIVMode(f); mode != "" {
-			//broadcastRig("ICOM", 0, string(mode), data)
-			rigMu.Lock()
-			rigState.Mode = mode
-			rigState.Data = data
-			rigState.Proto = ProtoCIV
-			rigMu.Unlock()
-			broadcastRigState()
-
-		}
+	st := noteCIVRigState(index, f[3], freq, mode, data)
+	if !ours {
+		return
 	}
+	// 無線機が切り替わっても周波数とモードが混ざらないよう、その無線機の状態をまとめて反映する
+	updateRigStateForPort(index, st.Freq, string(st.Mode), st.Data, ProtoCIV)
 }
 
 // parseCIVFreq parses the given byte slice as a CI-V frequency frame.
@@ -672,27 +532,6 @@ func isDStarDR(freq int64) bool {
 	return false
 }
 
-// updateCATState updates the current Rig state with the given frequency,
-// mode, and data. If the frequency is greater than 0, it updates
-// the frequency of the Rig state. If the mode is not empty, it
-// updates the mode of the Rig state. After updating the Rig state,
-// it calls broadcastRigState to broadcast the updated Rig state
-// to all connected WebSocket clients.
-func updateCATState(freq int64, mode string, data bool) {
-	rigMu.Lock()
-	if freq > 0 {
-		rigState.Freq = freq
-	}
-	if mode != "" {
-		rigState.Mode = RigMode(mode)
-		rigState.Data = data
-	}
-	rigState.Proto = ProtoCAT
-	rigMu.Unlock()
-
-	broadcastRigState()
-}
-
 // shouldBroadcastFromPort checks if data from the given port should be broadcast
 // based on the current broadcast mode and selected port index.
 func shouldBroadcastFromPort(index int) bool {
@@ -701,8 +540,6 @@ func shouldBroadcastFromPort(index int) bool {
 	selectedIndex := config.SelectedRigIndex
 	configLock.RUnlock()
 
-	//log.Printf("[RIG] shouldBroadcast: index=%d, mode=%q, selectedIndex=%d", index, mode, selectedIndex)
-
 	if mode == "single" {
 		return index == selectedIndex
 	}
@@ -710,7 +547,8 @@ func shouldBroadcastFromPort(index int) bool {
 	return true
 }
 
-// updateRigStateForPort updates the rig state for a specific port
+// updateRigStateForPort updates the rig state for a specific port. The
+// change is broadcast only from the ports shouldBroadcastFromPort allows.
 func updateRigStateForPort(index int, freq int64, mode string, data bool, proto RigProto) {
 	// Update port-specific state
 	rigStatesMu.Lock()
@@ -748,6 +586,10 @@ func updateRigStateForPort(index int, freq int64, mode string, data bool, proto
 	if !freqChanged && !modeChanged {
 		return
 	}
+	// rigctld などが読むポートの状態は常に更新し、配信だけをモードで絞る
+	if !shouldBroadcastFromPort(index) {
+		return
+	}
 
 	// アクティブポートのチェック
 	// 別のポートが最近500ms以内にアクティブだった場合、周波数のみの変化は無視
@@ -780,6 +622,7 @@ func updateRigStateForPort(index int, freq int64, mode string, data bool, proto
 			rigState.Mode = portState.Mode
 			rigState.Data = portState.Data
 		}
+		rigState.PTT = portState.PTT
 		rigState.Proto = proto
 		rigState.Index = index
 	}
@@ -788,67 +631,36 @@ func updateRigStateForPort(index int, freq int64, mode string, data bool, proto
 	broadcastRigState()
 }
 
-// handleCATCommandForPort handles a CAT command for a specific port
-func handleCATCommandForPort(index int, cmd string, s serial.Port) {
-	if len(cmd) < 2 {
-		return
+// startRigPoller sends the start commands of the driver (e.g. AI1 for
+// Auto Information). If the rig doesn't report its frequency within 2
+// seconds, it falls back to polling mode for rigs that don't support it
+// (e.g., TS-2000). The check and the polling loop run in g until ctx is
+// cancelled.
+func startRigPoller(ctx context.Context, g *errgroup.Group, index int, s serial.Port, d RigDriver) {
+	start := d.Start(index)
+	for i, b := range start {
+		if i > 0 {
+			time.Sleep(50 * time.Millisecond)
+		}
+		_, _ = s.Write(b)
 	}
-
-	if !shouldBroadcastFromPort(index) {
+	if d.Poll(index) == nil {
 		return
 	}
-
-	switch {
-	case strings.HasPrefix(cmd, "IF"):
-		parseIFForPort(index, cmd)
-	case strings.HasPrefix(cmd, "FA"):
-		if freq := parseCATFreq(cmd); freq > 0 {
-			updateRigStateForPort(index, freq, "", false, ProtoCAT)
-		}
-	case strings.HasPrefix(cmd, "MD"):
-		if mode, data := parseCATMode(cmd); mode != "" {
-			updateRigStateForPort(index, 0, mode, data, ProtoCAT)
-		}
-	}
-}
-
-// parseIFForPort parses IF command for a specific port
-func parseIFForPort(index int, cmd string) {
-	if len(cmd) < 30 {
+	if len(start) == 0 {
+		// Auto Information のないリグは最初からポーリングする
+		g.Go(func() error {
+			pollRigState(ctx, index, s)
+			return nil
+		})
 		return
 	}
 
-	// 周波数
-	freqStr := cmd[5:16] // P2
-	var hz int64
-	for _, c := range freqStr {
-		if c < '0' || c > '9' {
-			return
+	// Wait and check if Auto Information is working
+	g.Go(func() error {
+		if !sleepCtx(ctx, 2*time.Second) {
+			return nil
 		}
-		hz = hz*10 + int64(c-'0')
-	}
-
-	// モード
-	modeCode := cmd[26:27] // P6
-	mode, data := parseCATMode("MD0" + modeCode)
-
-	updateRigStateForPort(index, hz, mode, data, ProtoCAT)
-}
-
-var lastFreqPoll time.Time
-var lastMDPoll time.Time
-
-// startCATPoller enables CAT Auto Information mode.
-// If the rig doesn't respond within timeout, it falls back to polling mode
-// for older rigs that don't support AI1 (e.g., FT-817, FT-857, TS-2000).
-func startCATPoller(index int, s serial.Port) {
-	// Auto Information ON (YAESU/KENWOOD)
-	_, _ = s.Write([]byte("AI1;FA;MD0;"))
-	log.Printf("[RIG-%d] CAT Auto Information enabled", index)
-
-	// Wait and check if AI1 is working
-	go func() {
-		time.Sleep(2 * time.Second)
 
 		// Check if we received any data
 		rigStatesMu.RLock()
@@ -857,97 +669,46 @@ func startCATPoller(index int, s serial.Port) {
 		rigStatesMu.RUnlock()
 
 		if !hasData {
-			// AI1 not working, start polling mode
-			log.Printf("[RIG-%d] AI1 not responding, starting polling mode (legacy rig support)", index)
-			startCATPollingLoop(index, s)
+			log.Printf("[RIG-%d] no Auto Information, starting polling mode", index)
+			pollRigState(ctx, index, s)
 		}
-	}()
+		return nil
+	})
 }
 
-// startCATPollingLoop starts a polling loop for older rigs that don't support AI1.
-// This enables support for legacy rigs like FT-817, FT-857, FT-897, TS-2000, etc.
-func startCATPollingLoop(index int, s serial.Port) {
+// pollRigState sends the poll query of the port's driver every 2 seconds
+// until ctx is cancelled. The driver is looked up each time, since "auto"
+// may refine it.
+func pollRigState(ctx context.Context, index int, s serial.Port) {
 	ticker := time.NewTicker(2 * time.Second)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		// Check if port is still valid
-		currentRigPortsMu.Lock()
-		_, exists := currentRigPorts[index]
-		currentRigPortsMu.Unlock()
-		if !exists {
+	for {
+		select {
+		case <-ctx.Done():
 			log.Printf("[RIG-%d] polling stopped (port closed)", index)
 			return
+		case <-ticker.C:
 		}
 
-		_, _ = s.Write([]byte("FA;MD0;"))
-	}
-}
-
-// handleCATCommand handles a CAT command received from the serial port.
-// It expects the command to be one of the following:
-// - "IF" followed by a 9-digit frequency in Hz
-// - "FA" followed by a 9-digit frequency in Hz
-// - "MD" followed by a single digit mode code
-// The function will return without doing anything if the command is invalid.
-// The function will parse the command and call updateCATState with the parsed frequency and mode.
-// If the command is "IF", the function will call updateCATState with the parsed frequency and an empty string for the mode.
-// If the command is "FA", the function will call updateCATState with the parsed frequency and an empty string for the mode.
-// If the command is "MD", the function will call updateCATState with 0 for the frequency and the parsed mode and data.
-// The function will log a message at the INFO level with the command received.
-// The function takes two parameters: the command as a string and the serial port as a serial.Port.
-func handleCATCommand(cmd string, s serial.Port) {
-	if len(cmd) < 2 {
-		return
-	}
-	//log.Println("[RIG] CAT command:", cmd)
-
-	switch {
-	case strings.HasPrefix(cmd, "IF"):
-		parseIF(cmd)
-	case strings.HasPrefix(cmd, "FA"):
-		if freq := parseCATFreq(cmd); freq > 0 {
-			updateCATState(freq, "", false)
-		}
-
-	case strings.HasPrefix(cmd, "MD"):
-		if mode, data := parseCATMode(cmd); mode != "" {
-			updateCATState(0, mode, data)
+		d := rigDriverFor(index)
+		if d == nil {
+			continue
 		}
-	}
-}
-
-// parseIF parses the given string as a CAT IF frame.
-// It expects the frame to be in the format of "IF P1(x) P2(x) P3(x) P4(x) P5(x) P6(x) P7(x) P8(x) P9(x) P10(x)".
-// The function will return 0 if the frame is invalid.
-// The function will return the parsed frequency in Hz otherwise.
-func parseIF(cmd string) {
-	// IF P1(3) P2(11) P3(5) P4(1) P5(1) P6(1) P7(1) P8(1) P9(2) P10(1)
-	// IF004430698000+000000E00000
-	if len(cmd) < 30 {
-		return
-	}
-
-	// 周波数
-	freqStr := cmd[5:16] // P2
-	var hz int64
-	for _, c := range freqStr {
-		if c < '0' || c > '9' {
-			return
+		if q := d.Poll(index); q != nil {
+			// 制御コマンドの応答と混ざらないよう、応答を待つ間はコマンドを止める
+			l := rigLinkFor(index)
+			l.mu.Lock()
+			_, _ = s.Write(q)
+			time.Sleep(100 * time.Millisecond)
+			l.mu.Unlock()
 		}
-		hz = hz*10 + int64(c-'0')
 	}
-
-	// モード
-	modeCode := cmd[26:27] // P6
-	mode, data := parseCATMode("MD0" + modeCode)
-
-	updateCATState(hz, mode, data)
 }
 
 // parseCATFreq parses the given string as a CAT frequency frame.
 // Supports precision correction for different rig models based on digit count:
-// - 8 digits (FT-817, FT-857, FT-897): 10Hz precision, multiplied by 10 for 1Hz
+// - 8 digits (FT-450, FT-950, FT-2000): 10Hz precision, multiplied by 10 for 1Hz
 // - 9 digits (FT-991A, FT-710): 1Hz precision
 // - 11 digits (TS-590S, TS-2000, KENWOOD): 1Hz precision
 func parseCATFreq(s string) int64 {
@@ -969,7 +730,7 @@ func parseCATFreq(s string) int64 {
 		digitCount++
 	}
 
-	// Apply precision correction for 8-digit rigs (FT-817, FT-857, FT-897)
+	// Apply precision correction for 8-digit rigs (FT-450, FT-950, FT-2000)
 	// These rigs use 10Hz precision, so multiply by 10 to get 1Hz precision
 	if digitCount == 8 {
 		hz *= 10
@@ -978,101 +739,22 @@ func parseCATFreq(s string) int64 {
 	return hz
 }
 
-// parseCATMode parses the given string as a CAT mode frame.
-// It expects the frame to be in the format of "MD" followed by a single digit mode code.
-// The function will return an empty string if the frame is invalid.
-// The function will return the parsed mode as a string otherwise.
-// The supported modes are as follows:
-// - 0: FM (Frequency Modulation)
-// - 1: LSB (Lower Side Band)
-// - 2: USB (Upper Side Band)
-// - 3: CW (Continuous Wave)
-// - 4: AM (Amplitude Modulation)
-// - 5: RTTY (Radio Teletype)
-// - 8: USB (Upper Side Band) with data
-// - 18: FM (Frequency Modulation) with data
-// The function will return the parsed mode as a string and a boolean indicating whether the mode has data.
-func parseCATMode(s string) (string, bool) {
-	if len(s) < 4 {
-		return "", false
-	}
-
-	// MD02 -> "02"
-	code := strings.TrimLeft(s[2:], "0")
-	data := false
-
-	switch code {
-	case "1":
-		return "LSB", data
-	case "2":
-		return "USB", data
-	case "3":
-		return "CW-U", data
-	case "4":
-		return "FM", data
-	case "5":
-		return "AM", data
-	case "6":
-		return "RTTY-LSB", data
-	case "7":
-		return "CW-R", data
-	case "8":
-		data = true
-		return "LSB", data
-	case "9":
-		data = true
-		return "RTTY-USB", data
-	case "A":
-		data = true
-		return "FM", data
-	case "B":
-		return "FM-N", data
-	case "C":
-		data = true
-		return "USB", data
-	case "D":
-		return "AM-N", data
-	case "E":
-		return "C4FM", data
-	default:
-		return "", false
-	}
-}
-
-// BroadcastRIG to all connected WebSocket clients.
-func broadcastRig(rig string, freq int64, mode string, data bool) {
-	ev := map[string]interface{}{
-		"type": "rig",
-		"rig":  rig,
-	}
-	if freq > 0 {
-		ev["freq"] = freq
-	}
-	if mode != "" {
-		ev["mode"] = mode
-		ev["data"] = data
-	}
-
-	b, _ := json.Marshal(ev)
-	broadcast(string(b))
-}
-
-// SendAI1 sends AI1; command to enable Auto Information on YAESU rigs.
-// Called from webui when settings are changed.
+// SendAI1 sends the start commands of the CAT driver on port 0 (AI1;) to
+// enable Auto Information again. Called from webui when settings are changed.
 func SendAI1() {
-	rigMu.Lock()
-	proto := rigState.Proto
-	rigMu.Unlock()
-
-	if proto != ProtoCAT {
+	d := rigDriverFor(0)
+	if d == nil || d.Proto() != ProtoCAT {
 		return
 	}
 
-	currentRigPortMu.Lock()
-	defer currentRigPortMu.Unlock()
+	currentRigPortsMu.Lock()
+	s := currentRigPorts[0]
+	currentRigPortsMu.Unlock()
 
-	if currentRigPort != nil {
-		_, _ = currentRigPort.Write([]byte("AI1;FA;MD0;"))
+	if s != nil {
+		for _, b := range d.Start(0) {
+			_, _ = s.Write(b)
+		}
 		log.Println("[RIG] AI1; sent (settings changed)")
 	}
 }
@@ -1089,6 +771,7 @@ var lastBroadcast struct {
 	Freq  int64
 	Mode  RigMode
 	Data  bool
+	PTT   bool
 	Proto RigProto
 }
 
@@ -1100,6 +783,7 @@ func broadcastRigState() {
 	if rigState.Freq == lastBroadcast.Freq &&
 		rigState.Mode == lastBroadcast.Mode &&
 		rigState.Data == lastBroadcast.Data &&
+		rigState.PTT == lastBroadcast.PTT &&
 		rigState.Proto == lastBroadcast.Proto {
 		return
 	}
@@ -1108,16 +792,20 @@ func broadcastRigState() {
 	lastBroadcast.Freq = rigState.Freq
 	lastBroadcast.Mode = rigState.Mode
 	lastBroadcast.Data = rigState.Data
+	lastBroadcast.PTT = rigState.PTT
 	lastBroadcast.Proto = rigState.Proto
 
-	ev := map[string]interface{}{
-		"type": "rig",
-		"rig":  rigState.Proto,
-		"port": rigState.Index,
+	port := rigState.Index
+	ptt := rigState.PTT
+	ev := &RigEvent{
+		Envelope: newEnvelope("rig", ""),
+		Rig:      rigState.Proto,
+		Port:     &port,
+		PTT:      &ptt,
 	}
 
 	if rigState.Freq > 0 {
-		ev["freq"] = rigState.Freq
+		ev.Freq = rigState.Freq
 	}
 
 	if rigState.Mode != "" {
@@ -1126,17 +814,17 @@ func broadcastRigState() {
 		// D-STAR 判定
 		if mode == ModeDV && rigState.Freq > 0 {
 			if isDStarDR(rigState.Freq) {
-				ev["mode"] = "D-STAR (DR)"
+				ev.Mode = "D-STAR (DR)"
 			} else {
-				ev["mode"] = "D-STAR (DV)"
+				ev.Mode = "D-STAR (DV)"
 			}
 		} else {
-			ev["mode"] = mode
+			ev.Mode = string(mode)
 		}
 
-		ev["data"] = rigState.Data
+		data := rigState.Data
+		ev.Data = &data
 	}
 
-	b, _ := json.Marshal(ev)
-	broadcast(string(b))
+	broadcast(ev)
 }
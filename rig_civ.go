@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// civEchoWindow is how long a frame written to a CI-V port may take to
+// come back. On a shared bus (CT-17, daisy-chained rigs) every frame sent
+// is also received.
+const civEchoWindow = 500 * time.Millisecond
+
+// civSentFrame is a frame written to a CI-V port.
+type civSentFrame struct {
+	f  []byte
+	at time.Time
+}
+
+// civRigName returns the model of a CI-V address, or "unknown".
+func civRigName(addr byte) string {
+	if info, ok := civRigDatabase[addr]; ok {
+		return info.Name
+	}
+	return "unknown"
+}
+
+// civAddrChoice is an address of civRigDatabase for the settings page.
+type civAddrChoice struct {
+	Value string // "94"
+	Label string // "IC-7300"
+}
+
+// civAddrChoices lists civRigDatabase sorted by model name.
+func civAddrChoices() []civAddrChoice {
+	var c []civAddrChoice
+	for addr, info := range civRigDatabase {
+		c = append(c, civAddrChoice{Value: fmt.Sprintf("%02X", addr), Label: info.Name})
+	}
+	sort.Slice(c, func(i, j int) bool {
+		if c[i].Label != c[j].Label {
+			return c[i].Label < c[j].Label
+		}
+		return c[i].Value < c[j].Value
+	})
+	return c
+}
+
+// pinCIVAddr sets the CI-V address expected on a port when it is opened.
+// Polls and commands go to that radio and frames of other radios on the
+// bus are not broadcast. 0 learns the address from the frames received.
+func pinCIVAddr(index int, addr byte) {
+	l := rigLinkFor(index)
+	rigLinksMu.Lock()
+	l.civPin = addr
+	l.civAddr = addr
+	rigLinksMu.Unlock()
+	if addr != 0 {
+		log.Printf("[RIG-%d] CI-V address pinned: 0x%02X (%s)", index, addr, civRigName(addr))
+	}
+}
+
+// civPinFor returns the pinned CI-V address of a port, or 0.
+func civPinFor(index int) byte {
+	l := rigLinkFor(index)
+	rigLinksMu.Lock()
+	defer rigLinksMu.Unlock()
+	return l.civPin
+}
+
+// noteCIVSent records the frames written to a port, so that their echoes
+// are not taken for frames of a radio.
+func noteCIVSent(index int, b []byte) {
+	frames, _ := icomDriver{}.Frames(index, b)
+	if len(frames) == 0 {
+		return
+	}
+	l := rigLinkFor(index)
+	rigLinksMu.Lock()
+	defer rigLinksMu.Unlock()
+	now := time.Now()
+	l.pruneCIVSentLocked(now)
+	for _, f := range frames {
+		l.civSent = append(l.civSent, civSentFrame{f: bytes.Clone(f), at: now})
+	}
+	if n := len(l.civSent); n > 32 {
+		l.civSent = l.civSent[n-32:]
+	}
+}
+
+// civEchoLocked reports whether f is the echo of a frame written to the
+// port, and forgets that frame. rigLinksMu must be held.
+func (l *rigLink) civEchoLocked(f []byte) bool {
+	l.pruneCIVSentLocked(time.Now())
+	for i, s := range l.civSent {
+		if bytes.Equal(s.f, f) {
+			l.civSent = append(l.civSent[:i], l.civSent[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (l *rigLink) pruneCIVSentLocked(now time.Time) {
+	for len(l.civSent) > 0 && now.Sub(l.civSent[0].at) > civEchoWindow {
+		l.civSent = l.civSent[1:]
+	}
+}
+
+// noteCIVRigState updates the state of the radio at addr on a port and
+// returns it.
+func noteCIVRigState(index int, addr byte, freq int64, mode RigMode, data bool) RigState {
+	l := rigLinkFor(index)
+	rigLinksMu.Lock()
+	defer rigLinksMu.Unlock()
+	st := l.civRigs[addr]
+	if st == nil {
+		return RigState{Freq: freq, Mode: mode, Data: data, Proto: ProtoCIV, Index: index}
+	}
+	if freq > 0 {
+		st.Freq = freq
+	}
+	if mode != "" {
+		st.Mode = mode
+		st.Data = data
+	}
+	return *st
+}
+
+// civRigsSummary describes the radios seen on the CI-V bus of a port for
+// the settings page, e.g. "94 IC-7300 / A4 IC-705", or "" if none.
+func civRigsSummary(index int) string {
+	l := rigLinkFor(index)
+	rigLinksMu.Lock()
+	addrs := make([]byte, 0, len(l.civRigs))
+	for addr := range l.civRigs {
+		addrs = append(addrs, addr)
+	}
+	active := l.civAddr
+	rigLinksMu.Unlock()
+
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	var parts []string
+	for _, addr := range addrs {
+		s := fmt.Sprintf("%02X %s", addr, civRigName(addr))
+		if addr == active && len(addrs) > 1 {
+			s += "（使用中）"
+		}
+		parts = append(parts, s)
+	}
+	return strings.Join(parts, " / ")
+}
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// civControllerAddr is the CI-V address the bridge sends commands from.
+const civControllerAddr = 0xE0
+
+// rigAckTimeout is how long a command waits for the rig to answer. CI-V
+// rigs answer every command with FB (OK) or FA (NG); CAT rigs answer only
+// errors ("?;"), so for CAT the timeout means success.
+const rigAckTimeout = 500 * time.Millisecond
+
+// Errors of the rig control commands that clients may want to tell apart.
+var (
+	errRigNotOpen  = errors.New("rig port is not open")
+	errRigNotReady = errors.New("rig is not detected yet")
+	errRigRejected = errors.New("command rejected by the rig")
+	errRigTimeout  = errors.New("no response from the rig")
+)
+
+// rigLink holds what the control commands learn about a port from the
+// frames the rig sends.
+type rigLink struct {
+	mu        sync.Mutex         // コマンドの送信を直列化
+	civAddr   byte               // リグの CI-V アドレス（0 = 未検出）
+	civPin    byte               // 設定で指定した CI-V アドレス（0 = 自動）
+	civRigs   map[byte]*RigState // CI-V バス上の無線機ごとの状態
+	civSent   []civSentFrame     // エコーとして戻ってくる送信済みフレーム
+	catDigits int                // FA 応答の桁数（0 = 未検出）
+	catData   bool               // Kenwood の DA（DATA ON / OFF）
+	driver    RigDriver          // 使用中のドライバー（nil = 検出前）
+	auto      bool               // ドライバーを自動判別する
+	binOut    []byte             // 送信途中のバイナリ CAT コマンド
+	binOutAt  time.Time          // 最後にバイナリ CAT を送った時刻
+	binWait   []ft817Reply       // 応答待ちのバイナリ CAT コマンド
+	ack       chan bool          // FB/FA または "?;" の通知
+	vfo       string             // 最後に設定した VFO（"" = A）
+	split     bool               // 最後に設定したスプリット
+	pending   bool               // コマンドの応答待ち
+	polled    time.Time          // 最後に PTT を読んだ時刻
+	pttQuery  []byte             // PTT の読み取りコマンド
+	pttWait   bool               // PTT の読み取りの最初の応答待ち
+	pttNG     int                // PTT の読み取りが続けて拒否された回数
+	noPTTPoll bool               // PTT の読み取りに対応しないリグ
+	sentAt    time.Time          // PTT の読み取り以外を最後に送った時刻
+	ptyAt     time.Time          // PTY のアプリが最後に書き込んだ時刻
+	txTimer   *time.Timer
+}
+
+var (
+	rigLinks   = make(map[int]*rigLink)
+	rigLinksMu sync.Mutex
+)
+
+func rigLinkFor(index int) *rigLink {
+	rigLinksMu.Lock()
+	defer rigLinksMu.Unlock()
+	l := rigLinks[index]
+	if l == nil {
+		l = &rigLink{ack: make(chan bool, 1)}
+		rigLinks[index] = l
+	}
+	return l
+}
+
+// noteCIVFrame records a CI-V frame received on a port: the radios on the
+// bus and the answers to commands. It reports whether the frame is from
+// the port's radio; echoes of frames written to the port and frames of
+// other radios on the bus are not. Without a pinned address the port
+// follows the radio that was operated last (transceive, sent to 00).
+func noteCIVFrame(index int, f []byte) bool {
+	if len(f) < 6 {
+		return false
+	}
+	to, from, cmd := f[2], f[3], f[4]
+	if from == civControllerAddr || from == 0x00 {
+		return false // 自分（または他のコントローラー）が送ったフレームのエコー
+	}
+
+	l := rigLinkFor(index)
+	rigLinksMu.Lock()
+	if l.civEchoLocked(f) {
+		rigLinksMu.Unlock()
+		return false
+	}
+	seen := l.civRigs[from] != nil
+	if !seen {
+		if l.civRigs == nil {
+			l.civRigs = make(map[byte]*RigState)
+		}
+		l.civRigs[from] = &RigState{Proto: ProtoCIV, Index: index}
+	}
+	changed := l.civPin == 0 && l.civAddr != from && (l.civAddr == 0 || to == 0x00)
+	if changed {
+		l.civAddr = from
+	}
+	ours := from == l.civAddr
+	rigLinksMu.Unlock()
+	if !seen && !changed {
+		log.Printf("[RIG-%d] CI-V rig on bus: 0x%02X (%s)", index, from, civRigName(from))
+	}
+	if changed {
+		log.Printf("[RIG-%d] CI-V address: 0x%02X (%s)", index, from, civRigName(from))
+	}
+
+	if ours && to == civControllerAddr && len(f) == 6 && (cmd == 0xFB || cmd == 0xFA) {
+		noteRigAck(index, cmd == 0xFB)
+	}
+	return ours
+}
+
+// noteCATFreq records the number of digits of an FA answer, which tells
+// the frequency format the rig expects.
+func noteCATFreq(index int, cmd string) {
+	digits := 0
+	for _, c := range cmd[2:] {
+		if c < '0' || c > '9' {
+			break
+		}
+		digits++
+	}
+	if digits < 8 {
+		return
+	}
+	l := rigLinkFor(index)
+	rigLinksMu.Lock()
+	l.catDigits = digits
+	// 自動判別では 11 桁の FA を Kenwood のコマンド体系とみなす
+	upgrade := l.auto && digits == 11 && l.driver != nil && l.driver.Name() == RigProfileYaesu
+	if upgrade {
+		l.driver = rigDrivers[RigProfileKenwood]
+	}
+	rigLinksMu.Unlock()
+	if upgrade {
+		logRigDriver(index, rigDrivers[RigProfileKenwood], "11-digit FA")
+	}
+}
+
+// noteRigAck passes an answer of the rig to the command waiting for it.
+// An error answer while no command is waiting counts against the PTT poll
+// when it is the first answer to the query; polling stops after
+// rigPTTRejectLimit of them in a row.
+func noteRigAck(index int, ok bool) {
+	l := rigLinkFor(index)
+	rigLinksMu.Lock()
+	pending := l.pending
+	if !pending && !ok && l.pttRejectedLocked() {
+		l.pttNG++
+		if l.pttNG >= rigPTTRejectLimit && !l.noPTTPoll {
+			l.noPTTPoll = true
+			log.Printf("[RIG-%d] PTT read not supported, polling stopped", index)
+		}
+	}
+	rigLinksMu.Unlock()
+	if !pending {
+		return
+	}
+	select {
+	case l.ack <- ok:
+	default:
+	}
+}
+
+// pttRejectedLocked reports whether an error answer arriving now is the
+// first answer to the PTT query, with nothing else sent around it that
+// the rig could be rejecting. rigLinksMu must be held.
+func (l *rigLink) pttRejectedLocked() bool {
+	if !l.pttWait || time.Since(l.polled) >= rigAckTimeout {
+		return false
+	}
+	l.pttWait = false
+	// 直前や応答待ちの間に他のコマンド（ポーリング・PTY のアプリ）を送っていれば、どちらへの応答か分からない
+	return l.sentAt.Before(l.polled.Add(-rigAckTimeout))
+}
+
+// noteRigSent records a write to a port other than the PTT query.
+func noteRigSent(index int, b []byte) {
+	l := rigLinkFor(index)
+	rigLinksMu.Lock()
+	if !bytes.Equal(b, l.pttQuery) {
+		l.sentAt = time.Now()
+	}
+	rigLinksMu.Unlock()
+}
+
+// noteRigReply is called for every frame read from a port after it is
+// parsed. A first answer to the PTT query that was not an error resets
+// the count of rejections.
+func noteRigReply(index int, f []byte) {
+	l := rigLinkFor(index)
+	rigLinksMu.Lock()
+	defer rigLinksMu.Unlock()
+	if !l.pttWait || bytes.Equal(f, l.pttQuery) {
+		return // CI-V のエコー
+	}
+	l.pttWait = false
+	l.pttNG = 0
+}
+
+// RigControlCommand is a rig control request from a WebSocket client.
+type RigControlCommand struct {
+	Command         // type: "setFreq" / "setMode" / "setVFO" / "setSplit" / "setPTT"
+	Port    int     `json:"port"`
+	Freq    int64   `json:"freq,omitempty"`  // setFreq（Hz）
+	Mode    RigMode `json:"mode,omitempty"`  // setMode
+	Data    bool    `json:"data,omitempty"`  // setMode（DATA ON/OFF）
+	VFO     string  `json:"vfo,omitempty"`   // setVFO: "A" / "B" / "MAIN" / "SUB"
+	Split   bool    `json:"split,omitempty"` // setSplit
+	PTT     bool    `json:"ptt,omitempty"`   // setPTT
+}
+
+// handleRigControl sends a set* command to the rig on the requested port
+// and returns the rigAck reply.
+func handleRigControl(cmd RigControlCommand) Message {
+	ack := &RigAckReply{
+		Envelope: newEnvelope("rigAck", ""),
+		Command:  cmd.Type,
+		Port:     cmd.Port,
+	}
+	if err := controlRig(cmd); err != nil {
+		log.Printf("[RIG-%d] %s failed: %v", cmd.Port, cmd.Type, err)
+		ack.Error = err.Error()
+		return ack
+	}
+	log.Printf("[RIG-%d] %s ok", cmd.Port, cmd.Type)
+	ack.OK = true
+	return ack
+}
+
+func controlRig(cmd RigControlCommand) error {
+	currentRigPortsMu.Lock()
+	s := currentRigPorts[cmd.Port]
+	currentRigPortsMu.Unlock()
+
+	rigStatesMu.RLock()
+	var state RigState
+	if st := rigStates[cmd.Port]; st != nil {
+		state = *st
+	}
+	rigStatesMu.RUnlock()
+
+	if s == nil {
+		return errRigNotOpen
+	}
+
+	d := rigDriverFor(cmd.Port)
+	if d == nil {
+		return errRigNotReady
+	}
+	frames, refresh, err := d.Command(cmd.Port, cmd, state)
+	if err != nil {
+		return err
+	}
+
+	l := rigLinkFor(cmd.Port)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, f := range frames {
+		if err := l.send(s, f, d.Proto()); err != nil {
+			return err
+		}
+	}
+	if refresh != nil {
+		// トランシーブ / AI1 が無効なリグのために状態を読み直す
+		_, _ = s.Write(refresh)
+	}
+
+	rigLinksMu.Lock()
+	switch cmd.Type {
+	case "setVFO":
+		l.vfo = strings.ToUpper(cmd.VFO)
+	case "setSplit":
+		l.split = cmd.Split
+	}
+	rigLinksMu.Unlock()
+
+	if cmd.Type == "setPTT" {
+		if cmd.PTT {
+			startTXTimeout(cmd.Port)
+		} else {
+			stopTXTimeout(cmd.Port)
+		}
+	}
+	return nil
+}
+
+// send writes one command and waits for the rig to answer it.
+func (l *rigLink) send(s serial.Port, b []byte, proto RigProto) error {
+	// 前のコマンドへの遅れた応答を捨てる
+	select {
+	case <-l.ack:
+	default:
+	}
+
+	rigLinksMu.Lock()
+	l.pending = true
+	rigLinksMu.Unlock()
+	defer func() {
+		rigLinksMu.Lock()
+		l.pending = false
+		rigLinksMu.Unlock()
+	}()
+
+	if _, err := s.Write(b); err != nil {
+		return err
+	}
+
+	select {
+	case ok := <-l.ack:
+		if !ok {
+			return errRigRejected
+		}
+		return nil
+	case <-time.After(rigAckTimeout):
+		if proto == ProtoCIV {
+			return errRigTimeout
+		}
+		return nil // CAT はエラー時のみ応答する
+	}
+}
+
+// civFrame builds a CI-V frame: FE FE to from cmd ... FD.
+func civFrame(to byte, body ...byte) []byte {
+	f := []byte{0xFE, 0xFE, to, civControllerAddr}
+	f = append(f, body...)
+	return append(f, 0xFD)
+}
+
+// civFreqBCD encodes freq as n bytes of little-endian BCD, the format
+// parseCIVFreq reads.
+func civFreqBCD(freq int64, n int) ([]byte, error) {
+	b := make([]byte, n)
+	for i := 0; i < n; i++ {
+		lo := freq % 10
+		freq /= 10
+		hi := freq % 10
+		freq /= 10
+		b[i] = byte(hi<<4 | lo)
+	}
+	if freq != 0 {
+		return nil, errors.New("frequency out of range for this rig")
+	}
+	return b, nil
+}
+
+// civModes maps modes to CI-V mode bytes (the reverse of parseCIVMode).
+var civModes = map[RigMode]byte{
+	ModeLSB:   0x00,
+	ModeUSB:   0x01,
+	ModeAM:    0x02,
+	ModeCW:    0x03,
+	ModeRTTY:  0x04,
+	ModeFM:    0x05,
+	ModeWFM:   0x06,
+	ModeCWR:   0x07,
+	ModeRTTYR: 0x08,
+	ModeDV:    0x17,
+}
+
+// civCommand returns the CI-V frames of a command and the frame that reads
+// back the changed state.
+func civCommand(addr byte, cmd RigControlCommand, state RigState) (frames [][]byte, refresh []byte, err error) {
+	switch cmd.Type {
+	case "setFreq":
+		if cmd.Freq <= 0 {
+			return nil, nil, errors.New("freq is required")
+		}
+		n := 5
+		if info, ok := civRigDatabase[addr]; ok {
+			n = info.FreqBytes
+		}
+		bcd, err := civFreqBCD(cmd.Freq, n)
+		if err != nil {
+			return nil, nil, err
+		}
+		return [][]byte{civFrame(addr, append([]byte{0x05}, bcd...)...)}, civFrame(addr, 0x03), nil
+
+	case "setMode":
+		m, ok := civModes[normalizeRigMode(cmd.Mode)]
+		if !ok {
+			return nil, nil, fmt.Errorf("unsupported mode: %q", cmd.Mode)
+		}
+		frames = [][]byte{civFrame(addr, 0x06, m)}
+		// DATA 切り替え（1A 06）は対応しない旧機種もあるため、必要な時だけ送る
+		if cmd.Data {
+			frames = append(frames, civFrame(addr, 0x1A, 0x06, 0x01, 0x01))
+		} else if state.Data {
+			frames = append(frames, civFrame(addr, 0x1A, 0x06, 0x00, 0x00))
+		}
+		return frames, civFrame(addr, 0x04), nil
+
+	case "setVFO":
+		var v byte
+		switch strings.ToUpper(cmd.VFO) {
+		case "A":
+			v = 0x00
+		case "B":
+			v = 0x01
+		case "MAIN":
+			v = 0xD0
+		case "SUB":
+			v = 0xD1
+		default:
+			return nil, nil, fmt.Errorf("unknown vfo: %q", cmd.VFO)
+		}
+		return [][]byte{civFrame(addr, 0x07, v)}, civFrame(addr, 0x03), nil
+
+	case "setSplit":
+		var v byte
+		if cmd.Split {
+			v = 0x01
+		}
+		return [][]byte{civFrame(addr, 0x0F, v)}, nil, nil
+
+	case "setPTT":
+		var v byte
+		if cmd.PTT {
+			v = 0x01
+		}
+		return [][]byte{civFrame(addr, 0x1C, 0x00, v)}, civFrame(addr, 0x1C, 0x00), nil
+	}
+	return nil, nil, fmt.Errorf("unknown command: %s", cmd.Type)
+}
+
+// catModes maps modes to Yaesu CAT mode codes (the reverse of yaesuModes,
+// plus the narrow modes rigctld clients can set).
+// Index 1 is the code with DATA on, if the rig has one.
+var catModes = map[RigMode][2]string{
+	ModeLSB:   {"1", "8"},
+	ModeUSB:   {"2", "C"},
+	ModeCW:    {"3"},
+	ModeFM:    {"4", "A"},
+	ModeAM:    {"5"},
+	ModeRTTY:  {"6"},
+	ModeCWR:   {"7"},
+	ModeRTTYR: {"9"},
+	"FM-N":    {"B"},
+	"AM-N":    {"D"},
+	ModeDV:    {"E"}, // C4FM
+	"C4FM":    {"E"},
+}
+
+// normalizeRigMode maps the mode names of rig events to RigMode.
+func normalizeRigMode(m RigMode) RigMode {
+	switch m := RigMode(strings.ToUpper(string(m))); m {
+	case "CW-U":
+		return ModeCW
+	case "RTTY-LSB":
+		return ModeRTTY
+	case "RTTY-USB":
+		return ModeRTTYR
+	default:
+		return m
+	}
+}
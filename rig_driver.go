@@ -0,0 +1,775 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// Rig profiles selectable per port (RigPortConfig.Profile).
+const (
+	RigProfileAuto        = "auto"
+	RigProfileYaesu       = "yaesu"
+	RigProfileYaesuLegacy = "yaesu-legacy"
+	RigProfileKenwood     = "kenwood"
+	RigProfileElecraft    = "elecraft"
+	RigProfileICOM        = "icom"
+)
+
+// RigDriver is the protocol of one rig family: how the rig is polled, how
+// its answers are read into the port state and how control commands are
+// encoded. Drivers keep no state of their own; what they learn about a
+// port is kept in its rigLink.
+type RigDriver interface {
+	// Name returns the profile name.
+	Name() string
+	// Proto returns the protocol reported in rig events.
+	Proto() RigProto
+	// Start returns the commands sent once the rig is found.
+	Start(index int) [][]byte
+	// Poll returns the query sent every 2 seconds when the rig does not
+	// report changes by itself, or nil.
+	Poll(index int) []byte
+	// PollPTT returns the query that reads the TX/RX state, or nil.
+	PollPTT(index int) []byte
+	// Frames cuts the complete frames off buf and returns the rest.
+	Frames(index int, buf []byte) (frames [][]byte, rest []byte)
+	// Parse handles one frame received from the rig.
+	Parse(index int, f []byte)
+	// Command returns the frames of a control command and the query that
+	// reads back the changed state.
+	Command(index int, cmd RigControlCommand, state RigState) (frames [][]byte, refresh []byte, err error)
+}
+
+var rigDrivers = map[string]RigDriver{
+	RigProfileYaesu:       yaesuDriver{},
+	RigProfileYaesuLegacy: yaesuLegacyDriver{},
+	RigProfileKenwood:     kenwoodDriver{},
+	RigProfileElecraft:    elecraftDriver{},
+	RigProfileICOM:        icomDriver{},
+}
+
+// rigProfile is a profile choice of the settings page.
+type rigProfile struct {
+	Value string
+	Label string
+}
+
+var rigProfiles = []rigProfile{
+	{RigProfileAuto, "自動判別"},
+	{RigProfileYaesu, "Yaesu（FTDX / FT-991 / FT-710 等）"},
+	{RigProfileYaesuLegacy, "Yaesu 旧機種（FT-817 / FT-857 / FT-897）"},
+	{RigProfileKenwood, "Kenwood（TS-590 / TS-890 / TS-2000 等）"},
+	{RigProfileElecraft, "Elecraft（K3 / K4 / KX）"},
+	{RigProfileICOM, "ICOM（CI-V）"},
+}
+
+// rigDriverForProfile returns the driver of a profile, or nil for "auto"
+// and unknown profiles, whose protocol is detected from the received data.
+func rigDriverForProfile(profile string) RigDriver {
+	return rigDrivers[profile]
+}
+
+// setRigDriver sets the driver of a port when it is opened and forgets
+// what was learned from the rig before. auto means the driver is to be
+// detected (d is nil) and may be refined later.
+func setRigDriver(index int, d RigDriver, auto bool) {
+	l := rigLinkFor(index)
+	rigLinksMu.Lock()
+	l.driver = d
+	l.auto = auto
+	l.civAddr = 0
+	l.civRigs = nil
+	l.civSent = nil
+	l.catDigits = 0
+	l.catData = false
+	l.binOut = nil
+	l.binWait = nil
+	// 前の接続で分かったことは引き継がない（電源を入れ直したリグや別のリグかもしれない）
+	l.vfo = ""
+	l.split = false
+	l.polled = time.Time{}
+	l.pttQuery = nil
+	l.pttWait = false
+	l.pttNG = 0
+	l.noPTTPoll = false
+	l.sentAt = time.Time{}
+	l.ptyAt = time.Time{}
+	if l.txTimer != nil {
+		l.txTimer.Stop()
+		l.txTimer = nil
+	}
+	rigLinksMu.Unlock()
+}
+
+// claimRigDriver sets the detected driver of a port unless one is set
+// already, and reports whether it did.
+func claimRigDriver(index int, d RigDriver) bool {
+	l := rigLinkFor(index)
+	rigLinksMu.Lock()
+	defer rigLinksMu.Unlock()
+	if l.driver != nil {
+		return false
+	}
+	l.driver = d
+	return true
+}
+
+// rigDriverFor returns the driver of a port, or nil until it is detected.
+func rigDriverFor(index int) RigDriver {
+	l := rigLinkFor(index)
+	rigLinksMu.Lock()
+	defer rigLinksMu.Unlock()
+	return l.driver
+}
+
+// rigWriteWatcher is implemented by drivers that must see what is written
+// to the rig, such as binary protocols whose answers do not name the
+// command they answer.
+type rigWriteWatcher interface {
+	Sent(index int, b []byte)
+}
+
+// rigPort is the serial port of a rig. Every write, including those of
+// the PTY router, is shown to the driver of the port.
+type rigPort struct {
+	serial.Port
+	index int
+	name  string // デバイス名（抜去の検出用）
+}
+
+func (p *rigPort) Write(b []byte) (int, error) {
+	noteRigSent(p.index, b)
+	if w, ok := rigDriverFor(p.index).(rigWriteWatcher); ok {
+		w.Sent(p.index, b)
+	}
+	return p.Port.Write(b)
+}
+
+// rigStream cuts the bytes read from a port into frames of its driver. On
+// "auto" it first detects the protocol from the data.
+type rigStream struct {
+	index   int
+	detect  []byte // プロトコル検出用バッファ（CI-V分割受信対策）
+	pending []byte // 未完成のフレーム（分割受信対策）
+}
+
+// feed adds data read from the port and returns the driver with the
+// complete frames. found is true when this call detected the driver; d is
+// nil while the protocol is still unknown.
+func (r *rigStream) feed(data []byte) (d RigDriver, frames [][]byte, found bool) {
+	d = rigDriverFor(r.index)
+	if d == nil {
+		// プロトコル未確定時はバッファに蓄積して判定
+		r.detect = append(r.detect, data...)
+		switch detectProto(r.detect) {
+		case ProtoCIV:
+			d = rigDrivers[RigProfileICOM]
+		case ProtoCAT:
+			d = rigDrivers[RigProfileYaesu] // FA の桁数で Kenwood に切り替わる
+		default:
+			// バッファが大きくなりすぎないよう制限
+			if len(r.detect) > 64 {
+				r.detect = r.detect[len(r.detect)-32:]
+			}
+			return nil, nil, false
+		}
+		if found = claimRigDriver(r.index, d); !found {
+			d = rigDriverFor(r.index) // 探査側が先に決めた
+		}
+		// 検出後、蓄積データを処理対象にする
+		data = r.detect
+		r.detect = nil
+	}
+
+	r.pending = append(r.pending, data...)
+	frames, r.pending = d.Frames(r.index, r.pending)
+	if len(r.pending) > 256 {
+		r.pending = nil // 区切りの来ないゴミ
+	}
+	return d, frames, found
+}
+
+// ---- CAT (Yaesu / Kenwood / Elecraft) ----
+
+// catFrames cuts ";"-terminated CAT answers; the frames exclude the ";".
+func catFrames(buf []byte) (frames [][]byte, rest []byte) {
+	for {
+		i := bytes.IndexByte(buf, ';')
+		if i < 0 {
+			return frames, buf
+		}
+		frames = append(frames, buf[:i])
+		buf = buf[i+1:]
+	}
+}
+
+// catAnswer handles what every CAT answer is checked for and reports
+// whether cmd is to be parsed further.
+func catAnswer(index int, cmd string) bool {
+	if cmd == "?" {
+		noteRigAck(index, false)
+		return false
+	}
+	if len(cmd) < 2 {
+		return false
+	}
+	if strings.HasPrefix(cmd, "FA") {
+		noteCATFreq(index, cmd)
+	}
+	return true
+}
+
+// catDigitsFor returns the number of FA digits seen on a port.
+func catDigitsFor(index int) int {
+	l := rigLinkFor(index)
+	rigLinksMu.Lock()
+	defer rigLinksMu.Unlock()
+	return l.catDigits
+}
+
+// catFamilyUnknown reports whether a port on "auto" has not told Yaesu
+// from Kenwood yet, which an FA answer does by its number of digits.
+func catFamilyUnknown(index int) bool {
+	l := rigLinkFor(index)
+	rigLinksMu.Lock()
+	defer rigLinksMu.Unlock()
+	return l.auto && l.catDigits == 0
+}
+
+// parseDigits reads a decimal number; ok is false if s has a non-digit.
+func parseDigits(s string) (n int64, ok bool) {
+	if s == "" {
+		return 0, false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		n = n*10 + int64(c-'0')
+	}
+	return n, true
+}
+
+// catFreqCommand encodes FA with the given number of digits.
+func catFreqCommand(digits int, freq int64) ([][]byte, []byte, error) {
+	if freq <= 0 {
+		return nil, nil, errors.New("freq is required")
+	}
+	if digits == 8 {
+		freq /= 10 // 8桁のリグは 10Hz 単位
+	}
+	s := fmt.Sprintf("FA%0*d;", digits, freq)
+	if len(s) != digits+3 {
+		return nil, nil, errors.New("frequency out of range for this rig")
+	}
+	return [][]byte{[]byte(s)}, []byte("FA;"), nil
+}
+
+// yaesuModes are the MD codes of the Yaesu ASCII CAT. Narrow FM / AM are
+// reported as FM / AM and C4FM as DV.
+var yaesuModes = map[byte]struct {
+	mode RigMode
+	data bool
+}{
+	'1': {ModeLSB, false},
+	'2': {ModeUSB, false},
+	'3': {ModeCW, false}, // CW-U
+	'4': {ModeFM, false},
+	'5': {ModeAM, false},
+	'6': {ModeRTTY, false},  // RTTY-LSB
+	'7': {ModeCWR, false},   // CW-L
+	'8': {ModeLSB, true},    // DATA-LSB
+	'9': {ModeRTTYR, false}, // RTTY-USB
+	'A': {ModeFM, true},     // DATA-FM
+	'B': {ModeFM, false},    // FM-N
+	'C': {ModeUSB, true},    // DATA-USB
+	'D': {ModeAM, false},    // AM-N
+	'E': {ModeDV, false},    // C4FM
+}
+
+// yaesuDriver is the ASCII CAT of current Yaesu rigs (FTDX10, FTDX101,
+// FT-991A, FT-710, FT-891 ...).
+type yaesuDriver struct{}
+
+func (yaesuDriver) Name() string    { return RigProfileYaesu }
+func (yaesuDriver) Proto() RigProto { return ProtoCAT }
+
+func (yaesuDriver) Start(int) [][]byte { return [][]byte{[]byte("AI1;FA;MD0;")} }
+func (yaesuDriver) Poll(int) []byte    { return []byte("FA;MD0;") }
+func (yaesuDriver) PollPTT(int) []byte { return []byte("TX;") }
+
+func (yaesuDriver) Frames(_ int, buf []byte) ([][]byte, []byte) { return catFrames(buf) }
+
+func (yaesuDriver) Parse(index int, f []byte) {
+	// 自動判別で同じ読み取りの FA から Kenwood に切り替わった
+	if d := rigDriverFor(index); d != nil && d.Name() != RigProfileYaesu {
+		d.Parse(index, f)
+		return
+	}
+	cmd := string(f)
+	if !catAnswer(index, cmd) {
+		return
+	}
+	// Kenwood と MD / IF の意味が違うため、FA の桁数で分かるまで読まない
+	if !strings.HasPrefix(cmd, "FA") && catFamilyUnknown(index) {
+		return
+	}
+	// TX0 = 受信, TX1 / TX2 = 送信
+	if len(cmd) == 3 && cmd[:2] == "TX" {
+		updateRigPTTForPort(index, cmd[2] != '0')
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(cmd, "IF"):
+		// IF P1(3) P2(9) P3(5) P4(1) P5(1) P6(1) ...
+		// IF004430698000+000000E00000
+		if len(cmd) < 22 {
+			return
+		}
+		hz, ok := parseDigits(cmd[5:14])
+		if !ok {
+			return
+		}
+		m := yaesuModes[cmd[21]]
+		updateRigStateForPort(index, hz, string(m.mode), m.data, ProtoCAT)
+	case strings.HasPrefix(cmd, "FA"):
+		if freq := parseCATFreq(cmd); freq > 0 {
+			updateRigStateForPort(index, freq, "", false, ProtoCAT)
+		}
+	case strings.HasPrefix(cmd, "MD") && len(cmd) == 4:
+		// MD0x（x = モードコード）
+		if m, ok := yaesuModes[cmd[3]]; ok {
+			updateRigStateForPort(index, 0, string(m.mode), m.data, ProtoCAT)
+		}
+	}
+}
+
+func (yaesuDriver) Command(index int, cmd RigControlCommand, _ RigState) ([][]byte, []byte, error) {
+	switch cmd.Type {
+	case "setFreq":
+		digits := catDigitsFor(index)
+		if digits == 0 {
+			digits = 9
+		}
+		return catFreqCommand(digits, cmd.Freq)
+
+	case "setMode":
+		codes, ok := catModes[normalizeRigMode(cmd.Mode)]
+		if !ok {
+			return nil, nil, fmt.Errorf("unsupported mode: %q", cmd.Mode)
+		}
+		code := codes[0]
+		if cmd.Data {
+			if codes[1] == "" {
+				return nil, nil, fmt.Errorf("mode %s has no DATA mode", cmd.Mode)
+			}
+			code = codes[1]
+		}
+		return [][]byte{[]byte("MD0" + code + ";")}, []byte("MD0;"), nil
+
+	case "setVFO":
+		v, err := catVFO(cmd.VFO)
+		if err != nil {
+			return nil, nil, err
+		}
+		return [][]byte{[]byte("VS" + v + ";")}, []byte("FA;"), nil
+
+	case "setSplit":
+		// FT2 = 送信 VFO-A（スプリット OFF）, FT3 = 送信 VFO-B（スプリット ON）
+		if cmd.Split {
+			return [][]byte{[]byte("FT3;")}, nil, nil
+		}
+		return [][]byte{[]byte("FT2;")}, nil, nil
+
+	case "setPTT":
+		if cmd.PTT {
+			return [][]byte{[]byte("TX1;")}, []byte("TX;"), nil
+		}
+		return [][]byte{[]byte("TX0;")}, []byte("TX;"), nil
+	}
+	return nil, nil, fmt.Errorf("unknown command: %s", cmd.Type)
+}
+
+// catVFO returns the CAT VFO number: 0 = A / MAIN, 1 = B / SUB.
+func catVFO(vfo string) (string, error) {
+	switch strings.ToUpper(vfo) {
+	case "A", "MAIN":
+		return "0", nil
+	case "B", "SUB":
+		return "1", nil
+	}
+	return "", fmt.Errorf("unknown vfo: %q", vfo)
+}
+
+// kenwoodModes are the MD codes of the Kenwood TS series. They differ from
+// Yaesu: 3 is CW, 6 / 9 are FSK, and DATA is switched by DA.
+var kenwoodModes = map[byte]RigMode{
+	'1': ModeLSB,
+	'2': ModeUSB,
+	'3': ModeCW,
+	'4': ModeFM,
+	'5': ModeAM,
+	'6': ModeRTTY,
+	'7': ModeCWR,
+	'9': ModeRTTYR,
+}
+
+// kenwoodModeCode returns the MD code of a mode (the reverse of kenwoodModes).
+func kenwoodModeCode(m RigMode) (byte, bool) {
+	for c, km := range kenwoodModes {
+		if km == m {
+			return c, true
+		}
+	}
+	return 0, false
+}
+
+// kenwoodDataModes are the modes DA1 applies to.
+var kenwoodDataModes = map[RigMode]bool{ModeLSB: true, ModeUSB: true, ModeFM: true, ModeAM: true}
+
+// kenwoodDriver is the CAT of Kenwood TS-series rigs (TS-590, TS-890,
+// TS-990, TS-2000 ...). They answer FA with 11 digits.
+type kenwoodDriver struct{}
+
+func (kenwoodDriver) Name() string    { return RigProfileKenwood }
+func (kenwoodDriver) Proto() RigProto { return ProtoCAT }
+
+func (kenwoodDriver) Start(int) [][]byte { return [][]byte{[]byte("AI1;IF;")} }
+func (kenwoodDriver) Poll(int) []byte    { return []byte("IF;") }
+func (kenwoodDriver) PollPTT(int) []byte { return []byte("IF;") } // "TX;" は送信になる
+
+func (kenwoodDriver) Frames(_ int, buf []byte) ([][]byte, []byte) { return catFrames(buf) }
+
+func (kenwoodDriver) Parse(index int, f []byte) {
+	cmd := string(f)
+	if !catAnswer(index, cmd) {
+		return
+	}
+	// Auto Information は送信を TXn、受信を RX で通知する
+	switch {
+	case cmd == "RX":
+		updateRigPTTForPort(index, false)
+		return
+	case len(cmd) == 3 && cmd[:2] == "TX":
+		updateRigPTTForPort(index, true)
+		return
+	}
+
+	if strings.HasPrefix(cmd, "IF") {
+		mode, hz, ok := parseKenwoodIF(cmd)
+		if !ok {
+			return
+		}
+		updateRigPTTForPort(index, cmd[28] == '1')
+		updateRigStateForPort(index, hz, string(mode), kenwoodData(index, mode), ProtoCAT)
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(cmd, "FA"):
+		if freq := parseCATFreq(cmd); freq > 0 {
+			updateRigStateForPort(index, freq, "", false, ProtoCAT)
+		}
+	case strings.HasPrefix(cmd, "MD") && len(cmd) >= 3:
+		if mode, ok := kenwoodModes[cmd[len(cmd)-1]]; ok {
+			updateRigStateForPort(index, 0, string(mode), kenwoodData(index, mode), ProtoCAT)
+		}
+	case cmd == "DA0" || cmd == "DA1":
+		rigStatesMu.RLock()
+		var mode RigMode
+		if st := rigStates[index]; st != nil {
+			mode = st.Mode
+		}
+		rigStatesMu.RUnlock()
+		if mode != "" {
+			setKenwoodData(index, cmd == "DA1")
+			updateRigStateForPort(index, 0, string(mode), kenwoodData(index, mode), ProtoCAT)
+		}
+	}
+}
+
+// parseKenwoodIF reads the frequency and mode of a Kenwood / Elecraft IF
+// answer: IF P1(11) P2(5) P3(5) P4(1) P5(1) P6(3) P7(1) P8(1) ...
+// P7 (cmd[28]) is TX/RX and P8 (cmd[29]) the MD code.
+func parseKenwoodIF(cmd string) (RigMode, int64, bool) {
+	if len(cmd) < 30 {
+		return "", 0, false
+	}
+	hz, ok := parseDigits(cmd[2:13])
+	if !ok {
+		return "", 0, false
+	}
+	mode, ok := kenwoodModes[cmd[29]]
+	return mode, hz, ok
+}
+
+// kenwoodData returns the DATA state of a port for mode, as last reported
+// by DA.
+func kenwoodData(index int, mode RigMode) bool {
+	if !kenwoodDataModes[mode] {
+		return false
+	}
+	l := rigLinkFor(index)
+	rigLinksMu.Lock()
+	defer rigLinksMu.Unlock()
+	return l.catData
+}
+
+func setKenwoodData(index int, on bool) {
+	l := rigLinkFor(index)
+	rigLinksMu.Lock()
+	l.catData = on
+	rigLinksMu.Unlock()
+}
+
+func (kenwoodDriver) Command(index int, cmd RigControlCommand, state RigState) ([][]byte, []byte, error) {
+	switch cmd.Type {
+	case "setMode":
+		mode := normalizeRigMode(cmd.Mode)
+		code, ok := kenwoodModeCode(mode)
+		if !ok {
+			return nil, nil, fmt.Errorf("unsupported mode: %q", cmd.Mode)
+		}
+		if cmd.Data && !kenwoodDataModes[mode] {
+			return nil, nil, fmt.Errorf("mode %s has no DATA mode", cmd.Mode)
+		}
+		frames := [][]byte{[]byte("MD" + string(code) + ";")}
+		// Kenwood は DATA を DA コマンドで切り替える（DA のない TS-2000 などには送らない）
+		if cmd.Data {
+			frames = append(frames, []byte("DA1;"))
+		} else if state.Data {
+			frames = append(frames, []byte("DA0;"))
+		}
+		return frames, []byte("IF;"), nil
+	}
+	return kenwoodCommand(cmd)
+}
+
+// kenwoodCommand encodes the commands Kenwood and Elecraft share.
+func kenwoodCommand(cmd RigControlCommand) ([][]byte, []byte, error) {
+	switch cmd.Type {
+	case "setFreq":
+		return catFreqCommand(11, cmd.Freq)
+
+	case "setVFO":
+		v, err := catVFO(cmd.VFO)
+		if err != nil {
+			return nil, nil, err
+		}
+		// 受信・送信 VFO を揃える
+		return [][]byte{[]byte("FR" + v + ";"), []byte("FT" + v + ";")}, []byte("FA;"), nil
+
+	case "setSplit":
+		// 受信 VFO-A / 送信 VFO-B
+		if cmd.Split {
+			return [][]byte{[]byte("FR0;"), []byte("FT1;")}, nil, nil
+		}
+		return [][]byte{[]byte("FR0;"), []byte("FT0;")}, nil, nil
+
+	case "setPTT":
+		if cmd.PTT {
+			return [][]byte{[]byte("TX;")}, []byte("IF;"), nil
+		}
+		return [][]byte{[]byte("RX;")}, []byte("IF;"), nil
+	}
+	return nil, nil, fmt.Errorf("unknown command: %s", cmd.Type)
+}
+
+// elecraftDriver is the CAT of Elecraft K3 / K4 / KX rigs: the Kenwood
+// command set with MD6 / MD9 as DATA / DATA-REV and the data sub-mode
+// selected by DT.
+type elecraftDriver struct{}
+
+func (elecraftDriver) Name() string    { return RigProfileElecraft }
+func (elecraftDriver) Proto() RigProto { return ProtoCAT }
+
+func (elecraftDriver) Start(int) [][]byte { return [][]byte{[]byte("AI1;IF;")} }
+func (elecraftDriver) Poll(int) []byte    { return []byte("IF;") }
+func (elecraftDriver) PollPTT(int) []byte { return []byte("TQ;") }
+
+func (elecraftDriver) Frames(_ int, buf []byte) ([][]byte, []byte) { return catFrames(buf) }
+
+func (elecraftDriver) Parse(index int, f []byte) {
+	cmd := string(f)
+	if !catAnswer(index, cmd) {
+		return
+	}
+	switch {
+	case cmd == "TQ0" || cmd == "TQ1":
+		updateRigPTTForPort(index, cmd == "TQ1")
+		return
+	case cmd == "RX":
+		updateRigPTTForPort(index, false)
+		return
+	case len(cmd) == 3 && cmd[:2] == "TX":
+		updateRigPTTForPort(index, true)
+		return
+	}
+
+	if strings.HasPrefix(cmd, "IF") {
+		if len(cmd) < 35 {
+			return
+		}
+		hz, ok := parseDigits(cmd[2:13])
+		mode, data, known := elecraftMode(cmd[29], cmd[34])
+		if !ok || !known {
+			return
+		}
+		updateRigPTTForPort(index, cmd[28] == '1')
+		updateRigStateForPort(index, hz, string(mode), data, ProtoCAT)
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(cmd, "FA"):
+		if freq := parseCATFreq(cmd); freq > 0 {
+			updateRigStateForPort(index, freq, "", false, ProtoCAT)
+		}
+	case strings.HasPrefix(cmd, "MD") && len(cmd) == 3:
+		// DT は IF の P14 で読む（MD だけでは DATA A と FSK D を区別できない）
+		if mode, data, ok := elecraftMode(cmd[2], '0'); ok {
+			updateRigStateForPort(index, 0, string(mode), data, ProtoCAT)
+		}
+	}
+}
+
+// elecraftMode maps an Elecraft MD code and DT data sub-mode to a mode.
+// DATA A / AFSK A / PSK D are SSB with DATA on; FSK D is RTTY.
+func elecraftMode(md, dt byte) (mode RigMode, data bool, ok bool) {
+	switch md {
+	case '6', '9':
+		rev := md == '9'
+		if dt == '2' {
+			if rev {
+				return ModeRTTYR, false, true
+			}
+			return ModeRTTY, false, true
+		}
+		if rev {
+			return ModeLSB, true, true
+		}
+		return ModeUSB, true, true
+	}
+	mode, ok = kenwoodModes[md]
+	return mode, false, ok
+}
+
+func (elecraftDriver) Command(index int, cmd RigControlCommand, _ RigState) ([][]byte, []byte, error) {
+	if cmd.Type != "setMode" {
+		return kenwoodCommand(cmd)
+	}
+
+	var md string
+	switch mode := normalizeRigMode(cmd.Mode); {
+	case cmd.Data && mode == ModeUSB:
+		md = "MD6;DT0;"
+	case cmd.Data && mode == ModeLSB:
+		md = "MD9;DT0;"
+	case cmd.Data:
+		return nil, nil, fmt.Errorf("mode %s has no DATA mode", cmd.Mode)
+	case mode == ModeRTTY:
+		md = "MD6;DT2;"
+	case mode == ModeRTTYR:
+		md = "MD9;DT2;"
+	default:
+		code, ok := kenwoodModeCode(mode)
+		if !ok {
+			return nil, nil, fmt.Errorf("unsupported mode: %q", cmd.Mode)
+		}
+		md = "MD" + string(code) + ";"
+	}
+	var frames [][]byte
+	for _, c := range strings.SplitAfter(md, ";") {
+		if c != "" {
+			frames = append(frames, []byte(c))
+		}
+	}
+	return frames, []byte("IF;"), nil
+}
+
+// ---- ICOM CI-V ----
+
+// icomDriver is the CI-V protocol of ICOM rigs. The rig address is pinned
+// in the settings or learned from the frames it sends.
+type icomDriver struct{}
+
+func (icomDriver) Name() string    { return RigProfileICOM }
+func (icomDriver) Proto() RigProto { return ProtoCIV }
+
+// Start reads the frequency and mode, from the pinned radio if there is
+// one. Some rigs (IC-7300 / IC-9700) answer only the last of several
+// queries, so they are sent one by one.
+func (icomDriver) Start(index int) [][]byte {
+	if addr := civPinFor(index); addr != 0 {
+		return [][]byte{civFrame(addr, 0x03), civFrame(addr, 0x04)}
+	}
+	return [][]byte{
+		{0xFE, 0xFE, 0x00, 0x00, 0x03, 0xFD}, // freq
+		{0xFE, 0xFE, 0x00, 0x00, 0x04, 0xFD}, // mode
+	}
+}
+
+// Poll is nil: the rig reports changes with CI-V transceive.
+func (icomDriver) Poll(int) []byte { return nil }
+
+func (icomDriver) PollPTT(index int) []byte {
+	if addr := civAddrFor(index); addr != 0 {
+		return civFrame(addr, 0x1C, 0x00)
+	}
+	return nil
+}
+
+// Frames cuts FE FE ... FD frames and drops the bytes before them.
+func (icomDriver) Frames(_ int, buf []byte) (frames [][]byte, rest []byte) {
+	for {
+		start := bytes.Index(buf, []byte{0xFE, 0xFE})
+		if start < 0 {
+			// FE FE がない場合、単独FEがあれば残す
+			if lastFE := bytes.LastIndexByte(buf, 0xFE); lastFE >= 0 {
+				return frames, buf[lastFE:] // 最後のFE以降を残す
+			}
+			return frames, nil
+		}
+		// FE FE の前のゴミを除去し、FE FE の後から FD を探す
+		buf = buf[start:]
+		end := bytes.IndexByte(buf[2:], 0xFD)
+		if end < 0 {
+			return frames, buf // FD がまだ来ていない
+		}
+		frames = append(frames, buf[:2+end+1])
+		buf = buf[2+end+1:]
+	}
+}
+
+func (icomDriver) Parse(index int, f []byte) { parseCIVFrameForPort(index, f) }
+
+// Sent records the frames written to the port to recognize their echoes.
+func (icomDriver) Sent(index int, b []byte) { noteCIVSent(index, b) }
+
+func (icomDriver) Command(index int, cmd RigControlCommand, state RigState) ([][]byte, []byte, error) {
+	addr := civAddrFor(index)
+	if addr == 0 {
+		return nil, nil, fmt.Errorf("%w: CI-V address unknown", errRigNotReady)
+	}
+	return civCommand(addr, cmd, state)
+}
+
+// civAddrFor returns the CI-V address of the port's radio, or 0.
+func civAddrFor(index int) byte {
+	l := rigLinkFor(index)
+	rigLinksMu.Lock()
+	defer rigLinksMu.Unlock()
+	return l.civAddr
+}
+
+// logRigDriver logs the driver in use on a port.
+func logRigDriver(index int, d RigDriver, how string) {
+	log.Printf("[RIG-%d] driver: %s (%s)", index, d.Name(), how)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestKenwoodSetMode(t *testing.T) {
+	tests := []struct {
+		mode  RigMode
+		data  bool
+		state RigState
+		want  string
+	}{
+		{ModeUSB, false, RigState{}, "MD2;"}, // TS-2000 など DA のないリグにも送れる
+		{ModeUSB, true, RigState{}, "MD2;DA1;"},
+		{ModeUSB, false, RigState{Mode: ModeUSB, Data: true}, "MD2;DA0;"},
+		{ModeCW, false, RigState{Mode: ModeLSB, Data: true}, "MD3;DA0;"},
+		{ModeFM, true, RigState{Mode: ModeFM, Data: true}, "MD4;DA1;"},
+	}
+	for _, tt := range tests {
+		var cmd RigControlCommand
+		cmd.Type, cmd.Mode, cmd.Data = "setMode", tt.mode, tt.data
+		frames, refresh, err := kenwoodDriver{}.Command(0, cmd, tt.state)
+		if err != nil {
+			t.Errorf("setMode %s data=%v: %v", tt.mode, tt.data, err)
+			continue
+		}
+		var got strings.Builder
+		for _, f := range frames {
+			got.Write(f)
+		}
+		if got.String() != tt.want || string(refresh) != "IF;" {
+			t.Errorf("setMode %s data=%v state=%+v = %q, %q; want %q, \"IF;\"",
+				tt.mode, tt.data, tt.state, got.String(), refresh, tt.want)
+		}
+	}
+
+	var cmd RigControlCommand
+	cmd.Type, cmd.Mode, cmd.Data = "setMode", ModeCW, true
+	if _, _, err := (kenwoodDriver{}).Command(0, cmd, RigState{}); err == nil {
+		t.Error("setMode CW data=true succeeded")
+	}
+}
+
+// rigStateOf returns the state of a port, or the zero state.
+func rigStateOf(index int) RigState {
+	rigStatesMu.RLock()
+	defer rigStatesMu.RUnlock()
+	if st := rigStates[index]; st != nil {
+		return *st
+	}
+	return RigState{}
+}
+
+// resetRigPort forgets the state and the driver of a port.
+func resetRigPort(t *testing.T, index int) {
+	t.Helper()
+	reset := func() {
+		rigStatesMu.Lock()
+		delete(rigStates, index)
+		rigStatesMu.Unlock()
+		rigLinksMu.Lock()
+		delete(rigLinks, index)
+		rigLinksMu.Unlock()
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func TestYaesuParseMode(t *testing.T) {
+	tests := []struct {
+		frame string
+		mode  RigMode
+		data  bool
+	}{
+		{"MD01", ModeLSB, false},
+		{"MD02", ModeUSB, false},
+		{"MD03", ModeCW, false},
+		{"MD04", ModeFM, false},
+		{"MD05", ModeAM, false},
+		{"MD06", ModeRTTY, false},
+		{"MD07", ModeCWR, false},
+		{"MD08", ModeLSB, true},
+		{"MD09", ModeRTTYR, false},
+		{"MD0A", ModeFM, true},
+		{"MD0B", ModeFM, false},
+		{"MD0C", ModeUSB, true},
+		{"MD0D", ModeAM, false},
+		{"MD0E", ModeDV, false},
+		{"IF001014074000+000000C00000", ModeUSB, true},
+	}
+	const index = 93
+	for _, tt := range tests {
+		resetRigPort(t, index)
+		setRigDriver(index, yaesuDriver{}, false)
+		yaesuDriver{}.Parse(index, []byte(tt.frame))
+		st := rigStateOf(index)
+		if st.Mode != tt.mode || st.Data != tt.data {
+			t.Errorf("%s: mode %q data %v, want %q %v", tt.frame, st.Mode, st.Data, tt.mode, tt.data)
+		}
+		if _, ok := catModes[st.Mode]; !ok {
+			t.Errorf("%s: mode %q cannot be set back", tt.frame, st.Mode)
+		}
+	}
+}
+
+func TestCATAutoDetect(t *testing.T) {
+	const index = 93
+	tests := []struct {
+		name   string
+		chunks []string
+		driver string
+		freq   int64
+		mode   RigMode
+		data   bool
+	}{
+		{
+			name:   "Yaesu",
+			chunks: []string{"MD02;", "FA014074000;MD0C;"},
+			driver: RigProfileYaesu,
+			freq:   14_074_000,
+			mode:   ModeUSB,
+			data:   true,
+		},
+		{
+			// FA より前の MD3 / IF を Yaesu の表で読まない
+			name:   "Kenwood",
+			chunks: []string{"MD3;IF00007074000     +00000000001000000 ;", "FA00014074000;MD3;"},
+			driver: RigProfileKenwood,
+			freq:   14_074_000,
+			mode:   ModeCW,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetRigPort(t, index)
+			setRigDriver(index, nil, true)
+			stream := &rigStream{index: index}
+			for i, c := range tt.chunks {
+				d, frames, _ := stream.feed([]byte(c))
+				if d == nil {
+					t.Fatalf("chunk %d: protocol not detected", i)
+				}
+				for _, f := range frames {
+					d.Parse(index, f)
+				}
+				if i == 0 {
+					if st := rigStateOf(index); st.Freq != 0 || st.Mode != "" {
+						t.Errorf("state before FA = %+v", st)
+					}
+				}
+			}
+			if d := rigDriverFor(index); d == nil || d.Name() != tt.driver {
+				t.Errorf("driver = %v, want %s", d, tt.driver)
+			}
+			st := rigStateOf(index)
+			if st.Freq != tt.freq || st.Mode != tt.mode || st.Data != tt.data {
+				t.Errorf("state = %+v, want %d %s data=%v", st, tt.freq, tt.mode, tt.data)
+			}
+		})
+	}
+}
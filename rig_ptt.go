@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// defaultMaxTXSeconds is the default of Config.MaxTXSeconds.
+const defaultMaxTXSeconds = 180
+
+// rigPTTPollInterval is how often the TX/RX state is read. Transceive
+// (CI-V) and Auto Information (CAT) do not report it on most rigs.
+const rigPTTPollInterval = time.Second
+
+// rigPTTRejectLimit is how many times in a row a rig must reject the PTT
+// read before it is no longer polled.
+const rigPTTRejectLimit = 3
+
+// rigPTYQuiet is how long after an application last wrote to the PTY of a
+// port the PTT is not polled. Its own queries keep the state current, and
+// the answers to ours would reach it unasked.
+const rigPTYQuiet = 5 * time.Second
+
+// updateRigPTTForPort records the TX/RX state of a port and broadcasts it.
+// A port that starts transmitting becomes the active port.
+func updateRigPTTForPort(index int, ptt bool) {
+	rigStatesMu.Lock()
+	if rigStates[index] == nil {
+		rigStates[index] = &RigState{Index: index}
+	}
+	changed := rigStates[index].PTT != ptt
+	rigStates[index].PTT = ptt
+	portState := *rigStates[index]
+	rigStatesMu.Unlock()
+
+	if !changed {
+		return
+	}
+	if ptt {
+		log.Printf("[RIG-%d] TX", index)
+	} else {
+		log.Printf("[RIG-%d] RX", index)
+		stopTXTimeout(index)
+	}
+
+	if !shouldBroadcastFromPort(index) {
+		return
+	}
+
+	lastActivePortMu.Lock()
+	lastActivePort = index
+	lastActiveTime = time.Now()
+	lastActivePortMu.Unlock()
+
+	rigMu.Lock()
+	if portState.Freq > 0 {
+		rigState.Freq = portState.Freq
+	}
+	if portState.Mode != "" {
+		rigState.Mode = portState.Mode
+		rigState.Data = portState.Data
+	}
+	rigState.PTT = ptt
+	rigState.Proto = portState.Proto
+	rigState.Index = index
+	rigMu.Unlock()
+
+	broadcastRigState()
+}
+
+// parseCIVPTT reads the answer to "1C 00" (FE FE to from 1C 00 <00|01> FD).
+func parseCIVPTT(index int, f []byte) {
+	if len(f) < 8 || f[5] != 0x00 || f[3] == civControllerAddr {
+		return // 自分が送ったコマンドのエコー
+	}
+	updateRigPTTForPort(index, f[6] == 0x01)
+}
+
+// pollRigPTT reads the TX/RX state of a port until ctx is cancelled.
+// Rigs that keep rejecting the read are not polled again.
+func pollRigPTT(ctx context.Context, index int, s serial.Port) {
+	ticker := time.NewTicker(rigPTTPollInterval)
+	defer ticker.Stop()
+
+	l := rigLinkFor(index)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		rigLinksMu.Lock()
+		d, disabled := l.driver, l.noPTTPoll
+		// 自動判別では FA の桁数で Kenwood と分かるまで読まない（Kenwood の "TX;" は送信になる）
+		unsure := l.auto && l.catDigits == 0
+		app := time.Since(l.ptyAt) < rigPTYQuiet
+		rigLinksMu.Unlock()
+		if disabled {
+			return
+		}
+		if d == nil || app || (unsure && d.Proto() == ProtoCAT) {
+			continue
+		}
+		query := d.PollPTT(index)
+		if query == nil {
+			continue
+		}
+
+		// 制御コマンドの応答と混ざらないよう、応答を待つ間はコマンドを止める
+		l.mu.Lock()
+		rigLinksMu.Lock()
+		l.pttPolledLocked(query)
+		rigLinksMu.Unlock()
+		_, err := s.Write(query)
+		time.Sleep(100 * time.Millisecond)
+		l.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// pttPolledLocked records that query is being sent to read the PTT, so
+// that the first answer to it can be told apart. rigLinksMu must be held.
+func (l *rigLink) pttPolledLocked(query []byte) {
+	l.polled = time.Now()
+	l.pttQuery = query
+	l.pttWait = true
+}
+
+// notePTYWrite records that an application wrote to the PTY of a port.
+func notePTYWrite(index int) {
+	l := rigLinkFor(index)
+	rigLinksMu.Lock()
+	l.ptyAt = time.Now()
+	rigLinksMu.Unlock()
+}
+
+// startTXTimeout unkeys the rig after Config.MaxTXSeconds, so that a
+// client that keyed the rig and went away cannot leave it transmitting.
+func startTXTimeout(index int) {
+	configLock.RLock()
+	max := time.Duration(config.MaxTXSeconds) * time.Second
+	configLock.RUnlock()
+	if max <= 0 {
+		max = defaultMaxTXSeconds * time.Second
+	}
+
+	l := rigLinkFor(index)
+	rigLinksMu.Lock()
+	defer rigLinksMu.Unlock()
+	if l.txTimer != nil {
+		l.txTimer.Stop()
+	}
+	l.txTimer = time.AfterFunc(max, func() {
+		log.Printf("[RIG-%d] TX timeout (%s): releasing PTT", index, max)
+		cmd := RigControlCommand{Command: Command{Type: "setPTT"}, Port: index}
+		if err := controlRig(cmd); err != nil {
+			log.Printf("[RIG-%d] PTT release failed: %v", index, err)
+		}
+	})
+}
+
+// stopTXTimeout cancels the TX timeout of a port.
+func stopTXTimeout(index int) {
+	l := rigLinkFor(index)
+	rigLinksMu.Lock()
+	defer rigLinksMu.Unlock()
+	if l.txTimer != nil {
+		l.txTimer.Stop()
+		l.txTimer = nil
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+// Only repeated rejections that answer the PTT read itself stop the
+// polling.
+func TestPTTPollRejected(t *testing.T) {
+	const index = 93
+	type step struct {
+		sent  string // PTT の読み取り以外の送信（"" = なし）
+		reply string // 受信したフレーム（"" = なし）
+		poll  bool
+	}
+	var (
+		reject   = step{poll: true, reply: "?"}
+		answered = step{poll: true, reply: "TX0"}
+		busy     = step{poll: true, sent: "FA;", reply: "?"}
+		stray    = step{reply: "?"}
+	)
+	tests := []struct {
+		name  string
+		steps []step
+		want  bool
+	}{
+		{"rejected", []step{reject, reject, reject}, true},
+		{"answered in between", []step{reject, reject, answered, reject, reject}, false},
+		{"other command in flight", []step{busy, busy, busy}, false},
+		{"not the first reply", []step{answered, stray, answered, stray, answered, stray}, false},
+		{"no poll", []step{stray, stray, stray}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetRigPort(t, index)
+			setRigDriver(index, yaesuDriver{}, false)
+			l := rigLinkFor(index)
+			for _, s := range tt.steps {
+				if s.sent != "" {
+					noteRigSent(index, []byte(s.sent))
+				}
+				if s.poll {
+					rigLinksMu.Lock()
+					l.pttPolledLocked([]byte("TX;"))
+					rigLinksMu.Unlock()
+				}
+				if s.reply != "" {
+					yaesuDriver{}.Parse(index, []byte(s.reply))
+					noteRigReply(index, []byte(s.reply))
+				}
+			}
+			rigLinksMu.Lock()
+			got := l.noPTTPoll
+			rigLinksMu.Unlock()
+			if got != tt.want {
+				t.Errorf("noPTTPoll = %v, want %v", got, tt.want)
+			}
+
+			// 開き直すとまた読み取る
+			setRigDriver(index, yaesuDriver{}, false)
+			rigLinksMu.Lock()
+			got = l.noPTTPoll
+			rigLinksMu.Unlock()
+			if got {
+				t.Error("noPTTPoll kept after setRigDriver")
+			}
+		})
+	}
+}
+
+// The PTT is not polled while an application talks to the rig through
+// the PTY, so that it gets no answers it did not ask for.
+func TestPTTPollPTYQuiet(t *testing.T) {
+	const index = 93
+	resetRigPort(t, index)
+	p := newTestRigPort(t, index, RigState{})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		pollRigPTT(ctx, index, p)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	notePTYWrite(index)
+	time.Sleep(rigPTTPollInterval + rigPTTPollInterval/2)
+	if got := p.take(); len(got) != 0 {
+		t.Errorf("polled while the PTY was in use: %q", got)
+	}
+
+	l := rigLinkFor(index)
+	rigLinksMu.Lock()
+	l.ptyAt = time.Now().Add(-rigPTYQuiet)
+	rigLinksMu.Unlock()
+	var got []string
+	waitFor(t, "PTT poll", func() bool {
+		got = append(got, p.take()...)
+		return len(got) > 0
+	})
+	if !slices.Equal(got, []string{"TQ;"}) {
+		t.Errorf("polled %q, want \"TQ;\"", got)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Reconnect timing of the rig port supervisor.
+const (
+	rigReconnectMin = time.Second
+	rigReconnectMax = 30 * time.Second
+	rigPresencePoll = 2 * time.Second
+)
+
+var (
+	errRigStopped = errors.New("stopped")
+	errRigRemoved = errors.New("device removed")
+)
+
+// rigWatchers holds the running rig port supervisors. Every goroutine that
+// serves a port runs under ctx, so stopping cancels it and waits on done
+// until all of them have returned.
+var rigWatchers struct {
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// runRigWatchers stops the running supervisors and runs serve for every
+// configured port until the watchers are stopped.
+func runRigWatchers(ports []RigPortConfig, serve func(ctx context.Context, index int, rp RigPortConfig)) {
+	rigWatchers.mu.Lock()
+	defer rigWatchers.mu.Unlock()
+	stopRigWatchersLocked()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var g errgroup.Group
+	for i, rp := range ports {
+		if rp.Port == "" {
+			continue
+		}
+		g.Go(func() error {
+			serve(ctx, i, rp)
+			return nil
+		})
+	}
+
+	done := make(chan struct{})
+	go func() {
+		_ = g.Wait()
+		close(done)
+	}()
+	rigWatchers.cancel = cancel
+	rigWatchers.done = done
+}
+
+// stopRigWatchers cancels the running supervisors and returns once their
+// ports are closed and all their goroutines have exited.
+func stopRigWatchers() {
+	rigWatchers.mu.Lock()
+	defer rigWatchers.mu.Unlock()
+	stopRigWatchersLocked()
+}
+
+func stopRigWatchersLocked() {
+	if rigWatchers.cancel == nil {
+		return
+	}
+	rigWatchers.cancel()
+	<-rigWatchers.done
+	rigWatchers.cancel = nil
+	rigWatchers.done = nil
+}
+
+// sleepCtx waits for d and reports false if ctx is cancelled first.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// rigPortRunner opens a rig port, calls opened once it is open and serves
+// it until it fails or ctx is cancelled. It returns after every goroutine
+// it started has exited.
+type rigPortRunner func(ctx context.Context, index int, rp RigPortConfig, opened func()) error
+
+// superviseRigPort keeps a configured rig port open until ctx is
+// cancelled. It waits for the device to appear, opens it with backoff and
+// runs it again after it is unplugged or the rig is power-cycled; protocol
+// detection starts over on every open.
+func superviseRigPort(ctx context.Context, index int, rp RigPortConfig, run rigPortRunner) {
+	backoff := rigReconnectMin
+	waiting := false
+
+	for ctx.Err() == nil {
+		if !serialPortPresent(rp.Port) {
+			if !waiting {
+				log.Printf("[RIG-%d] waiting for %s", index, rp.Port)
+				waiting = true
+			}
+			sleepCtx(ctx, rigPresencePoll)
+			continue
+		}
+		waiting = false
+
+		connected := false
+		err := run(ctx, index, rp, func() {
+			connected = true
+			backoff = rigReconnectMin
+			broadcast(&RigConnectionEvent{
+				Envelope: newEnvelope("rigConnected", ""),
+				Port:     index,
+				Device:   rp.Port,
+			})
+		})
+
+		stopped := ctx.Err() != nil
+		if connected {
+			// 切断中の状態は返さない（getRigState / rigctld はエラーになる）
+			rigStatesMu.Lock()
+			delete(rigStates, index)
+			rigStatesMu.Unlock()
+
+			reason := "stopped"
+			if !stopped && err != nil {
+				reason = err.Error()
+			}
+			broadcast(&RigConnectionEvent{
+				Envelope: newEnvelope("rigDisconnected", ""),
+				Port:     index,
+				Device:   rp.Port,
+				Reason:   reason,
+			})
+		}
+		if stopped {
+			return
+		}
+
+		log.Printf("[RIG-%d] reconnecting to %s in %s", index, rp.Port, backoff)
+		sleepCtx(ctx, backoff)
+		backoff *= 2
+		if backoff > rigReconnectMax {
+			backoff = rigReconnectMax
+		}
+	}
+}
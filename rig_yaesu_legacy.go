@@ -0,0 +1,282 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Opcodes of the binary CAT of the FT-817 / FT-857 / FT-897. A command is
+// four parameter bytes followed by the opcode.
+const (
+	ft817SetFreq    = 0x01
+	ft817SplitOn    = 0x02
+	ft817ReadFreq   = 0x03 // 周波数（BCD 4 バイト）+ モード
+	ft817SetMode    = 0x07
+	ft817PTTOn      = 0x08
+	ft817SplitOff   = 0x82
+	ft817PTTOff     = 0x88
+	ft817ReadEEPROM = 0xBB
+	ft817ReadRX     = 0xE7 // 受信状態（S メーター / スケルチ）
+	ft817ReadTX     = 0xF7 // 送信状態（bit 7 = 0 で送信中）
+)
+
+// ft817ReplyTimeout is how long an answer is waited for. Set commands are
+// answered with one byte (00 / F0), but not by every firmware.
+const ft817ReplyTimeout = 300 * time.Millisecond
+
+// ft817AckWait is how long the answer to a set command may take. A set
+// command still unanswered when the next command is sent is taken as not
+// answered, so that the answers to the next commands are not shifted.
+const ft817AckWait = 100 * time.Millisecond
+
+// ft817Reply is an answer expected from the rig.
+type ft817Reply struct {
+	op byte      // 応答するコマンド
+	n  int       // 応答のバイト数
+	at time.Time // コマンドを送った時刻
+}
+
+// ft817ReplyLen returns the length of the answer to an opcode.
+func ft817ReplyLen(op byte) int {
+	switch op {
+	case ft817ReadFreq:
+		return 5
+	case ft817ReadEEPROM:
+		return 2
+	default:
+		return 1 // 読み取り（E7 / F7）と設定コマンドの応答
+	}
+}
+
+// ft817IsSet reports whether op is a set command, whose one-byte answer
+// (00 / F0) may never come.
+func ft817IsSet(op byte) bool {
+	switch op {
+	case ft817ReadFreq, ft817ReadEEPROM, ft817ReadRX, ft817ReadTX:
+		return false
+	}
+	return true
+}
+
+// ft817Modes are the mode bytes of the binary CAT. Bit 7 of an answer is
+// the narrow filter and is ignored. DIG and PKT are reported as USB and FM
+// with DATA on.
+var ft817Modes = map[byte]struct {
+	mode RigMode
+	data bool
+}{
+	0x00: {ModeLSB, false},
+	0x01: {ModeUSB, false},
+	0x02: {ModeCW, false},
+	0x03: {ModeCWR, false},
+	0x04: {ModeAM, false},
+	0x06: {ModeWFM, false},
+	0x08: {ModeFM, false},
+	0x0A: {ModeUSB, true}, // DIG
+	0x0C: {ModeFM, true},  // PKT
+}
+
+// ft817ModeByte returns the mode byte of a mode (the reverse of ft817Modes).
+func ft817ModeByte(mode RigMode, data bool) (byte, bool) {
+	for b, m := range ft817Modes {
+		if m.mode == mode && m.data == data {
+			return b, true
+		}
+	}
+	return 0, false
+}
+
+// ft817Freq reads 4 bytes of big-endian BCD in 10 Hz units.
+func ft817Freq(b []byte) (int64, bool) {
+	var v int64
+	for _, c := range b {
+		hi, lo := int64(c>>4), int64(c&0x0F)
+		if hi > 9 || lo > 9 {
+			return 0, false
+		}
+		v = v*100 + hi*10 + lo
+	}
+	return v * 10, true
+}
+
+// ft817FreqBCD encodes freq as ft817Freq reads it.
+func ft817FreqBCD(freq int64) ([]byte, error) {
+	v := freq / 10
+	if v >= 100_000_000 {
+		return nil, errors.New("frequency out of range for this rig")
+	}
+	b := make([]byte, 4)
+	for i := 3; i >= 0; i-- {
+		lo := v % 10
+		v /= 10
+		hi := v % 10
+		v /= 10
+		b[i] = byte(hi<<4 | lo)
+	}
+	return b, nil
+}
+
+// ft817Command builds a command: four parameter bytes and the opcode.
+func ft817Command(op byte, params ...byte) []byte {
+	b := make([]byte, 5)
+	copy(b, params)
+	b[4] = op
+	return b
+}
+
+// yaesuLegacyDriver is the 5-byte binary CAT of the Yaesu FT-817 / FT-857
+// / FT-897, which do not answer ASCII commands. The rig never reports
+// changes, so it is polled. Its answers do not name the command, so the
+// commands written to the port (including those of other applications
+// through the PTY router) are tracked to know which answer comes next.
+type yaesuLegacyDriver struct{}
+
+func (yaesuLegacyDriver) Name() string    { return RigProfileYaesuLegacy }
+func (yaesuLegacyDriver) Proto() RigProto { return ProtoCAT }
+
+// Start is nil: there is no Auto Information, so polling starts.
+func (yaesuLegacyDriver) Start(int) [][]byte { return nil }
+
+// Poll reads the frequency and mode, the RX status and the TX status.
+// The RX status is read for the rig's squelch / S-meter, which are not
+// reported yet.
+func (yaesuLegacyDriver) Poll(int) []byte {
+	var b []byte
+	b = append(b, ft817Command(ft817ReadFreq)...)
+	b = append(b, ft817Command(ft817ReadRX)...)
+	return append(b, ft817Command(ft817ReadTX)...)
+}
+
+// PollPTT is nil: the TX status is part of Poll.
+func (yaesuLegacyDriver) PollPTT(int) []byte { return nil }
+
+// Sent queues the answers to the commands in b.
+func (yaesuLegacyDriver) Sent(index int, b []byte) {
+	l := rigLinkFor(index)
+	rigLinksMu.Lock()
+	defer rigLinksMu.Unlock()
+
+	now := time.Now()
+	// 途中で止まったコマンドと、来なかった応答を捨てる
+	if now.Sub(l.binOutAt) > ft817ReplyTimeout {
+		l.binOut = nil
+	}
+	for len(l.binWait) > 0 {
+		age := now.Sub(l.binWait[0].at)
+		if age <= ft817ReplyTimeout && (!ft817IsSet(l.binWait[0].op) || age <= ft817AckWait) {
+			break
+		}
+		l.binWait = l.binWait[1:]
+	}
+	l.binOutAt = now
+
+	l.binOut = append(l.binOut, b...)
+	for len(l.binOut) >= 5 {
+		op := l.binOut[4]
+		l.binWait = append(l.binWait, ft817Reply{op: op, n: ft817ReplyLen(op), at: now})
+		l.binOut = l.binOut[5:]
+	}
+}
+
+// Frames cuts the expected answers off buf. A frame is the opcode it
+// answers followed by the answer. Bytes nobody asked for are dropped.
+func (yaesuLegacyDriver) Frames(index int, buf []byte) (frames [][]byte, rest []byte) {
+	l := rigLinkFor(index)
+	rigLinksMu.Lock()
+	defer rigLinksMu.Unlock()
+
+	for len(buf) > 0 {
+		if len(l.binWait) == 0 {
+			return frames, nil
+		}
+		w := l.binWait[0]
+		if ft817IsSet(w.op) && buf[0] != 0x00 && buf[0] != 0xF0 {
+			// 設定コマンドへの応答がなく、次のコマンドの応答が来た
+			l.binWait = l.binWait[1:]
+			continue
+		}
+		if len(buf) >= w.n {
+			frames = append(frames, append([]byte{w.op}, buf[:w.n]...))
+			buf = buf[w.n:]
+			l.binWait = l.binWait[1:]
+			continue
+		}
+		if time.Since(w.at) > ft817ReplyTimeout {
+			// 途中で切れた応答
+			l.binWait = l.binWait[1:]
+			return frames, nil
+		}
+		break
+	}
+	return frames, buf
+}
+
+func (yaesuLegacyDriver) Parse(index int, f []byte) {
+	if len(f) < 2 {
+		return
+	}
+	op, data := f[0], f[1:]
+	switch op {
+	case ft817ReadFreq:
+		if len(data) < 5 {
+			return
+		}
+		freq, ok := ft817Freq(data[:4])
+		if !ok {
+			return
+		}
+		var mode RigMode
+		var isData bool
+		if m, ok := ft817Modes[data[4]&0x7F]; ok {
+			mode, isData = m.mode, m.data
+		}
+		updateRigStateForPort(index, freq, string(mode), isData, ProtoCAT)
+	case ft817ReadTX:
+		updateRigPTTForPort(index, data[0]&0x80 == 0)
+	case ft817ReadRX, ft817ReadEEPROM:
+		// 現状は使わない
+	default:
+		noteRigAck(index, true) // 設定コマンドの応答
+	}
+}
+
+func (yaesuLegacyDriver) Command(_ int, cmd RigControlCommand, _ RigState) ([][]byte, []byte, error) {
+	read := ft817Command(ft817ReadFreq)
+	switch cmd.Type {
+	case "setFreq":
+		if cmd.Freq <= 0 {
+			return nil, nil, errors.New("freq is required")
+		}
+		bcd, err := ft817FreqBCD(cmd.Freq)
+		if err != nil {
+			return nil, nil, err
+		}
+		return [][]byte{ft817Command(ft817SetFreq, bcd...)}, read, nil
+
+	case "setMode":
+		m, ok := ft817ModeByte(normalizeRigMode(cmd.Mode), cmd.Data)
+		if !ok {
+			return nil, nil, fmt.Errorf("unsupported mode: %q", cmd.Mode)
+		}
+		return [][]byte{ft817Command(ft817SetMode, m)}, read, nil
+
+	case "setSplit":
+		if cmd.Split {
+			return [][]byte{ft817Command(ft817SplitOn)}, nil, nil
+		}
+		return [][]byte{ft817Command(ft817SplitOff)}, nil, nil
+
+	case "setPTT":
+		op := byte(ft817PTTOff)
+		if cmd.PTT {
+			op = ft817PTTOn
+		}
+		return [][]byte{ft817Command(op)}, ft817Command(ft817ReadTX), nil
+
+	case "setVFO":
+		// A/B の切り替え（81）しかなく、現在の VFO を読めない
+		return nil, nil, errors.New("setVFO is not supported by this rig")
+	}
+	return nil, nil, fmt.Errorf("unknown command: %s", cmd.Type)
+}
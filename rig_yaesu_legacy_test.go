@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestFT817Freq(t *testing.T) {
+	tests := []struct {
+		b    []byte
+		want int64
+		ok   bool
+	}{
+		{[]byte{0x01, 0x40, 0x74, 0x00}, 14_074_000, true},
+		{[]byte{0x00, 0x70, 0x74, 0x00}, 7_074_000, true},
+		{[]byte{0x43, 0x01, 0x23, 0x45}, 430_123_450, true},
+		{[]byte{0x00, 0x00, 0x00, 0x00}, 0, true},
+		{[]byte{0x01, 0x4A, 0x74, 0x00}, 0, false},
+		{[]byte{0xF1, 0x40, 0x74, 0x00}, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := ft817Freq(tt.b)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("ft817Freq(% X) = %d, %v; want %d, %v", tt.b, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestFT817FreqBCD(t *testing.T) {
+	for _, freq := range []int64{1_840_000, 7_074_000, 14_074_000, 50_313_000, 145_000_000, 439_990_000} {
+		b, err := ft817FreqBCD(freq)
+		if err != nil {
+			t.Fatalf("ft817FreqBCD(%d): %v", freq, err)
+		}
+		if got, ok := ft817Freq(b); !ok || got != freq {
+			t.Errorf("ft817Freq(ft817FreqBCD(%d)) = %d, %v", freq, got, ok)
+		}
+	}
+	if b, _ := ft817FreqBCD(14_074_000); !bytes.Equal(b, []byte{0x01, 0x40, 0x74, 0x00}) {
+		t.Errorf("ft817FreqBCD(14074000) = % X", b)
+	}
+	// 10 Hz 未満は切り捨て
+	if b, _ := ft817FreqBCD(7_074_005); !bytes.Equal(b, []byte{0x00, 0x70, 0x74, 0x00}) {
+		t.Errorf("ft817FreqBCD(7074005) = % X", b)
+	}
+	if _, err := ft817FreqBCD(1_000_000_000); err == nil {
+		t.Error("ft817FreqBCD(1 GHz) succeeded")
+	}
+}
+
+func TestFT817Modes(t *testing.T) {
+	for b, m := range ft817Modes {
+		got, ok := ft817ModeByte(m.mode, m.data)
+		if !ok || got != b {
+			t.Errorf("ft817ModeByte(%s, %v) = %02X, %v; want %02X", m.mode, m.data, got, ok, b)
+		}
+	}
+	for _, m := range []struct {
+		mode RigMode
+		data bool
+	}{{ModeRTTY, false}, {ModeLSB, true}, {ModeCW, true}} {
+		if b, ok := ft817ModeByte(m.mode, m.data); ok {
+			t.Errorf("ft817ModeByte(%s, %v) = %02X, want not supported", m.mode, m.data, b)
+		}
+	}
+}
+
+// newYaesuLegacyTestPort returns a port index with a fresh rigLink.
+func newYaesuLegacyTestPort(t *testing.T) int {
+	t.Helper()
+	const index = 90
+	rigLinksMu.Lock()
+	delete(rigLinks, index)
+	rigLinksMu.Unlock()
+	t.Cleanup(func() {
+		rigLinksMu.Lock()
+		delete(rigLinks, index)
+		rigLinksMu.Unlock()
+	})
+	return index
+}
+
+// ageYaesuLegacyWait makes the answers waited for on a port d older.
+func ageYaesuLegacyWait(index int, d time.Duration) {
+	l := rigLinkFor(index)
+	rigLinksMu.Lock()
+	defer rigLinksMu.Unlock()
+	for i := range l.binWait {
+		l.binWait[i].at = l.binWait[i].at.Add(-d)
+	}
+	l.binOutAt = l.binOutAt.Add(-d)
+}
+
+func TestYaesuLegacyFrames(t *testing.T) {
+	var d yaesuLegacyDriver
+	setFreq := ft817Command(ft817SetFreq, 0x01, 0x40, 0x74, 0x00)
+	// 14.074 MHz USB、RX 状態、受信中
+	pollReply14 := []byte{0x01, 0x40, 0x74, 0x00, 0x01, 0x3F, 0xFF}
+	want14 := [][]byte{
+		{ft817ReadFreq, 0x01, 0x40, 0x74, 0x00, 0x01},
+		{ft817ReadRX, 0x3F},
+		{ft817ReadTX, 0xFF},
+	}
+	// 7.074 MHz DIG（先頭が 00 で設定コマンドの応答と区別できない）
+	pollReply7 := []byte{0x00, 0x70, 0x74, 0x00, 0x0A, 0x3F, 0x7F}
+	want7 := [][]byte{
+		{ft817ReadFreq, 0x00, 0x70, 0x74, 0x00, 0x0A},
+		{ft817ReadRX, 0x3F},
+		{ft817ReadTX, 0x7F},
+	}
+
+	tests := []struct {
+		name   string
+		writes [][]byte      // ポートへの書き込み（PTY のアプリケーションを含む）
+		age    time.Duration // 最後の書き込みの前に経過する時間
+		last   []byte
+		reply  [][]byte // リグの応答（分割受信）
+		want   [][]byte
+	}{
+		{
+			name:  "poll answered in pieces",
+			last:  d.Poll(0),
+			reply: [][]byte{pollReply14[:2], pollReply14[2:6], pollReply14[6:]},
+			want:  want14,
+		},
+		{
+			name:   "set command split across PTY writes, then poll",
+			writes: [][]byte{setFreq[:2], setFreq[2:]},
+			last:   d.Poll(0),
+			reply:  [][]byte{append([]byte{0x00}, pollReply14...)},
+			want:   append([][]byte{{ft817SetFreq, 0x00}}, want14...),
+		},
+		{
+			name:   "PTY application reads the frequency between polls",
+			writes: [][]byte{d.Poll(0)},
+			last:   ft817Command(ft817ReadFreq),
+			reply:  [][]byte{pollReply14, pollReply7[:5]},
+			want:   append(append([][]byte{}, want14...), want7[0]),
+		},
+		{
+			name:   "unanswered set command, poll right after it",
+			writes: [][]byte{setFreq},
+			last:   d.Poll(0),
+			reply:  [][]byte{pollReply14},
+			want:   want14,
+		},
+		{
+			name:   "unanswered set command, next poll",
+			writes: [][]byte{setFreq},
+			age:    ft817AckWait + 50*time.Millisecond,
+			last:   d.Poll(0),
+			reply:  [][]byte{pollReply7},
+			want:   want7,
+		},
+		{
+			name:   "answered set command before next poll",
+			writes: [][]byte{setFreq},
+			last:   d.Poll(0),
+			reply:  [][]byte{{0x00}, pollReply7},
+			want:   append([][]byte{{ft817SetFreq, 0x00}}, want7...),
+		},
+		{
+			name:  "bytes nobody asked for are dropped",
+			reply: [][]byte{{0x12, 0x34}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			index := newYaesuLegacyTestPort(t)
+			for _, b := range tt.writes {
+				d.Sent(index, b)
+			}
+			ageYaesuLegacyWait(index, tt.age)
+			if tt.last != nil {
+				d.Sent(index, tt.last)
+			}
+
+			var got [][]byte
+			var rest []byte
+			for _, r := range tt.reply {
+				var frames [][]byte
+				frames, rest = d.Frames(index, append(rest, r...))
+				got = append(got, frames...)
+			}
+			if len(rest) != 0 {
+				t.Errorf("rest = % X", rest)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("frames = % X, want % X", got, tt.want)
+			}
+			for i := range got {
+				if !bytes.Equal(got[i], tt.want[i]) {
+					t.Errorf("frame %d = % X, want % X", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestYaesuLegacyFramesTimeout(t *testing.T) {
+	var d yaesuLegacyDriver
+	index := newYaesuLegacyTestPort(t)
+
+	// 途中で切れた応答は ft817ReplyTimeout 後に捨て、次の応答と混ぜない
+	d.Sent(index, ft817Command(ft817ReadFreq))
+	frames, rest := d.Frames(index, []byte{0x01, 0x40})
+	if len(frames) != 0 || len(rest) != 2 {
+		t.Fatalf("Frames = % X, % X", frames, rest)
+	}
+	ageYaesuLegacyWait(index, ft817ReplyTimeout+50*time.Millisecond)
+	if frames, rest = d.Frames(index, rest); len(frames) != 0 || len(rest) != 0 {
+		t.Fatalf("Frames after timeout = % X, % X", frames, rest)
+	}
+
+	d.Sent(index, ft817Command(ft817ReadTX))
+	frames, _ = d.Frames(index, []byte{0x7F})
+	if len(frames) != 1 || !bytes.Equal(frames[0], []byte{ft817ReadTX, 0x7F}) {
+		t.Errorf("Frames = % X", frames)
+	}
+}
+
+func TestYaesuLegacyCommand(t *testing.T) {
+	var d yaesuLegacyDriver
+	tests := []struct {
+		typ     string
+		cmd     RigControlCommand
+		frame   []byte
+		refresh []byte
+	}{
+		{"setFreq", RigControlCommand{Freq: 7_074_000}, []byte{0x00, 0x70, 0x74, 0x00, ft817SetFreq}, ft817Command(ft817ReadFreq)},
+		{"setMode", RigControlCommand{Mode: ModeUSB, Data: true}, []byte{0x0A, 0, 0, 0, ft817SetMode}, ft817Command(ft817ReadFreq)},
+		{"setSplit", RigControlCommand{Split: true}, ft817Command(ft817SplitOn), nil},
+		{"setPTT", RigControlCommand{PTT: true}, ft817Command(ft817PTTOn), ft817Command(ft817ReadTX)},
+		{"setPTT", RigControlCommand{}, ft817Command(ft817PTTOff), ft817Command(ft817ReadTX)},
+	}
+	for _, tt := range tests {
+		cmd := tt.cmd
+		cmd.Type = tt.typ
+		frames, refresh, err := d.Command(0, cmd, RigState{})
+		if err != nil {
+			t.Errorf("%s: %v", cmd.Type, err)
+			continue
+		}
+		if len(frames) != 1 || !bytes.Equal(frames[0], tt.frame) || !bytes.Equal(refresh, tt.refresh) {
+			t.Errorf("%s = % X, % X; want % X, % X", cmd.Type, frames, refresh, tt.frame, tt.refresh)
+		}
+	}
+
+	for _, typ := range []string{"setVFO", "setFreq", "setMode"} {
+		var cmd RigControlCommand
+		cmd.Type = typ
+		cmd.Mode = ModeRTTY
+		if _, _, err := d.Command(0, cmd, RigState{}); err == nil {
+			t.Errorf("%s %+v succeeded", typ, cmd)
+		}
+	}
+}
@@ -0,0 +1,538 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// rigctldDefaultPort is the TCP port of the first rig port's rigctld
+// server; rig port i listens on the configured port + i. 4532 is the port
+// of Hamlib's rigctld.
+const rigctldDefaultPort = 4532
+
+// Hamlib error codes sent as "RPRT n".
+const (
+	rigOK       = 0
+	rigEINVAL   = -1
+	rigENIMPL   = -4
+	rigETIMEOUT = -5
+	rigEIO      = -6
+	rigERJCTED  = -9
+	rigENAVAIL  = -11
+)
+
+// rigctldServer is a Hamlib rigctld compatible TCP server for one rig port.
+// Apps configured for "Hamlib NET rigctl" share the radio through it while
+// the bridge stays the only owner of the serial port.
+type rigctldServer struct {
+	index int
+	ln    net.Listener
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+var rigctldServers []*rigctldServer
+var rigctldErrors = map[int]string{}
+var rigctldMu sync.Mutex
+
+// startRigctld opens a rigctld server for every configured rig port.
+func startRigctld() {
+	configLock.RLock()
+	enabled := config.UseRig && config.Rigctld
+	base := config.RigctldPort
+	rigPorts := make([]RigPortConfig, len(config.RigPorts))
+	copy(rigPorts, config.RigPorts)
+	configLock.RUnlock()
+
+	rigctldMu.Lock()
+	defer rigctldMu.Unlock()
+
+	rigctldErrors = map[int]string{}
+	if !enabled {
+		return
+	}
+
+	for i, rp := range rigPorts {
+		if rp.Port == "" {
+			continue
+		}
+		// 他のマシンから無線機を操作されないよう 127.0.0.1 のみで待ち受ける
+		addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(base+i))
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			log.Printf("[RIGCTLD-%d] listen error: %s: %v", i, addr, err)
+			rigctldErrors[i] = err.Error()
+			continue
+		}
+
+		srv := &rigctldServer{index: i, ln: ln, conns: map[net.Conn]struct{}{}}
+		rigctldServers = append(rigctldServers, srv)
+		go srv.serve()
+		log.Printf("[RIGCTLD-%d] listening on %s (%s)", i, addr, rp.Port)
+	}
+}
+
+// stopRigctld closes the servers and their connections.
+func stopRigctld() {
+	rigctldMu.Lock()
+	defer rigctldMu.Unlock()
+
+	for _, srv := range rigctldServers {
+		srv.ln.Close()
+		srv.mu.Lock()
+		for c := range srv.conns {
+			c.Close()
+		}
+		srv.mu.Unlock()
+	}
+	rigctldServers = nil
+}
+
+// restartRigctld reopens the servers with the current configuration.
+func restartRigctld() {
+	log.Println("[RIGCTLD] restarting...")
+	stopRigctld()
+	startRigctld()
+}
+
+// getRigctldStatus returns the listen address and error for each rig
+// port, indexed like Config.RigPorts.
+func getRigctldStatus(n int) (addrs []string, errs []string) {
+	rigctldMu.Lock()
+	defer rigctldMu.Unlock()
+
+	addrs = make([]string, n)
+	errs = make([]string, n)
+	for _, srv := range rigctldServers {
+		if srv.index < n {
+			addrs[srv.index] = srv.ln.Addr().String()
+		}
+	}
+	for i, e := range rigctldErrors {
+		if i < n {
+			errs[i] = e
+		}
+	}
+	return addrs, errs
+}
+
+func (srv *rigctldServer) serve() {
+	for {
+		c, err := srv.ln.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("[RIGCTLD-%d] accept error: %v", srv.index, err)
+			}
+			return
+		}
+		srv.mu.Lock()
+		srv.conns[c] = struct{}{}
+		srv.mu.Unlock()
+
+		go func() {
+			log.Printf("[RIGCTLD-%d] client connected: %s", srv.index, c.RemoteAddr())
+			srv.handle(c)
+			srv.mu.Lock()
+			delete(srv.conns, c)
+			srv.mu.Unlock()
+			c.Close()
+			log.Printf("[RIGCTLD-%d] client disconnected: %s", srv.index, c.RemoteAddr())
+		}()
+	}
+}
+
+// rigctldCommands maps the one-letter commands to their long names.
+var rigctldCommands = map[string]string{
+	"f": "get_freq",
+	"F": "set_freq",
+	"m": "get_mode",
+	"M": "set_mode",
+	"v": "get_vfo",
+	"V": "set_vfo",
+	"t": "get_ptt",
+	"T": "set_ptt",
+	"s": "get_split_vfo",
+	"S": "set_split_vfo",
+	"_": "get_info",
+	"q": "quit",
+	"Q": "quit",
+}
+
+// handle reads commands, one per line, until the client quits.
+func (srv *rigctldServer) handle(c net.Conn) {
+	r := bufio.NewScanner(c)
+	w := bufio.NewWriter(c)
+	for r.Scan() {
+		line := strings.TrimSpace(r.Text())
+		if line == "" {
+			continue
+		}
+
+		// 拡張応答モード: "+f" や ";\get_freq"（区切り文字は + なら改行）
+		var sep byte
+		if strings.IndexByte("+;|,", line[0]) >= 0 {
+			sep = line[0]
+			if sep == '+' {
+				sep = '\n'
+			}
+			line = strings.TrimSpace(line[1:])
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		name := fields[0]
+		if long, ok := strings.CutPrefix(name, "\\"); ok {
+			name = long
+		} else if long, ok := rigctldCommands[name]; ok {
+			name = long
+		}
+		if name == "quit" {
+			return
+		}
+
+		values, code := srv.exec(name, fields[1:])
+		writeRigctldReply(w, name, fields[1:], values, code, sep)
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// rigctldValue is one line of a get_* answer: the value and its label in
+// extended response mode.
+type rigctldValue struct {
+	Label string
+	Value string
+}
+
+// writeRigctldReply writes the answer in the normal or extended response
+// format. In normal mode a get_* command answers its values only and a
+// set_* command "RPRT n"; errors are always "RPRT n".
+func writeRigctldReply(w *bufio.Writer, name string, args []string, values []rigctldValue, code int, sep byte) {
+	if sep == 0 {
+		if code != rigOK || values == nil {
+			fmt.Fprintf(w, "RPRT %d\n", code)
+			return
+		}
+		for _, v := range values {
+			fmt.Fprintf(w, "%s\n", v.Value)
+		}
+		return
+	}
+
+	head := name + ":"
+	if len(args) > 0 {
+		head += " " + strings.Join(args, " ")
+	}
+	w.WriteString(head)
+	w.WriteByte(sep)
+	if code == rigOK {
+		for _, v := range values {
+			if v.Label == "" {
+				w.WriteString(v.Value) // dump_state はそのまま
+			} else {
+				fmt.Fprintf(w, "%s: %s", v.Label, v.Value)
+			}
+			w.WriteByte(sep)
+		}
+	}
+	fmt.Fprintf(w, "RPRT %d\n", code)
+}
+
+// exec runs one command. A nil values slice with rigOK means a set command
+// succeeded.
+func (srv *rigctldServer) exec(name string, args []string) ([]rigctldValue, int) {
+	switch name {
+	case "dump_state":
+		return []rigctldValue{{Value: rigctldDumpState()}}, rigOK
+	case "chk_vfo":
+		return []rigctldValue{{Label: "ChkVFO", Value: "0"}}, rigOK
+	case "get_powerstat":
+		return []rigctldValue{{Label: "Power Status", Value: "1"}}, rigOK
+	case "get_info":
+		return []rigctldValue{{Label: "Info", Value: "HAMLAB Bridge"}}, rigOK
+	}
+
+	if strings.HasPrefix(name, "get_") {
+		return srv.get(name)
+	}
+
+	var cmd RigControlCommand
+	cmd.Port = srv.index
+	switch name {
+	case "set_freq":
+		if len(args) < 1 {
+			return nil, rigEINVAL
+		}
+		f, err := strconv.ParseFloat(args[0], 64)
+		if err != nil || f <= 0 {
+			return nil, rigEINVAL
+		}
+		cmd.Type, cmd.Freq = "setFreq", int64(f)
+
+	case "set_mode":
+		if len(args) < 1 {
+			return nil, rigEINVAL
+		}
+		mode, data, ok := rigModeFromHamlib(args[0])
+		if !ok {
+			return nil, rigEINVAL
+		}
+		cmd.Type, cmd.Mode, cmd.Data = "setMode", mode, data
+
+	case "set_vfo":
+		if len(args) < 1 {
+			return nil, rigEINVAL
+		}
+		if args[0] == "currVFO" {
+			return nil, rigOK
+		}
+		vfo, ok := rigVFOFromHamlib(args[0])
+		if !ok {
+			return nil, rigEINVAL
+		}
+		cmd.Type, cmd.VFO = "setVFO", vfo
+
+	case "set_split_vfo":
+		if len(args) < 1 {
+			return nil, rigEINVAL
+		}
+		cmd.Type, cmd.Split = "setSplit", args[0] == "1"
+
+	case "set_ptt":
+		if len(args) < 1 {
+			return nil, rigEINVAL
+		}
+		// 1 = ON, 2 = ON (MIC), 3 = ON (DATA) はすべて送信
+		cmd.Type, cmd.PTT = "setPTT", args[0] != "0"
+
+	default:
+		return nil, rigENIMPL
+	}
+
+	if err := controlRig(cmd); err != nil {
+		log.Printf("[RIGCTLD-%d] %s %v failed: %v", srv.index, name, args, err)
+		return nil, rigctldErrorCode(err)
+	}
+	return nil, rigOK
+}
+
+// get answers a get_* command from the state the rig watcher keeps.
+func (srv *rigctldServer) get(name string) ([]rigctldValue, int) {
+	rigStatesMu.RLock()
+	st := rigStates[srv.index]
+	var state RigState
+	if st != nil {
+		state = *st
+	}
+	rigStatesMu.RUnlock()
+	if st == nil {
+		return nil, rigEIO
+	}
+
+	l := rigLinkFor(srv.index)
+	rigLinksMu.Lock()
+	vfo, split := l.vfo, l.split
+	rigLinksMu.Unlock()
+
+	switch name {
+	case "get_freq":
+		return []rigctldValue{{"Frequency", strconv.FormatInt(state.Freq, 10)}}, rigOK
+	case "get_mode":
+		mode := hamlibMode(state.Mode, state.Data)
+		return []rigctldValue{
+			{"Mode", mode},
+			{"Passband", strconv.Itoa(hamlibPassband(mode))},
+		}, rigOK
+	case "get_vfo":
+		return []rigctldValue{{"VFO", hamlibVFO(vfo)}}, rigOK
+	case "get_ptt":
+		ptt := "0"
+		if state.PTT {
+			ptt = "1"
+		}
+		return []rigctldValue{{"PTT", ptt}}, rigOK
+	case "get_split_vfo":
+		s, tx := "0", hamlibVFO(vfo)
+		if split {
+			s, tx = "1", "VFOB"
+		}
+		return []rigctldValue{{"Split", s}, {"TX VFO", tx}}, rigOK
+	}
+	return nil, rigENIMPL
+}
+
+// rigctldErrorCode maps a control error to a Hamlib error code.
+func rigctldErrorCode(err error) int {
+	switch {
+	case errors.Is(err, errRigNotOpen):
+		return rigEIO
+	case errors.Is(err, errRigNotReady):
+		return rigENAVAIL
+	case errors.Is(err, errRigRejected):
+		return rigERJCTED
+	case errors.Is(err, errRigTimeout):
+		return rigETIMEOUT
+	}
+	return rigEINVAL
+}
+
+// hamlibMode returns the Hamlib name of a rig mode.
+func hamlibMode(m RigMode, data bool) string {
+	switch normalizeRigMode(m) {
+	case ModeUSB:
+		if data {
+			return "PKTUSB"
+		}
+		return "USB"
+	case ModeLSB:
+		if data {
+			return "PKTLSB"
+		}
+		return "LSB"
+	case ModeFM:
+		if data {
+			return "PKTFM"
+		}
+		return "FM"
+	case ModeCWR:
+		return "CWR"
+	case ModeRTTYR:
+		return "RTTYR"
+	case ModeDV:
+		return "D-STAR"
+	case "FM-N":
+		return "FMN"
+	case "AM-N":
+		return "AMN"
+	case "":
+		return "USB"
+	}
+	return string(normalizeRigMode(m))
+}
+
+// rigModeFromHamlib returns the rig mode of a Hamlib mode name.
+func rigModeFromHamlib(s string) (RigMode, bool, bool) {
+	switch strings.ToUpper(s) {
+	case "USB":
+		return ModeUSB, false, true
+	case "LSB":
+		return ModeLSB, false, true
+	case "CW":
+		return ModeCW, false, true
+	case "CWR":
+		return ModeCWR, false, true
+	case "AM":
+		return ModeAM, false, true
+	case "AMN":
+		return "AM-N", false, true
+	case "FM":
+		return ModeFM, false, true
+	case "FMN":
+		return "FM-N", false, true
+	case "WFM":
+		return ModeWFM, false, true
+	case "RTTY":
+		return ModeRTTY, false, true
+	case "RTTYR":
+		return ModeRTTYR, false, true
+	case "PKTUSB":
+		return ModeUSB, true, true
+	case "PKTLSB":
+		return ModeLSB, true, true
+	case "PKTFM":
+		return ModeFM, true, true
+	case "D-STAR":
+		return ModeDV, false, true
+	case "C4FM":
+		return "C4FM", false, true
+	}
+	return "", false, false
+}
+
+// hamlibPassband returns the usual filter width of a mode; the bridge does
+// not read the actual width from the rig.
+func hamlibPassband(mode string) int {
+	switch mode {
+	case "CW", "CWR", "RTTY", "RTTYR":
+		return 500
+	case "AM":
+		return 6000
+	case "FM", "PKTFM", "D-STAR", "C4FM":
+		return 12000
+	case "FMN", "AMN":
+		return 3000
+	case "WFM":
+		return 230000
+	}
+	return 2400
+}
+
+func hamlibVFO(vfo string) string {
+	switch vfo {
+	case "B":
+		return "VFOB"
+	case "MAIN":
+		return "Main"
+	case "SUB":
+		return "Sub"
+	}
+	return "VFOA"
+}
+
+func rigVFOFromHamlib(s string) (string, bool) {
+	switch strings.ToUpper(s) {
+	case "VFOA":
+		return "A", true
+	case "VFOB":
+		return "B", true
+	case "MAIN":
+		return "MAIN", true
+	case "SUB":
+		return "SUB", true
+	}
+	return "", false
+}
+
+// rigctldDumpState returns the \dump_state answer (protocol version 1),
+// which Hamlib's NET rigctl backend reads when it opens the connection.
+// The bridge does not know the rig model, so the ranges cover all bands.
+func rigctldDumpState() string {
+	const (
+		modes = 0x1dff // AM CW USB LSB RTTY FM WFM CWR RTTYR PKTLSB PKTUSB PKTFM（AMS なし）
+		vfos  = 0x3    // VFOA / VFOB
+		rx    = "100000.000000 1300000000.000000"
+	)
+	var b strings.Builder
+	fmt.Fprintf(&b, "1\n")                                       // プロトコルバージョン
+	fmt.Fprintf(&b, "2\n")                                       // リグモデル（NET rigctl）
+	fmt.Fprintf(&b, "0\n")                                       // ITU リージョン
+	fmt.Fprintf(&b, "%s 0x%x -1 -1 0x%x 0x0\n", rx, modes, vfos) // 受信範囲
+	fmt.Fprintf(&b, "0 0 0 0 0 0 0\n")
+	fmt.Fprintf(&b, "%s 0x%x 1000 100000 0x%x 0x0\n", rx, modes, vfos) // 送信範囲
+	fmt.Fprintf(&b, "0 0 0 0 0 0 0\n")
+	fmt.Fprintf(&b, "0x%x 1\n", modes) // チューニングステップ
+	fmt.Fprintf(&b, "0 0\n")
+	fmt.Fprintf(&b, "0x%x 2400\n0x%x 500\n0x%x 12000\n", 0xc|0xc00, 0x2|0x80|0x10|0x100, 0x20|0x1000) // フィルター
+	fmt.Fprintf(&b, "0 0\n")
+	fmt.Fprintf(&b, "0\n0\n0\n0\n") // max_rit / max_xit / max_ifshift / announces
+	fmt.Fprintf(&b, "\n\n")         // プリアンプ / アッテネーター
+	fmt.Fprintf(&b, "0x0\n0x0\n0x0\n0x0\n0x0\n0x0\n")
+	fmt.Fprintf(&b, "vfo_ops=0x0\n")
+	fmt.Fprintf(&b, "ptt_type=0x1\n")
+	fmt.Fprintf(&b, "has_set_vfo=1\n")
+	fmt.Fprintf(&b, "has_get_vfo=1\n")
+	fmt.Fprintf(&b, "has_set_freq=1\n")
+	fmt.Fprintf(&b, "has_get_freq=1\n")
+	fmt.Fprintf(&b, "done")
+	return b.String()
+}
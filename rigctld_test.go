@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// testRigPort is a serial port that records the commands written to it
+// and answers each as accepted.
+type testRigPort struct {
+	serial.Port
+	index int
+
+	mu      sync.Mutex
+	written []string
+}
+
+func (p *testRigPort) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	p.written = append(p.written, string(b))
+	p.mu.Unlock()
+	noteRigAck(p.index, true)
+	return len(b), nil
+}
+
+// take returns the commands written since the last call.
+func (p *testRigPort) take() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	w := p.written
+	p.written = nil
+	return w
+}
+
+// newTestRigPort opens a fake Elecraft rig on port index with the given
+// state.
+func newTestRigPort(t *testing.T, index int, state RigState) *testRigPort {
+	t.Helper()
+	p := &testRigPort{index: index}
+	setRigDriver(index, elecraftDriver{}, false)
+	currentRigPortsMu.Lock()
+	currentRigPorts[index] = p
+	currentRigPortsMu.Unlock()
+	state.Index = index
+	rigStatesMu.Lock()
+	rigStates[index] = &state
+	rigStatesMu.Unlock()
+
+	t.Cleanup(func() {
+		currentRigPortsMu.Lock()
+		delete(currentRigPorts, index)
+		currentRigPortsMu.Unlock()
+		rigStatesMu.Lock()
+		delete(rigStates, index)
+		rigStatesMu.Unlock()
+		rigLinksMu.Lock()
+		delete(rigLinks, index)
+		rigLinksMu.Unlock()
+	})
+	return p
+}
+
+// dialRigctld connects a client to a rigctld server of port index over
+// net.Pipe.
+func dialRigctld(t *testing.T, index int) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	client, server := net.Pipe()
+	srv := &rigctldServer{index: index, conns: map[net.Conn]struct{}{}}
+	done := make(chan struct{})
+	go func() {
+		srv.handle(server)
+		server.Close()
+		close(done)
+	}()
+	t.Cleanup(func() {
+		client.Close()
+		<-done
+	})
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	return client, bufio.NewReader(client)
+}
+
+// rigctldExchange sends one command line and reads n lines of answer.
+func rigctldExchange(t *testing.T, c net.Conn, r *bufio.Reader, line string, n int) []string {
+	t.Helper()
+	if _, err := c.Write([]byte(line + "\n")); err != nil {
+		t.Fatalf("write %q: %v", line, err)
+	}
+	var got []string
+	for i := 0; i < n; i++ {
+		s, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("%q: read: %v (got %q)", line, err, got)
+		}
+		got = append(got, strings.TrimSuffix(s, "\n"))
+	}
+	return got
+}
+
+func TestRigctldProtocol(t *testing.T) {
+	const index = 91
+	p := newTestRigPort(t, index, RigState{Freq: 14_074_000, Mode: ModeUSB, Data: true, Proto: ProtoCAT})
+	c, r := dialRigctld(t, index)
+
+	tests := []struct {
+		line   string
+		want   []string
+		writes []string // リグに書き込まれるコマンド
+	}{
+		{line: "f", want: []string{"14074000"}},
+		{line: "m", want: []string{"PKTUSB", "2400"}},
+		{line: "t", want: []string{"0"}},
+		{line: "s", want: []string{"0", "VFOA"}},
+		{line: `\get_vfo`, want: []string{"VFOA"}},
+		{line: "F 7074000", want: []string{"RPRT 0"}, writes: []string{"FA00007074000;", "FA;"}},
+		{line: "F 7074000.000000", want: []string{"RPRT 0"}, writes: []string{"FA00007074000;", "FA;"}},
+		{line: "F abc", want: []string{"RPRT -1"}},
+		{line: "M CW 500", want: []string{"RPRT 0"}, writes: []string{"MD3;", "IF;"}},
+		{line: "M PKTUSB 0", want: []string{"RPRT 0"}, writes: []string{"MD6;", "DT0;", "IF;"}},
+		{line: "M XYZ 0", want: []string{"RPRT -1"}},
+		{line: "T 1", want: []string{"RPRT 0"}, writes: []string{"TX;", "IF;"}},
+		{line: "T 0", want: []string{"RPRT 0"}, writes: []string{"RX;", "IF;"}},
+		{line: "S 1 VFOB", want: []string{"RPRT 0"}, writes: []string{"FR0;", "FT1;"}},
+		{line: "s", want: []string{"1", "VFOB"}},
+		{line: "S 0 VFOA", want: []string{"RPRT 0"}, writes: []string{"FR0;", "FT0;"}},
+		{line: `\get_level STRENGTH`, want: []string{"RPRT -4"}},
+		{line: `\send_morse CQ`, want: []string{"RPRT -4"}},
+
+		// 拡張応答モード
+		{line: "+f", want: []string{"get_freq:", "Frequency: 14074000", "RPRT 0"}},
+		{line: `+\get_mode`, want: []string{"get_mode:", "Mode: PKTUSB", "Passband: 2400", "RPRT 0"}},
+		{line: "+t", want: []string{"get_ptt:", "PTT: 0", "RPRT 0"}},
+		{line: "+s", want: []string{"get_split_vfo:", "Split: 0", "TX VFO: VFOA", "RPRT 0"}},
+		{line: "+F 7074000", want: []string{"set_freq: 7074000", "RPRT 0"}, writes: []string{"FA00007074000;", "FA;"}},
+		{line: "+T 1", want: []string{"set_ptt: 1", "RPRT 0"}, writes: []string{"TX;", "IF;"}},
+		{line: "+F abc", want: []string{"set_freq: abc", "RPRT -1"}},
+		{line: `;\get_freq`, want: []string{"get_freq:;Frequency: 14074000;RPRT 0"}},
+		{line: "|m", want: []string{"get_mode:|Mode: PKTUSB|Passband: 2400|RPRT 0"}},
+	}
+	for _, tt := range tests {
+		got := rigctldExchange(t, c, r, tt.line, len(tt.want))
+		if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("%q = %q, want %q", tt.line, got, tt.want)
+		}
+		if w := p.take(); strings.Join(w, "") != strings.Join(tt.writes, "") {
+			t.Errorf("%q wrote %q, want %q", tt.line, w, tt.writes)
+		}
+	}
+}
+
+func TestRigctldDumpState(t *testing.T) {
+	const index = 91
+	newTestRigPort(t, index, RigState{Freq: 14_074_000, Mode: ModeUSB})
+	c, r := dialRigctld(t, index)
+
+	for _, line := range []string{`\dump_state`, `+\dump_state`} {
+		if _, err := c.Write([]byte(line + "\n")); err != nil {
+			t.Fatal(err)
+		}
+		end := "done"
+		if line[0] == '+' {
+			end = "RPRT 0"
+		}
+		var got []string
+		for {
+			s, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatalf("%s: read: %v (got %q)", line, err, got)
+			}
+			s = strings.TrimSuffix(s, "\n")
+			got = append(got, s)
+			if s == end {
+				break
+			}
+		}
+		if line[0] == '+' {
+			if got[0] != "dump_state:" || got[len(got)-2] != "done" {
+				t.Errorf("%s = %q", line, got)
+			}
+			got = got[1 : len(got)-1]
+		}
+		// プロトコルバージョン 1 と NET rigctl のモデル
+		if len(got) < 5 || got[0] != "1" || got[1] != "2" || got[len(got)-1] != "done" {
+			t.Fatalf("%s = %q", line, got)
+		}
+		if want := "100000.000000 1300000000.000000 0x1dff -1 -1 0x3 0x0"; got[3] != want {
+			t.Errorf("%s rx range = %q, want %q", line, got[3], want)
+		}
+	}
+
+	// dump_state の後も接続を使える
+	if got := rigctldExchange(t, c, r, "f", 1); got[0] != "14074000" {
+		t.Errorf("f = %q", got)
+	}
+}
+
+func TestRigctldNoState(t *testing.T) {
+	c, r := dialRigctld(t, 92)
+	for _, line := range []string{"f", "m", "t"} {
+		if got := rigctldExchange(t, c, r, line, 1); got[0] != "RPRT -6" {
+			t.Errorf("%q = %q, want RPRT -6", line, got)
+		}
+	}
+	// ポートが開いていない
+	if got := rigctldExchange(t, c, r, "F 7074000", 1); got[0] != "RPRT -6" {
+		t.Errorf("F = %q, want RPRT -6", got)
+	}
+}
+
+// A port the broadcast mode leaves out still keeps its state for rigctld.
+func TestRigctldStateOfPortNotBroadcast(t *testing.T) {
+	const index = 91
+	newTestRigPort(t, index, RigState{})
+
+	configLock.Lock()
+	mode, selected := config.RigBroadcastMode, config.SelectedRigIndex
+	config.RigBroadcastMode, config.SelectedRigIndex = "single", 0
+	configLock.Unlock()
+	t.Cleanup(func() {
+		configLock.Lock()
+		config.RigBroadcastMode, config.SelectedRigIndex = mode, selected
+		configLock.Unlock()
+	})
+
+	// IF: 21.074 MHz、受信、MD6（DATA）、DT0（DATA A）
+	d := elecraftDriver{}
+	d.Parse(index, []byte("IF00021074000"+strings.Repeat(" ", 15)+"0"+"6"+"0000"+"0"))
+	d.Parse(index, []byte("TQ1"))
+
+	c, r := dialRigctld(t, index)
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"f", []string{"21074000"}},
+		{"m", []string{"PKTUSB", "2400"}},
+		{"t", []string{"1"}},
+	}
+	for _, tt := range tests {
+		got := rigctldExchange(t, c, r, tt.line, len(tt.want))
+		if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("%q = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
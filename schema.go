@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// schemaMessage registers a WebSocket message for the JSON Schema.
+type schemaMessage struct {
+	Type      string // "type" の値
+	Direction string // event / reply / command
+	Value     interface{}
+}
+
+// schemaMessages lists every message exchanged over the WebSocket. A new
+// message type must be added here so that clients can see it in
+// /schema.json.
+var schemaMessages = []schemaMessage{
+	// サーバー → クライアント（全クライアントへ配信）
+	{"adif", "event", ADIFEvent{}},
+	{"rig", "event", RigEvent{}},
+	{"pty", "event", PTYEvent{}},
+	{"rigConnected", "event", RigConnectionEvent{}},
+	{"rigDisconnected", "event", RigConnectionEvent{}},
+	{"wsjtx_status", "event", WSJTXStatusEvent{}},
+	{"wsjtx_decode", "event", WSJTXDecodeEvent{}},
+	{"qso_logged", "event", QSOLoggedEvent{}},
+	{"logbookResult", "event", LogbookResultEvent{}},
+	{"qslConfirmed", "event", QSLConfirmedEvent{}},
+
+	// サーバー → クライアント（コマンドへの応答）
+	{"resumed", "reply", ResumedReply{}},
+	{"subscribed", "reply", SubscribedReply{}},
+	{"rigState", "reply", RigStateReply{}},
+	{"rigStates", "reply", RigStatesReply{}},
+	{"wsjtxAck", "reply", WSJTXAckReply{}},
+	{"rigAck", "reply", RigAckReply{}},
+	{"error", "reply", ErrorReply{}},
+
+	// クライアント → サーバー
+	{"resume", "command", ResumeCommand{}},
+	{"subscribe", "command", SubscribeCommand{}},
+	{"unsubscribe", "command", SubscribeCommand{}},
+	{"getRigState", "command", GetRigStateCommand{}},
+	{"setFreq", "command", RigControlCommand{}},
+	{"setMode", "command", RigControlCommand{}},
+	{"setVFO", "command", RigControlCommand{}},
+	{"setSplit", "command", RigControlCommand{}},
+	{"setPTT", "command", RigControlCommand{}},
+	{"wsjtxReply", "command", WSJTXCommand{}},
+	{"wsjtxHaltTx", "command", WSJTXCommand{}},
+	{"wsjtxFreeText", "command", WSJTXCommand{}},
+	{"wsjtxLocation", "command", WSJTXCommand{}},
+	{"wsjtxHighlightCallsign", "command", WSJTXCommand{}},
+}
+
+var (
+	schemaOnce sync.Once
+	schemaJSON []byte
+)
+
+// eventSchema returns the JSON Schema (draft 2020-12) of all messages,
+// generated from the Go structs. Each message is a definition named after
+// its type; "oneOf" lists them all.
+func eventSchema() []byte {
+	schemaOnce.Do(func() {
+		defs := map[string]interface{}{}
+		var refs []interface{}
+		for _, m := range schemaMessages {
+			s := schemaFor(reflect.TypeOf(m.Value))
+			props := s["properties"].(map[string]interface{})
+			props["type"] = map[string]interface{}{"const": m.Type}
+			if _, ok := props["v"]; ok && m.Direction != "command" {
+				props["v"] = map[string]interface{}{"const": EventVersion}
+			}
+			if m.Direction == "event" {
+				s["required"] = append(s["required"].([]string), "seq")
+			}
+			s["title"] = reflect.TypeOf(m.Value).Name()
+			s["x-direction"] = m.Direction
+
+			defs[m.Type] = s
+			refs = append(refs, map[string]interface{}{"$ref": "#/$defs/" + m.Type})
+		}
+
+		schemaJSON, _ = json.MarshalIndent(map[string]interface{}{
+			"$schema":     "https://json-schema.org/draft/2020-12/schema",
+			"title":       "HAMLAB Bridge WebSocket messages",
+			"description": "x-direction: event = broadcast (with seq), reply = response to a command, command = sent by the client",
+			"version":     EventVersion,
+			"oneOf":       refs,
+			"$defs":       defs,
+		}, "", "  ")
+	})
+	return schemaJSON
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaFor returns the schema of a Go type as encoding/json encodes it.
+func schemaFor(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaFor(t.Elem())}
+	case reflect.Struct:
+		props := map[string]interface{}{}
+		required := []string{}
+		schemaFields(t, props, &required)
+		s := map[string]interface{}{"type": "object", "properties": props}
+		if len(required) > 0 {
+			s["required"] = required
+		}
+		return s
+	}
+	return map[string]interface{}{}
+}
+
+// schemaFields adds the JSON fields of struct t, including those of
+// embedded structs, to props.
+func schemaFields(t reflect.Type, props map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				schemaFields(ft, props, required)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		props[name] = schemaFor(f.Type)
+		optional := strings.Contains(opts, "omitempty") || strings.Contains(opts, "omitzero")
+		if !optional && f.Type.Kind() != reflect.Pointer {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// handleSchema serves the message schema. It contains no user data, so
+// no pairing token is needed.
+func handleSchema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Origin")
+	if o := r.Header.Get("Origin"); o != "" && originAllowed(o) {
+		w.Header().Set("Access-Control-Allow-Origin", o)
+	}
+	w.Header().Set("Content-Type", "application/schema+json")
+	_, _ = w.Write(eventSchema())
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files in testdata")
+
+// TestSchemaGolden compares /schema.json with testdata/schema.json, so that
+// a change to a message struct shows up in review. Run
+// "go test -run TestSchemaGolden -update" after an intended change.
+func TestSchemaGolden(t *testing.T) {
+	const golden = "testdata/schema.json"
+
+	req := httptest.NewRequest(http.MethodGet, "/schema.json", nil)
+	rec := httptest.NewRecorder()
+	handleSchema(rec, req)
+	if ct := rec.Header().Get("Content-Type"); ct != "application/schema+json" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	got := append(rec.Body.Bytes(), '\n')
+
+	if *updateGolden {
+		if err := os.WriteFile(golden, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("/schema.json differs from %s; run \"go test -run TestSchemaGolden -update\" if the change is intended", golden)
+	}
+}
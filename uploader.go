@@ -0,0 +1,100 @@
+package main
+
+import "strings"
+
+// Uploader sends ADIF records to one kind of online logbook. The settings
+// form, config validation and the outbox are all driven by Fields and
+// Upload, so adding a service only means adding an Uploader to uploaders.
+type Uploader interface {
+	// Type is the key stored in LogbookConfig.Type and outbox entries.
+	Type() string
+	// Name is the name shown in the settings UI.
+	Name() string
+	// Fields lists the credentials and options the service needs.
+	Fields() []UploaderField
+	// Upload sends one ADIF record and classifies the reply.
+	Upload(adif string, c LogbookConfig) LogbookResult
+}
+
+// UploaderField is one input of an uploader in the settings form.
+// Option fields are stored in LogbookConfig.Options, the others in
+// LogbookConfig.Credentials.
+type UploaderField struct {
+	Key         string
+	Label       string
+	Secret      bool // password 入力
+	Option      bool
+	Required    bool
+	Placeholder string
+}
+
+// uploaders is the registry of supported logbook services in display order.
+var uploaders = []Uploader{
+	qrzUploader{},
+	hamqthUploader{},
+	eqslUploader{},
+	hrdlogUploader{},
+	clublogUploader{},
+	lotwUploader{},
+	cloudlogUploader{typ: LogbookCloudlog, name: "Cloudlog"},
+	cloudlogUploader{typ: LogbookWavelog, name: "Wavelog"},
+}
+
+// lookupUploader returns the uploader registered for typ.
+func lookupUploader(typ string) (Uploader, bool) {
+	for _, u := range uploaders {
+		if u.Type() == typ {
+			return u, true
+		}
+	}
+	return nil, false
+}
+
+// uploaderName returns the display name of typ, or typ itself if unknown.
+func uploaderName(typ string) string {
+	if u, ok := lookupUploader(typ); ok {
+		return u.Name()
+	}
+	return typ
+}
+
+// value returns the stored value of an uploader field.
+func (c LogbookConfig) value(f UploaderField) string {
+	if f.Option {
+		return c.Options[f.Key]
+	}
+	return c.Credentials[f.Key]
+}
+
+// setValue stores the value of an uploader field.
+func (c *LogbookConfig) setValue(f UploaderField, v string) {
+	if f.Option {
+		if c.Options == nil {
+			c.Options = map[string]string{}
+		}
+		c.Options[f.Key] = v
+		return
+	}
+	if c.Credentials == nil {
+		c.Credentials = map[string]string{}
+	}
+	c.Credentials[f.Key] = v
+}
+
+// ready reports whether the logbook is enabled and every required field of
+// its uploader is filled in.
+func (c LogbookConfig) ready() bool {
+	if !c.Enabled {
+		return false
+	}
+	u, ok := lookupUploader(c.Type)
+	if !ok {
+		return false
+	}
+	for _, f := range u.Fields() {
+		if f.Required && strings.TrimSpace(c.value(f)) == "" {
+			return false
+		}
+	}
+	return true
+}
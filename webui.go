@@ -3,13 +3,18 @@ package main
 import (
 	"html/template"
 	"log"
+	"net"
 	"net/http"
 	"runtime"
 	"strconv"
+	"strings"
+	"time"
 )
 
 var tmpl = template.Must(template.New("").Funcs(template.FuncMap{
-	"inc": func(i int) int { return i + 1 },
+	"inc":     func(i int) int { return i + 1 },
+	"csrf":    func() string { return csrfToken },
+	"civName": civRigName,
 	"safeIndex": func(slice []string, i int) string {
 		if i >= 0 && i < len(slice) {
 			return slice[i]
@@ -59,6 +64,7 @@ label {
 }
 input[type="text"],
 input[type="password"],
+textarea,
 select {
   width: 100%;
   padding: 10px 12px;
@@ -70,6 +76,7 @@ select {
 }
 input[type="text"]:focus,
 input[type="password"]:focus,
+textarea:focus,
 select:focus {
   outline: none;
   border-color: #007aff;
@@ -167,7 +174,8 @@ button:hover {
   margin-bottom: 8px;
   align-items: center;
 }
-.port-row .port-num {
+.port-row .port-num,
+.listener-row .port-num {
   min-width: 20px;
   font-size: 13px;
   color: #666;
@@ -178,6 +186,55 @@ button:hover {
 .port-row select.baud {
   flex: 1;
 }
+.port-row select.profile {
+  flex: 2;
+}
+.port-row input.civ {
+  flex: none;
+  width: 56px;
+  font-family: monospace;
+}
+.listener-row {
+  display: flex;
+  gap: 8px;
+  margin-bottom: 8px;
+  align-items: center;
+}
+.listener-row input[type="text"] {
+  flex: 2;
+}
+.listener-row input.name {
+  flex: 1;
+}
+.listener-row select {
+  flex: 1;
+}
+.enrich-row {
+  display: flex;
+  align-items: center;
+  gap: 8px;
+  margin-bottom: 6px;
+}
+.enrich-row span {
+  flex: 1;
+  font-size: 13px;
+  color: #333;
+}
+.enrich-row select {
+  flex: 1;
+  padding: 6px 8px;
+  font-size: 13px;
+}
+.forward-stats {
+  font-size: 11px;
+  color: #666;
+  margin: -4px 0 8px 28px;
+}
+.field-error {
+  font-size: 11px;
+  color: #c00;
+  margin: -4px 0 8px 28px;
+}
 .broadcast-mode {
   margin-top: 12px;
   padding: 12px;
@@ -213,6 +270,7 @@ button:hover {
   </div>
   {{end}}
   <form method="post">
+    <input type="hidden" name="csrf" value="{{csrf}}">
     <div class="form-group">
       <label for="user">QRZ.com ユーザー名</label>
       <input type="text" id="user" name="user" value="{{.Config.QRZUser}}" autocomplete="username">
@@ -230,6 +288,54 @@ button:hover {
         <input type="checkbox" name="use_geo" {{if .Config.UseGeo}}checked{{end}}>
         <span>JCC / 住所を自動補完</span>
       </label>
+      <div style="font-size:12px;color:#555;margin:12px 0 6px;">Logbook送信時のADIF補完</div>
+      {{range .EnrichRows}}
+      <div class="enrich-row">
+        <span>{{.Label}}</span>
+        <select name="enrich_{{.Key}}">
+          <option value="off"{{if eq .Value "off"}} selected{{end}}>補完しない</option>
+          <option value="fill"{{if eq .Value "fill"}} selected{{end}}>空欄のみ補完</option>
+          <option value="overwrite"{{if eq .Value "overwrite"}} selected{{end}}>常に上書き</option>
+        </select>
+      </div>
+      {{end}}
+    </div>
+    <div class="form-group">
+      <label>UDP 受信（WSJT-X / JTDX / ADIF）</label>
+      {{range $i, $l := .Config.Listeners}}
+      <div class="listener-row">
+        <span class="port-num">{{inc $i}}</span>
+        <input type="text" class="name" name="listener_name_{{$i}}" value="{{$l.Name}}" placeholder="名前">
+        <input type="text" name="listener_addr_{{$i}}" value="{{$l.Address}}" placeholder="127.0.0.1:2333 / 224.0.0.1:2237">
+        <select name="listener_iface_{{$i}}" title="マルチキャスト受信インターフェース">
+          <option value="">既定</option>
+          {{range $.Interfaces}}
+          <option value="{{.}}"{{if eq . $l.Interface}} selected{{end}}>{{.}}</option>
+          {{end}}
+        </select>
+      </div>
+      {{with safeIndex $.ListenerErrors $i}}
+      <div class="field-error">⚠ {{.}}</div>
+      {{end}}
+      {{end}}
+      <div style="font-size:11px;color:#888;">※ マルチキャストアドレスを指定すると WSJT-X と JTDX を同時に受信できます（インターフェースはマルチキャスト時のみ使用）</div>
+    </div>
+    <div class="form-group">
+      <label>UDP 転送（GridTracker / JTAlert / Log4OM 等）</label>
+      {{range $i, $f := .Config.Forwards}}
+      <div class="listener-row">
+        <span class="port-num">{{inc $i}}</span>
+        <input type="text" name="forward_target_{{$i}}" value="{{$f.Target}}" placeholder="127.0.0.1:2238">
+        <input type="text" class="name" name="forward_types_{{$i}}" value="{{$f.Types}}" placeholder="全て">
+      </div>
+      {{with index $.ForwardStats $i}}{{if or .Sent .Filtered .Errors}}
+      <div class="forward-stats">送信 {{.Sent}} / 除外 {{.Filtered}} / 返信 {{.Replies}} / エラー {{.Errors}}{{with .LastError}}（{{.}}）{{end}}</div>
+      {{end}}{{end}}
+      {{with safeIndex $.ForwardErrors $i}}
+      <div class="field-error">⚠ {{.}}</div>
+      {{end}}
+      {{end}}
+      <div style="font-size:11px;color:#888;">※ 種別はカンマ区切りで指定（heartbeat, status, decode, qso_logged, logged_adif, adif 等）。空欄は全て転送</div>
     </div>
     <div class="checkbox-group">
       <label class="checkbox-item">
@@ -241,9 +347,9 @@ button:hover {
         <input type="checkbox" name="use_pty" {{if .Config.UsePTY}}checked{{end}}>
         <span>PTYルーター（WSJT-X等と共有）</span>
       </label>
-      <div style="font-size:11px;color:#888;margin-top:4px;padding-left:28px;">※ PTYルーター・ポート・ボーレートの変更は再起動後に反映</div>
+      <div style="font-size:11px;color:#888;margin-top:4px;padding-left:28px;">※ PTYルーター・ポート・ボーレート・機種の変更は再起動後に反映</div>
       {{else}}
-      <div style="font-size:11px;color:#888;margin-top:4px;padding-left:28px;">※ ポート・ボーレートの変更は再起動後に反映</div>
+      <div style="font-size:11px;color:#888;margin-top:4px;padding-left:28px;">※ ポート・ボーレート・機種の変更は再起動後に反映</div>
       {{end}}
     </div>
     <div class="form-group">
@@ -262,13 +368,36 @@ button:hover {
           <option value="{{.}}"{{if eq . $rp.Baud}} selected{{end}}>{{.}}</option>
           {{end}}
         </select>
+        <select name="rig_profile_{{$i}}" class="profile" title="無線機の種類">
+          {{range $.RigProfiles}}
+          <option value="{{.Value}}"{{if or (eq .Value $rp.Profile) (and (eq .Value "auto") (eq $rp.Profile ""))}} selected{{end}}>{{.Label}}</option>
+          {{end}}
+        </select>
+        <input type="text" name="rig_civ_{{$i}}" class="civ" list="civ-addrs" value="{{with $rp.CIVAddr}}{{printf "%02X" .}}{{end}}" placeholder="CI-V" title="CI-V アドレス（16進、空欄 = 自動）">
       </div>
+      {{with $rp.CIVAddr}}
+      <div class="forward-stats">CI-V {{printf "%02X" .}}: {{civName .}}</div>
+      {{end}}
+      {{with safeIndex $.CIVRigs $i}}
+      <div class="forward-stats">CI-V バス: {{.}}</div>
+      {{end}}
       {{if and $.HasPTY (safeIndex $.PTYPaths $i)}}
       <div class="pty-path" style="margin-left:28px;margin-bottom:12px;">
         <input type="text" value="{{safeIndex $.PTYPaths $i}}" readonly onclick="this.select()" title="PTYパス (ポート{{inc $i}})">
       </div>
       {{end}}
+      {{with safeIndex $.RigctldAddrs $i}}
+      <div class="forward-stats">rigctld: {{.}}</div>
       {{end}}
+      {{with safeIndex $.RigctldErrors $i}}
+      <div class="field-error">⚠ rigctld: {{.}}</div>
+      {{end}}
+      {{end}}
+      <datalist id="civ-addrs">
+        {{range .CIVAddrs}}
+        <option value="{{.Value}}">{{.Label}}</option>
+        {{end}}
+      </datalist>
       <div class="broadcast-mode">
         <label>
           <input type="radio" name="broadcast_mode" value="all"{{if eq .Config.RigBroadcastMode "all"}} checked{{end}}>
@@ -290,77 +419,264 @@ button:hover {
       </div>
     </div>
     <div class="checkbox-group">
-      <div style="font-weight:600;margin-bottom:12px;color:#333;">📚 Logbook連携</div>
       <label class="checkbox-item">
-        <input type="checkbox" name="logbook_qrz_enabled" {{if .Config.LogbookQRZEnabled}}checked{{end}}>
-        <span>QRZ.com Logbook</span>
+        <input type="checkbox" name="rigctld" {{if .Config.Rigctld}}checked{{end}}>
+        <span>rigctld 互換サーバー（Hamlib NET rigctl で無線機を共有）</span>
       </label>
       <div class="form-group" style="margin-left:28px;">
-        <label for="logbook_qrz_apikey">API Key</label>
-        <input type="password" id="logbook_qrz_apikey" name="logbook_qrz_apikey" value="{{.Config.LogbookQRZAPIKey}}">
+        <label for="rigctld_port">TCP ポート（ポート1。ポート2以降は +1 ずつ）</label>
+        <input type="text" id="rigctld_port" name="rigctld_port" value="{{.Config.RigctldPort}}">
       </div>
-      <label class="checkbox-item">
-        <input type="checkbox" name="logbook_hamqth_enabled" {{if .Config.LogbookHamQTHEnabled}}checked{{end}}>
-        <span>HamQTH</span>
-      </label>
       <div class="form-group" style="margin-left:28px;">
-        <label for="logbook_hamqth_callsign">Callsign</label>
-        <input type="text" id="logbook_hamqth_callsign" name="logbook_hamqth_callsign" value="{{.Config.LogbookHamQTHCallsign}}">
-        <label for="logbook_hamqth_user">ユーザー名</label>
-        <input type="text" id="logbook_hamqth_user" name="logbook_hamqth_user" value="{{.Config.LogbookHamQTHUser}}">
-        <label for="logbook_hamqth_pass">パスワード</label>
-        <input type="password" id="logbook_hamqth_pass" name="logbook_hamqth_pass" value="{{.Config.LogbookHamQTHPass}}">
-      </div>
-      <label class="checkbox-item">
-        <input type="checkbox" name="logbook_eqsl_enabled" {{if .Config.LogbookEQSLEnabled}}checked{{end}}>
-        <span>eQSL.cc</span>
-      </label>
-      <div class="form-group" style="margin-left:28px;">
-        <label for="logbook_eqsl_user">ユーザー名</label>
-        <input type="text" id="logbook_eqsl_user" name="logbook_eqsl_user" value="{{.Config.LogbookEQSLUser}}">
-        <label for="logbook_eqsl_pass">パスワード</label>
-        <input type="password" id="logbook_eqsl_pass" name="logbook_eqsl_pass" value="{{.Config.LogbookEQSLPass}}">
+        <label for="max_tx_seconds">最大送信時間（秒）</label>
+        <input type="text" id="max_tx_seconds" name="max_tx_seconds" value="{{.Config.MaxTXSeconds}}">
+        <div style="font-size:11px;color:#888;">※ WebSocket / rigctld から送信にした場合、この時間を超えると自動で受信に戻します</div>
       </div>
+    </div>
+    <div class="checkbox-group">
+      <div style="font-weight:600;margin-bottom:12px;color:#333;">📚 Logbook連携</div>
+      {{range .Logbooks}}
       <label class="checkbox-item">
-        <input type="checkbox" name="logbook_hrdlog_enabled" {{if .Config.LogbookHRDLogEnabled}}checked{{end}}>
-        <span>HRDLog.net</span>
+        <input type="checkbox" name="logbook_{{.Type}}_enabled" {{if .Enabled}}checked{{end}}>
+        <span>{{.Name}}</span>
       </label>
       <div class="form-group" style="margin-left:28px;">
-        <label for="logbook_hrdlog_callsign">Callsign</label>
-        <input type="text" id="logbook_hrdlog_callsign" name="logbook_hrdlog_callsign" value="{{.Config.LogbookHRDLogCallsign}}">
-        <label for="logbook_hrdlog_code">Upload Code</label>
-        <input type="password" id="logbook_hrdlog_code" name="logbook_hrdlog_code" value="{{.Config.LogbookHRDLogCode}}">
+        {{$type := .Type}}
+        {{range .Fields}}
+        <label for="logbook_{{$type}}_{{.Key}}">{{.Label}}</label>
+        <input type="{{if .Secret}}password{{else}}text{{end}}" id="logbook_{{$type}}_{{.Key}}" name="logbook_{{$type}}_{{.Key}}" value="{{.Value}}"{{with .Placeholder}} placeholder="{{.}}"{{end}}>
+        {{end}}
       </div>
+      {{end}}
       <label class="checkbox-item">
-        <input type="checkbox" name="logbook_clublog_enabled" {{if .Config.LogbookClubLogEnabled}}checked{{end}}>
-        <span>ClubLog</span>
+        <input type="checkbox" name="qsl_sync" {{if .Config.QSLSync}}checked{{end}}>
+        <span>QSL確認を定期取得（LoTW / eQSL / QRZ / ClubLog）</span>
       </label>
-      <div class="form-group" style="margin-left:28px;">
-        <label for="logbook_clublog_email">Email</label>
-        <input type="text" id="logbook_clublog_email" name="logbook_clublog_email" value="{{.Config.LogbookClubLogEmail}}">
-        <label for="logbook_clublog_pass">Password</label>
-        <input type="password" id="logbook_clublog_pass" name="logbook_clublog_pass" value="{{.Config.LogbookClubLogPass}}">
-        <label for="logbook_clublog_callsign">Callsign</label>
-        <input type="text" id="logbook_clublog_callsign" name="logbook_clublog_callsign" value="{{.Config.LogbookClubLogCall}}">
-        <label for="logbook_clublog_api">API Key (オプション)</label>
-        <input type="password" id="logbook_clublog_api" name="logbook_clublog_api" value="{{.Config.LogbookClubLogAPI}}" placeholder="未入力時は430ssb.net経由">
-      </div>
+      <div class="forward-stats"><a href="/outbox">送信キュー</a>（未送信 {{.OutboxCount}} 件）</div>
+    </div>
+    <div class="form-group">
+      <label for="pairing_token">ペアリングトークン（HAMLAB などの接続先に入力）</label>
+      <input type="text" id="pairing_token" value="{{.Config.PairingToken}}" readonly onclick="this.select()">
+    </div>
+    <div class="form-group">
+      <label for="allowed_origins">接続を許可する Origin（1 行に 1 つ、空欄で既定値）</label>
+      <textarea id="allowed_origins" name="allowed_origins" rows="3">{{.AllowedOrigins}}</textarea>
     </div>
     <button type="submit">保存</button>
   </form>
+  <form method="post" action="/pairing/regenerate" style="margin-top:12px;">
+    <input type="hidden" name="csrf" value="{{csrf}}">
+    <button type="submit" onclick="return confirm('トークンを再生成すると、接続中のクライアントは切断され、新しいトークンの入力が必要になります。')">ペアリングトークンを再生成</button>
+  </form>
   <div class="version">HAMLAB Bridge v0.4.2</div>
 </div>
 </body>
 </html>
 `))
 
+var outboxTmpl = template.Must(template.New("").Funcs(template.FuncMap{
+	"serviceName": uploaderName,
+	"csrf":        func() string { return csrfToken },
+	"fmtTime": func(t time.Time) string {
+		if t.IsZero() {
+			return "-"
+		}
+		return t.Local().Format("01/02 15:04:05")
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="ja">
+<head>
+<meta charset="UTF-8">
+<meta name="viewport" content="width=device-width, initial-scale=1.0">
+<title>HAMLAB Bridge 送信キュー</title>
+<style>
+body {
+  font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, "Helvetica Neue", Arial, sans-serif;
+  background: #f5f5f5;
+  margin: 0;
+  padding: 20px;
+}
+.container {
+  max-width: 760px;
+  margin: 0 auto;
+  background: #fff;
+  border-radius: 12px;
+  box-shadow: 0 2px 12px rgba(0,0,0,0.1);
+  padding: 24px;
+}
+h1 {
+  font-size: 20px;
+  font-weight: 600;
+  margin: 0 0 24px 0;
+  color: #333;
+  text-align: center;
+}
+table {
+  width: 100%;
+  border-collapse: collapse;
+  font-size: 13px;
+}
+th, td {
+  text-align: left;
+  padding: 6px 8px;
+  border-bottom: 1px solid #eee;
+  vertical-align: top;
+}
+th {
+  color: #555;
+  font-weight: 500;
+}
+.state-failed {
+  color: #c0392b;
+  font-weight: 600;
+}
+.error {
+  font-size: 11px;
+  color: #c0392b;
+  word-break: break-all;
+}
+form {
+  display: inline;
+}
+button {
+  padding: 4px 10px;
+  font-size: 12px;
+  color: #fff;
+  background: #007aff;
+  border: none;
+  border-radius: 6px;
+  cursor: pointer;
+}
+button.danger {
+  background: #c0392b;
+}
+.actions {
+  margin-bottom: 16px;
+  display: flex;
+  justify-content: space-between;
+  align-items: center;
+}
+.empty {
+  text-align: center;
+  color: #999;
+  padding: 24px 0;
+}
+a {
+  color: #007aff;
+  font-size: 13px;
+}
+</style>
+</head>
+<body>
+<div class="container">
+  <h1>送信キュー</h1>
+  <div class="actions">
+    <a href="/settings">← 設定に戻る</a>
+    {{if .}}<form method="POST" action="/outbox/retry"><input type="hidden" name="csrf" value="{{csrf}}"><button type="submit">すべて今すぐ再送</button></form>{{end}}
+  </div>
+  {{if .}}
+  <table>
+    <tr><th>サービス</th><th>コール</th><th>交信日時</th><th>状態</th><th>試行</th><th>次回</th><th></th></tr>
+    {{range .}}
+    <tr>
+      <td>{{serviceName .Service}}</td>
+      <td>{{.Call}}</td>
+      <td>{{fmtTime .TimeOn}}</td>
+      <td>{{if eq .State "failed"}}<span class="state-failed">失敗{{with .LastStatus}}（{{.}}）{{end}}</span>{{else}}待機中{{with .LastStatus}}（{{.}}）{{end}}{{end}}</td>
+      <td>{{.Attempts}}</td>
+      <td>{{if eq .State "failed"}}-{{else}}{{fmtTime .NextTry}}{{end}}</td>
+      <td>
+        <form method="POST" action="/outbox/retry"><input type="hidden" name="csrf" value="{{csrf}}"><input type="hidden" name="id" value="{{.ID}}"><button type="submit">今すぐ再送</button></form>
+        <form method="POST" action="/outbox/delete"><input type="hidden" name="csrf" value="{{csrf}}"><input type="hidden" name="id" value="{{.ID}}"><button type="submit" class="danger">削除</button></form>
+      </td>
+    </tr>
+    {{with .LastError}}<tr><td></td><td colspan="6" class="error">{{.}}</td></tr>{{end}}
+    {{end}}
+  </table>
+  {{else}}
+  <div class="empty">未送信のQSOはありません</div>
+  {{end}}
+</div>
+</body>
+</html>
+`))
+
 type PageData struct {
-	Config   Config
-	Saved    bool
-	PTYPaths []string
-	Ports    []string
-	Bauds    []int
-	HasPTY   bool
+	Config         Config
+	Saved          bool
+	PTYPaths       []string
+	Ports          []string
+	Bauds          []int
+	HasPTY         bool
+	Interfaces     []string
+	ListenerErrors []string
+	ForwardStats   []ForwardStats
+	ForwardErrors  []string
+	EnrichRows     []enrichRow
+	OutboxCount    int
+	Logbooks       []logbookForm
+	AllowedOrigins string // 1 行に 1 つ
+	RigctldAddrs   []string
+	RigctldErrors  []string
+	RigProfiles    []rigProfile
+	CIVAddrs       []civAddrChoice
+	CIVRigs        []string // ポートごとに CI-V バスで見つかった無線機
+}
+
+// logbookForm is one logbook block of the settings form.
+type logbookForm struct {
+	Type    string
+	Name    string
+	Enabled bool
+	Fields  []logbookFormField
+}
+
+type logbookFormField struct {
+	UploaderField
+	Value string
+}
+
+// logbookForms returns the settings form blocks for the configured logbooks.
+func logbookForms(logbooks []LogbookConfig) []logbookForm {
+	var forms []logbookForm
+	for _, lc := range logbooks {
+		u, ok := lookupUploader(lc.Type)
+		if !ok {
+			continue
+		}
+		f := logbookForm{Type: lc.Type, Name: u.Name(), Enabled: lc.Enabled}
+		for _, uf := range u.Fields() {
+			f.Fields = append(f.Fields, logbookFormField{UploaderField: uf, Value: lc.value(uf)})
+		}
+		forms = append(forms, f)
+	}
+	return forms
+}
+
+// enrichRow is one ADIF field row of the enrichment policy table.
+type enrichRow struct {
+	Key   string
+	Label string
+	Value string
+}
+
+// enrichField binds an enrichment policy row to its Config field.
+type enrichField struct {
+	row enrichRow
+	ptr *string
+}
+
+// enrichFields returns the enrichment policy table rows for the given config.
+// Key is also the suffix of the form field name.
+func enrichFields(e *EnrichConfig) []enrichField {
+	return []enrichField{
+		{enrichRow{"name", "NAME（運用者名）", e.Name}, &e.Name},
+		{enrichRow{"qth", "QTH", e.QTH}, &e.QTH},
+		{enrichRow{"grid", "GRIDSQUARE", e.Grid}, &e.Grid},
+		{enrichRow{"cnty", "CNTY（JCC）", e.Cnty}, &e.Cnty},
+		{enrichRow{"state", "STATE", e.State}, &e.State},
+		{enrichRow{"dxcc", "DXCC", e.DXCC}, &e.DXCC},
+	}
 }
 
 var defaultBauds = []int{4800, 9600, 19200, 38400, 57600, 115200}
@@ -370,7 +686,7 @@ var defaultBauds = []int{4800, 9600, 19200, 38400, 57600, 115200}
 // When the form is submitted, the settings are saved and the user is redirected back to the settings page.
 func startWebUI() {
 
-	http.HandleFunc("/settings", func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc("/settings", requireCSRF(func(w http.ResponseWriter, r *http.Request) {
 
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
 
@@ -385,6 +701,11 @@ func startWebUI() {
 			copy(oldPorts, config.RigPorts)
 			oldBroadcastMode := config.RigBroadcastMode
 			oldSelectedIndex := config.SelectedRigIndex
+			oldRigctld, oldRigctldPort := config.Rigctld, config.RigctldPort
+			oldListeners := make([]UDPListenerConfig, len(config.Listeners))
+			copy(oldListeners, config.Listeners)
+			oldForwards := make([]UDPForwardConfig, len(config.Forwards))
+			copy(oldForwards, config.Forwards)
 
 			config.QRZUser = r.FormValue("user")
 			config.QRZPass = r.FormValue("pass")
@@ -393,6 +714,29 @@ func startWebUI() {
 			config.UseRig = r.FormValue("use_rig") != ""
 			config.UsePTY = r.FormValue("use_pty") != ""
 
+			// ADIF補完ポリシー
+			for _, er := range enrichFields(&config.Enrich) {
+				switch v := r.FormValue("enrich_" + er.row.Key); v {
+				case EnrichOff, EnrichFill, EnrichOverwrite:
+					*er.ptr = v
+				}
+			}
+
+			// UDP受信設定の読み取り
+			for i := range config.Listeners {
+				idx := strconv.Itoa(i)
+				config.Listeners[i].Name = strings.TrimSpace(r.FormValue("listener_name_" + idx))
+				config.Listeners[i].Address = strings.TrimSpace(r.FormValue("listener_addr_" + idx))
+				config.Listeners[i].Interface = r.FormValue("listener_iface_" + idx)
+			}
+
+			// UDP転送設定の読み取り
+			for i := range config.Forwards {
+				idx := strconv.Itoa(i)
+				config.Forwards[i].Target = strings.TrimSpace(r.FormValue("forward_target_" + idx))
+				config.Forwards[i].Types = strings.TrimSpace(r.FormValue("forward_types_" + idx))
+			}
+
 			// 複数ポート設定の読み取り
 			for i := 0; i < 5; i++ {
 				portKey := "rig_port_" + strconv.Itoa(i)
@@ -403,6 +747,15 @@ func startWebUI() {
 						config.RigPorts[i].Baud = baud
 					}
 				}
+				if v := r.FormValue("rig_profile_" + strconv.Itoa(i)); v != "" {
+					if v == RigProfileAuto || rigDriverForProfile(v) == nil {
+						v = ""
+					}
+					config.RigPorts[i].Profile = v
+				}
+				if addr, ok := parseCIVAddrForm(r.FormValue("rig_civ_" + strconv.Itoa(i))); ok {
+					config.RigPorts[i].CIVAddr = addr
+				}
 			}
 
 			// 後方互換性: RigPorts[0]をRigPort/RigBaudにも反映
@@ -422,24 +775,47 @@ func startWebUI() {
 				}
 			}
 
-			// Logbook連携設定
-			config.LogbookQRZEnabled = r.FormValue("logbook_qrz_enabled") != ""
-			config.LogbookQRZAPIKey = r.FormValue("logbook_qrz_apikey")
-			config.LogbookHamQTHEnabled = r.FormValue("logbook_hamqth_enabled") != ""
-			config.LogbookHamQTHCallsign = r.FormValue("logbook_hamqth_callsign")
-			config.LogbookHamQTHUser = r.FormValue("logbook_hamqth_user")
-			config.LogbookHamQTHPass = r.FormValue("logbook_hamqth_pass")
-			config.LogbookEQSLEnabled = r.FormValue("logbook_eqsl_enabled") != ""
-			config.LogbookEQSLUser = r.FormValue("logbook_eqsl_user")
-			config.LogbookEQSLPass = r.FormValue("logbook_eqsl_pass")
-			config.LogbookHRDLogEnabled = r.FormValue("logbook_hrdlog_enabled") != ""
-			config.LogbookHRDLogCallsign = r.FormValue("logbook_hrdlog_callsign")
-			config.LogbookHRDLogCode = r.FormValue("logbook_hrdlog_code")
-			config.LogbookClubLogEnabled = r.FormValue("logbook_clublog_enabled") != ""
-			config.LogbookClubLogEmail = r.FormValue("logbook_clublog_email")
-			config.LogbookClubLogPass = r.FormValue("logbook_clublog_pass")
-			config.LogbookClubLogCall = r.FormValue("logbook_clublog_callsign")
-			config.LogbookClubLogAPI = r.FormValue("logbook_clublog_api")
+			// rigctld 互換サーバー
+			config.Rigctld = r.FormValue("rigctld") != ""
+			if port, err := strconv.Atoi(strings.TrimSpace(r.FormValue("rigctld_port"))); err == nil && port > 0 && port < 65536 {
+				config.RigctldPort = port
+			}
+			if sec, err := strconv.Atoi(strings.TrimSpace(r.FormValue("max_tx_seconds"))); err == nil && sec > 0 {
+				config.MaxTXSeconds = sec
+			}
+
+			// Logbook連携設定（送信中の設定を書き換えないよう新しい値で置き換える）
+			for i, lc := range config.Logbooks {
+				u, ok := lookupUploader(lc.Type)
+				if !ok {
+					continue
+				}
+				prefix := "logbook_" + lc.Type + "_"
+				next := LogbookConfig{Type: lc.Type, Enabled: r.FormValue(prefix+"enabled") != ""}
+				for _, f := range u.Fields() {
+					v := r.FormValue(prefix + f.Key)
+					if !f.Secret {
+						v = strings.TrimSpace(v)
+					}
+					next.setValue(f, v)
+				}
+				config.Logbooks[i] = next
+			}
+
+			oldQSLSync := config.QSLSync
+			config.QSLSync = r.FormValue("qsl_sync") != ""
+
+			// 接続を許可する Origin（空欄なら既定値）
+			var origins []string
+			for _, o := range strings.Split(r.FormValue("allowed_origins"), "\n") {
+				if o = strings.TrimRight(strings.TrimSpace(o), "/"); o != "" {
+					origins = append(origins, o)
+				}
+			}
+			config.AllowedOrigins = origins
+			if config.QSLSync && !oldQSLSync {
+				go syncQSL()
+			}
 
 			// リグ設定の変更をチェック
 			rigSettingsChanged := false
@@ -450,15 +826,44 @@ func startWebUI() {
 				rigSettingsChanged = true
 			}
 			for i := range config.RigPorts {
-				if oldPorts[i].Port != config.RigPorts[i].Port || oldPorts[i].Baud != config.RigPorts[i].Baud {
+				if oldPorts[i] != config.RigPorts[i] {
 					rigSettingsChanged = true
 					break
 				}
 			}
 
+			rigctldChanged := rigSettingsChanged || oldRigctld != config.Rigctld || oldRigctldPort != config.RigctldPort
+
+			listenersChanged := false
+			for i := range config.Listeners {
+				if oldListeners[i] != config.Listeners[i] {
+					listenersChanged = true
+					break
+				}
+			}
+
+			forwardsChanged := listenersChanged
+			for i := range config.Forwards {
+				if oldForwards[i] != config.Forwards[i] {
+					forwardsChanged = true
+					break
+				}
+			}
+
 			saveConfig()
 			configLock.Unlock()
 
+			// UDP受信設定が変更された場合は再バインド（エラーを画面に出すため同期実行）
+			if listenersChanged {
+				restartBridge()
+			}
+			if forwardsChanged {
+				restartForwarders()
+			}
+			if rigctldChanged {
+				restartRigctld()
+			}
+
 			// リグ設定が変更された場合は再起動（非同期）
 			if rigSettingsChanged && config.UseRig {
 				log.Println("[CONFIG] rig settings changed, restarting rig watcher...")
@@ -479,18 +884,103 @@ func startWebUI() {
 
 		configLock.RLock()
 		data := PageData{
-			Config:   config,
-			Saved:    r.URL.Query().Get("saved") == "1",
-			PTYPaths: GetPTYPaths(),
-			Ports:    listSerialPorts(),
-			Bauds:    defaultBauds,
-			HasPTY:   runtime.GOOS == "darwin" || runtime.GOOS == "linux",
+			Config:      config,
+			Saved:       r.URL.Query().Get("saved") == "1",
+			PTYPaths:    GetPTYPaths(),
+			Ports:       listSerialPorts(),
+			Bauds:       defaultBauds,
+			RigProfiles: rigProfiles,
+			CIVAddrs:    civAddrChoices(),
+			HasPTY:      runtime.GOOS == "darwin" || runtime.GOOS == "linux",
 		}
+		for _, er := range enrichFields(&data.Config.Enrich) {
+			data.EnrichRows = append(data.EnrichRows, er.row)
+		}
+		data.Interfaces = listInterfaceNames()
+		data.ListenerErrors = getListenerErrors(len(config.Listeners))
+		data.ForwardStats, data.ForwardErrors = getForwardStatus(len(config.Forwards))
+		data.Logbooks = logbookForms(config.Logbooks)
 		configLock.RUnlock()
+		data.OutboxCount = len(outboxQ.list())
+		data.AllowedOrigins = strings.Join(allowedOrigins(), "\n")
+		data.RigctldAddrs, data.RigctldErrors = getRigctldStatus(len(data.Config.RigPorts))
+		for i := range data.Config.RigPorts {
+			data.CIVRigs = append(data.CIVRigs, civRigsSummary(i))
+		}
 
 		_ = tmpl.Execute(w, data)
+	}))
+
+	http.HandleFunc("/pairing/regenerate", requireCSRF(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		configLock.Lock()
+		config.PairingToken = newToken()
+		saveConfig()
+		configLock.Unlock()
+
+		log.Println("[AUTH] pairing token regenerated")
+		disconnectClients("pairing token changed")
+		http.Redirect(w, r, "/settings?saved=1", http.StatusSeeOther)
+	}))
+
+	http.HandleFunc("/outbox", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = outboxTmpl.Execute(w, outboxQ.list())
 	})
 
-	http.ListenAndServe("127.0.0.1:17801", nil)
+	http.HandleFunc("/outbox/retry", requireCSRF(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		outboxQ.retry(r.FormValue("id"))
+		http.Redirect(w, r, "/outbox", http.StatusSeeOther)
+	}))
+
+	http.HandleFunc("/outbox/delete", requireCSRF(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		outboxQ.remove(r.FormValue("id"))
+		http.Redirect(w, r, "/outbox", http.StatusSeeOther)
+	}))
+
+	http.ListenAndServe("127.0.0.1:17801", withHostCheck(http.DefaultServeMux))
 	log.Println("Settings UI: http://127.0.0.1:17801/settings")
 }
+
+// listInterfaceNames returns the names of the network interfaces that can
+// receive multicast, for the listener interface selector.
+func listInterfaceNames() []string {
+	var names []string
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return names
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp != 0 && iface.Flags&net.FlagMulticast != 0 {
+			names = append(names, iface.Name)
+		}
+	}
+	return names
+}
+
+// parseCIVAddrForm reads a CI-V address field of the settings form: two
+// hex digits, optionally with "0x", or empty (or 00) for automatic. ok is false
+// for invalid input, which keeps the current setting.
+func parseCIVAddrForm(v string) (addr byte, ok bool) {
+	v = strings.TrimSpace(v)
+	v = strings.TrimPrefix(strings.TrimPrefix(v, "0x"), "0X")
+	if v == "" {
+		return 0, true
+	}
+	n, err := strconv.ParseUint(v, 16, 8)
+	if err != nil || n >= civControllerAddr {
+		return 0, false
+	}
+	return byte(n), true
+}
@@ -3,139 +3,359 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"net"
 	"net/http"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 
 	"log"
 )
 
-var clients = map[*websocket.Conn]bool{}
+const (
+	wsSendQueueLen = 2048             // resume で保持イベントをすべて再送できる大きさ
+	wsWriteWait    = 10 * time.Second // 1 メッセージの書き込み期限
+	wsPongWait     = 60 * time.Second // pong（または受信）が途絶えたら切断
+	wsPingPeriod   = wsPongWait * 9 / 10
+	wsMaxMessage   = 64 << 10
+)
+
+// wsClient is a connected WebSocket client. Only its writer goroutine
+// writes to conn; everything else queues messages with enqueue.
+type wsClient struct {
+	conn *websocket.Conn
+	addr string
+	send chan []byte
+	done chan struct{}
+	once sync.Once
+
+	// clientsMu で保護
+	first uint64           // 接続後に最初に送信したイベントの seq
+	sent  uint64           // 最後に送信したイベントの seq
+	subs  *wsSubscriptions // nil = すべてのイベント
+}
+
+var clients = map[*websocket.Conn]*wsClient{}
 var clientsMu sync.Mutex
 
-var broadcastChan = make(chan string, 100)
+var broadcastChan = make(chan wsEvent, 100)
 
 var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool { return true },
+	CheckOrigin: func(r *http.Request) bool { return originAllowed(r.Header.Get("Origin")) },
 }
 
 // wsHandler upgrades the HTTP connection to a WebSocket connection.
-// It stores the WebSocket connection in the clients map for later use.
+// It stores the client in the clients map and starts its reader and writer.
+// The client must come from an allowed Origin and present the pairing token.
 func wsHandler(w http.ResponseWriter, r *http.Request) {
-	c, _ := upgrader.Upgrade(w, r, nil)
+	if !clientAuthorized(w, r) {
+		return
+	}
+	c, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	cl := &wsClient{
+		conn: c,
+		addr: r.RemoteAddr,
+		send: make(chan []byte, wsSendQueueLen),
+		done: make(chan struct{}),
+	}
 	clientsMu.Lock()
-	clients[c] = true
+	clients[c] = cl
 	clientsMu.Unlock()
 
-	// Start goroutine to handle incoming messages from this client
-	go handleClientMessages(c)
+	go cl.writePump()
+	go handleClientMessages(cl)
 }
 
-// Broadcast sends a message to all connected WebSocket clients.
-// If any error occurs when sending to a client, that client is removed from the clients map and closed.
-func broadcast(msg string) {
+// enqueue queues msg for the writer without blocking. It reports false if
+// the client is gone or its queue is full.
+func (cl *wsClient) enqueue(msg []byte) bool {
+	select {
+	case <-cl.done:
+		return false
+	default:
+	}
 	select {
-	case broadcastChan <- msg:
+	case cl.send <- msg:
+		return true
 	default:
-		// バッファフル時は破棄（シリアル読み取りをブロックしない）
+		return false
 	}
 }
 
+// stop closes the connection once. A non-empty reason is logged.
+func (cl *wsClient) stop(reason string) {
+	cl.once.Do(func() {
+		if reason != "" {
+			log.Printf("[WS] client %s disconnected: %s", cl.addr, reason)
+		}
+		close(cl.done)
+		cl.conn.Close()
+	})
+}
+
+// removeClient removes cl from the clients map and closes it.
+func removeClient(cl *wsClient, reason string) {
+	clientsMu.Lock()
+	if clients[cl.conn] == cl {
+		delete(clients, cl.conn)
+	}
+	clientsMu.Unlock()
+	cl.stop(reason)
+}
+
+// reply queues a response to a request of this client.
+func (cl *wsClient) reply(m Message) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return
+	}
+	if !cl.enqueue(b) {
+		removeClient(cl, "send queue full")
+	}
+}
+
+// writePump is the only writer of cl.conn. It sends queued messages and
+// pings the client every wsPingPeriod.
+func (cl *wsClient) writePump() {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case msg := <-cl.send:
+			cl.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := cl.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				removeClient(cl, "write error: "+err.Error())
+				return
+			}
+		case <-ticker.C:
+			cl.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := cl.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				removeClient(cl, "ping error: "+err.Error())
+				return
+			}
+		case <-cl.done:
+			return
+		}
+	}
+}
+
+// Broadcast sends a message to all connected WebSocket clients.
+// The message is numbered and kept in the event log first, so a client
+// can get it with "resume" even if it is dropped here.
+func broadcast(m Message) {
+	events.add(m, func(ev wsEvent) {
+		select {
+		case broadcastChan <- ev:
+		default:
+			// バッファフル時は破棄（シリアル読み取りをブロックしない）
+		}
+	})
+}
+
 // broadcastWorker processes messages from the broadcast channel.
+// It only queues messages, so a stalled client cannot delay the others;
+// a client whose queue is full is disconnected.
 func broadcastWorker() {
-	for msg := range broadcastChan {
-		// log.Println("[WS] broadcast:", msg)
+	for ev := range broadcastChan {
+		// log.Println("[WS] broadcast:", ev.Msg)
+		msg := []byte(ev.Msg)
 		clientsMu.Lock()
-		for c := range clients {
-			if err := c.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+		for c, cl := range clients {
+			if ev.Seq <= cl.sent {
+				continue // resume で送信済み
+			}
+			if !cl.wants(ev) {
+				cl.sent = ev.Seq
+				continue
+			}
+			if !cl.enqueue(msg) {
 				delete(clients, c)
-				c.Close()
+				cl.stop("send queue full (slow client)")
+				continue
+			}
+			if cl.first == 0 {
+				cl.first = ev.Seq
 			}
+			cl.sent = ev.Seq
 		}
 		clientsMu.Unlock()
 	}
 }
 
-// handleClientMessages handles incoming messages from a WebSocket client
-func handleClientMessages(c *websocket.Conn) {
-	defer func() {
-		clientsMu.Lock()
-		delete(clients, c)
-		c.Close()
-		clientsMu.Unlock()
-	}()
+// resumeClient queues the events after since that cl has not received
+// yet, followed by a "resumed" message.
+func resumeClient(cl *wsClient, since uint64) {
+	list, last, gap := events.since(since)
+
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	if clients[cl.conn] != cl {
+		return
+	}
+	replayed := 0
+	for _, ev := range list {
+		if cl.first != 0 && ev.Seq >= cl.first && ev.Seq <= cl.sent {
+			continue // 接続後にライブで送信済み
+		}
+		if !cl.wants(ev) {
+			continue
+		}
+		if !cl.enqueue([]byte(ev.Msg)) {
+			delete(clients, cl.conn)
+			cl.stop("send queue full during resume")
+			return
+		}
+		replayed++
+	}
+	if last > cl.sent {
+		cl.sent = last
+	}
+
+	b, _ := json.Marshal(&ResumedReply{
+		Envelope: newEnvelope("resumed", ""),
+		Since:    since,
+		Last:     last,
+		Replayed: replayed,
+		Gap:      gap,
+	})
+	cl.enqueue(b)
+}
+
+// handleClientMessages handles incoming messages from a WebSocket client.
+// The read deadline is extended by every message and pong, so a client
+// that stops answering pings is disconnected.
+func handleClientMessages(cl *wsClient) {
+	c := cl.conn
+	reason := ""
+	defer func() { removeClient(cl, reason) }()
+
+	c.SetReadLimit(wsMaxMessage)
+	c.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.SetPongHandler(func(string) error {
+		return c.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
 
 	for {
 		_, msg, err := c.ReadMessage()
 		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				reason = "pong timeout"
+			} else if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseNoStatusReceived) &&
+				!errors.Is(err, net.ErrClosed) {
+				reason = "read error: " + err.Error()
+			}
 			return
 		}
+		c.SetReadDeadline(time.Now().Add(wsPongWait))
 
 		// Parse incoming message as JSON
-		var req map[string]interface{}
-		if err := json.Unmarshal(msg, &req); err != nil {
+		var cmd Command
+		if err := json.Unmarshal(msg, &cmd); err != nil {
 			continue
 		}
 
 		// Handle different message types
-		if msgType, ok := req["type"].(string); ok {
-			switch msgType {
-			case "getRigState":
-				// Get RigState for a specific port
-				portIndex := -1
-				if idx, ok := req["port"].(float64); ok {
-					portIndex = int(idx)
-				}
-
-				var response map[string]interface{}
-				if portIndex >= 0 {
-					// Get specific port state
-					rigStatesMu.RLock()
-					if state, exists := rigStates[portIndex]; exists && state != nil {
-						response = map[string]interface{}{
-							"type":  "rigState",
-							"port":  portIndex,
-							"freq":  state.Freq,
-							"mode":  state.Mode,
-							"data":  state.Data,
-							"proto": state.Proto,
-						}
-					} else {
-						response = map[string]interface{}{
-							"type":  "error",
-							"error": "Port not found or not initialized",
-						}
-					}
-					rigStatesMu.RUnlock()
-				} else {
-					// Get all port states
-					rigStatesMu.RLock()
-					states := make(map[string]interface{})
-					for idx, state := range rigStates {
-						if state != nil {
-							states[string(rune(idx+'0'))] = map[string]interface{}{
-								"freq":  state.Freq,
-								"mode":  state.Mode,
-								"data":  state.Data,
-								"proto": state.Proto,
-								"port":  state.Index,
-							}
-						}
-					}
-					response = map[string]interface{}{
-						"type":   "rigStates",
-						"states": states,
-					}
-					rigStatesMu.RUnlock()
-				}
-
-				// Send response
-				if responseBytes, err := json.Marshal(response); err == nil {
-					c.WriteMessage(websocket.TextMessage, responseBytes)
-				}
+		switch cmd.Type {
+		case "resume":
+			// 切断中に配信されたイベントを再送
+			var req ResumeCommand
+			if err := json.Unmarshal(msg, &req); err != nil {
+				cl.reply(newErrorReply(cmd.Type, err))
+				continue
+			}
+			resumeClient(cl, req.Since)
+
+		case "subscribe", "unsubscribe":
+			// 配信するトピックの変更
+			cl.reply(handleSubscribe(cl, msg))
+
+		case "getRigState":
+			var req GetRigStateCommand
+			if err := json.Unmarshal(msg, &req); err != nil {
+				cl.reply(newErrorReply(cmd.Type, err))
+				continue
 			}
+			cl.reply(handleGetRigState(req))
+
+		case "setFreq", "setMode", "setVFO", "setSplit", "setPTT":
+			// 無線機の操作（応答を待つため順番に処理する）
+			var req RigControlCommand
+			if err := json.Unmarshal(msg, &req); err != nil {
+				cl.reply(newErrorReply(cmd.Type, err))
+				continue
+			}
+			cl.reply(handleRigControl(req))
+
+		case "wsjtxReply", "wsjtxHaltTx", "wsjtxFreeText", "wsjtxLocation", "wsjtxHighlightCallsign":
+			// WSJT-X / JTDX へ UDP でコマンド送信
+			cl.reply(handleWSJTXCommand(msg))
+		}
+	}
+}
+
+// handleGetRigState returns the state of the requested port, or of all
+// ports when no port is given.
+func handleGetRigState(req GetRigStateCommand) Message {
+	rigStatesMu.RLock()
+	defer rigStatesMu.RUnlock()
+
+	if req.Port != nil && *req.Port >= 0 {
+		// Get specific port state
+		state, exists := rigStates[*req.Port]
+		if !exists || state == nil {
+			return newErrorReply(req.Type, errors.New("Port not found or not initialized"))
 		}
+		return &RigStateReply{
+			Envelope:     newEnvelope("rigState", ""),
+			RigPortState: rigPortState(*req.Port, state),
+		}
+	}
+
+	// Get all port states（キーはポート番号の10進表記）
+	states := make(map[string]RigPortState)
+	for idx, state := range rigStates {
+		if state != nil {
+			states[strconv.Itoa(idx)] = rigPortState(state.Index, state)
+		}
+	}
+	return &RigStatesReply{
+		Envelope: newEnvelope("rigStates", ""),
+		States:   states,
+	}
+}
+
+func rigPortState(port int, s *RigState) RigPortState {
+	return RigPortState{
+		Port:  port,
+		Freq:  s.Freq,
+		Mode:  s.Mode,
+		Data:  s.Data,
+		PTT:   s.PTT,
+		Proto: s.Proto,
+	}
+}
+
+// disconnectClients closes every WebSocket connection, e.g. after the
+// pairing token has been changed.
+func disconnectClients(reason string) {
+	clientsMu.Lock()
+	list := make([]*wsClient, 0, len(clients))
+	for c, cl := range clients {
+		delete(clients, c)
+		list = append(list, cl)
+	}
+	clientsMu.Unlock()
+	for _, cl := range list {
+		cl.stop(reason)
 	}
 }
 
@@ -144,8 +364,16 @@ func handleClientMessages(c *websocket.Conn) {
 // When a message is sent to the broadcast function, it is sent to all connected WebSocket clients.
 func startWebSocket() {
 	go broadcastWorker()
+	log.Println("WebSocket: 127.0.0.1:17800/ws")
+	http.ListenAndServe("127.0.0.1:17800", wsServerHandler())
+}
+
+// wsServerHandler returns the handler of the WebSocket server: the
+// WebSocket, the QSO journal API and the schema.
+func wsServerHandler() http.Handler {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/ws", wsHandler)
-	log.Println("WebSocket: 127.0.0.1:17800/ws")
-	http.ListenAndServe("127.0.0.1:17800", mux)
+	registerJournalAPI(mux)
+	mux.HandleFunc("GET /schema.json", handleSchema)
+	return withHostCheck(mux)
 }
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// WebSocket topics a client can subscribe to. Rig events can also be
+// selected per port with "rig:<port>".
+const (
+	TopicADIF    = "adif"    // adif
+	TopicRig     = "rig"     // rig / rigConnected / rigDisconnected
+	TopicPTY     = "pty"     // pty
+	TopicDecodes = "decodes" // wsjtx_decode / wsjtx_status
+	TopicUploads = "uploads" // logbookResult / qslConfirmed
+	TopicQSOs    = "qsos"    // qso_logged
+	TopicAll     = "all"
+)
+
+var wsTopics = []string{TopicADIF, TopicRig, TopicPTY, TopicDecodes, TopicUploads, TopicQSOs}
+
+// eventTopics maps event types to topics. Events of other types are only
+// sent to clients that have not subscribed to specific topics.
+var eventTopics = map[string]string{
+	"adif":            TopicADIF,
+	"rig":             TopicRig,
+	"rigConnected":    TopicRig,
+	"rigDisconnected": TopicRig,
+	"pty":             TopicPTY,
+	"wsjtx_decode":    TopicDecodes,
+	"wsjtx_status":    TopicDecodes,
+	"logbookResult":   TopicUploads,
+	"qslConfirmed":    TopicUploads,
+	"qso_logged":      TopicQSOs,
+}
+
+// rigFields are the fields of a rig event used by the "changes" filter.
+type rigFields struct {
+	Port *int   `json:"port"`
+	Freq int64  `json:"freq"`
+	Mode string `json:"mode"`
+	Data bool   `json:"data"`
+	PTT  bool   `json:"ptt"`
+
+	conn bool // rigConnected / rigDisconnected（changes フィルターの対象外）
+}
+
+// describeEvent returns the topic of a broadcast message and, for rig
+// events, the fields the filters look at.
+func describeEvent(msg string) (topic string, rig *rigFields) {
+	var head struct {
+		Type string `json:"type"`
+		rigFields
+	}
+	if err := json.Unmarshal([]byte(msg), &head); err != nil {
+		return "", nil
+	}
+	topic = eventTopics[head.Type]
+	if topic == TopicRig {
+		rig = &head.rigFields
+		rig.conn = head.Type != "rig"
+	}
+	return topic, rig
+}
+
+// wsFilter narrows the events of a topic.
+type wsFilter struct {
+	// Changes lists rig fields (freq / mode / data / ptt); a rig event is sent
+	// only if one of them differs from the last event sent for that port.
+	Changes []string `json:"changes,omitempty"`
+}
+
+// wsSubscriptions is the topic set of a client. It is guarded by clientsMu.
+type wsSubscriptions struct {
+	topics map[string]wsFilter // "rig" / "rig:2" などのキー
+	last   map[int]rigFields   // ポートごとに最後に送った rig イベント
+}
+
+// parseTopic validates a topic name and normalizes it.
+func parseTopic(t string) (string, error) {
+	t = strings.ToLower(strings.TrimSpace(t))
+	if t == TopicAll {
+		return t, nil
+	}
+	for _, known := range wsTopics {
+		if t == known {
+			return t, nil
+		}
+	}
+	if p, ok := strings.CutPrefix(t, TopicRig+":"); ok {
+		if n, err := strconv.Atoi(p); err == nil && n >= 0 {
+			return TopicRig + ":" + strconv.Itoa(n), nil
+		}
+	}
+	return "", fmt.Errorf("unknown topic: %s", t)
+}
+
+func (f wsFilter) validate() error {
+	for _, c := range f.Changes {
+		switch c {
+		case "freq", "mode", "data", "ptt":
+		default:
+			return fmt.Errorf("unknown filter field: %s", c)
+		}
+	}
+	return nil
+}
+
+// subscribe adds topics with filter f. Subscribing to "all" restores the
+// default of receiving every event.
+func (cl *wsClient) subscribe(topics []string, f wsFilter) {
+	for _, t := range topics {
+		if t == TopicAll {
+			cl.subs = nil
+			continue
+		}
+		if cl.subs == nil {
+			cl.subs = &wsSubscriptions{topics: map[string]wsFilter{}}
+		}
+		cl.subs.topics[t] = f
+		cl.subs.last = nil
+	}
+}
+
+// unsubscribe removes topics. A client receiving everything first expands
+// to the full topic list so that unsubscribing one topic keeps the others.
+// A single port cannot be left out of "rig", so unsubscribing "rig:<port>"
+// while receiving "rig" fails and changes nothing.
+func (cl *wsClient) unsubscribe(topics []string) error {
+	rig := cl.subs == nil
+	if cl.subs != nil {
+		_, rig = cl.subs.topics[TopicRig]
+	}
+	if rig && !slices.Contains(topics, TopicRig) && !slices.Contains(topics, TopicAll) {
+		for _, t := range topics {
+			if strings.HasPrefix(t, TopicRig+":") {
+				return fmt.Errorf("cannot unsubscribe %s while subscribed to %s", t, TopicRig)
+			}
+		}
+	}
+
+	for _, t := range topics {
+		if t == TopicAll {
+			cl.subs = &wsSubscriptions{topics: map[string]wsFilter{}}
+			continue
+		}
+		if cl.subs == nil {
+			cl.subs = &wsSubscriptions{topics: map[string]wsFilter{}}
+			for _, known := range wsTopics {
+				cl.subs.topics[known] = wsFilter{}
+			}
+		}
+		delete(cl.subs.topics, t)
+		if t == TopicRig {
+			// rig の購読解除はポート指定分も含む
+			for k := range cl.subs.topics {
+				if strings.HasPrefix(k, TopicRig+":") {
+					delete(cl.subs.topics, k)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// subscribedTopics returns the current topics, sorted.
+func (cl *wsClient) subscribedTopics() []string {
+	if cl.subs == nil {
+		return []string{TopicAll}
+	}
+	list := make([]string, 0, len(cl.subs.topics))
+	for t := range cl.subs.topics {
+		list = append(list, t)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// wants reports whether ev should be sent to the client and records the
+// rig state sent. The caller must hold clientsMu.
+func (cl *wsClient) wants(ev wsEvent) bool {
+	s := cl.subs
+	if s == nil {
+		return true
+	}
+	if ev.topic == "" {
+		return false
+	}
+	if ev.topic != TopicRig {
+		_, ok := s.topics[ev.topic]
+		return ok
+	}
+
+	f, ok := s.topics[TopicRig]
+	if ev.rig != nil && ev.rig.Port != nil {
+		if pf, pok := s.topics[TopicRig+":"+strconv.Itoa(*ev.rig.Port)]; pok {
+			f, ok = pf, true
+		}
+	}
+	if !ok {
+		return false
+	}
+	if len(f.Changes) == 0 || ev.rig == nil || ev.rig.conn {
+		return true
+	}
+
+	port := -1
+	if ev.rig.Port != nil {
+		port = *ev.rig.Port
+	}
+	prev, seen := s.last[port]
+	if seen && !rigChanged(prev, *ev.rig, f.Changes) {
+		return false
+	}
+	if s.last == nil {
+		s.last = map[int]rigFields{}
+	}
+	s.last[port] = *ev.rig
+	return true
+}
+
+func rigChanged(a, b rigFields, fields []string) bool {
+	for _, f := range fields {
+		switch f {
+		case "freq":
+			if a.Freq != b.Freq {
+				return true
+			}
+		case "mode":
+			if a.Mode != b.Mode {
+				return true
+			}
+		case "data":
+			if a.Data != b.Data {
+				return true
+			}
+		case "ptt":
+			if a.PTT != b.PTT {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// handleSubscribe handles "subscribe" and "unsubscribe" requests:
+//
+//	{"type":"subscribe","topics":["rig:2"],"filter":{"changes":["mode"]}}
+//	{"type":"unsubscribe","topics":["pty"]}
+func handleSubscribe(cl *wsClient, msg []byte) Message {
+	var req SubscribeCommand
+	if err := json.Unmarshal(msg, &req); err != nil {
+		return newErrorReply(req.Type, err)
+	}
+
+	topics := make([]string, 0, len(req.Topics))
+	for _, t := range req.Topics {
+		name, err := parseTopic(t)
+		if err != nil {
+			return newErrorReply(req.Type, err)
+		}
+		topics = append(topics, name)
+	}
+	if err := req.Filter.validate(); err != nil {
+		return newErrorReply(req.Type, err)
+	}
+
+	clientsMu.Lock()
+	var err error
+	if req.Type == "subscribe" {
+		cl.subscribe(topics, req.Filter)
+	} else {
+		err = cl.unsubscribe(topics)
+	}
+	current := cl.subscribedTopics()
+	clientsMu.Unlock()
+	if err != nil {
+		return newErrorReply(req.Type, err)
+	}
+
+	return &SubscribedReply{
+		Envelope: newEnvelope("subscribed", ""),
+		Topics:   current,
+	}
+}
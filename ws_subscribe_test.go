@@ -0,0 +1,93 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHandleSubscribe(t *testing.T) {
+	tests := []struct {
+		name    string
+		reqs    []string
+		want    []string
+		wantErr bool // 最後の要求が失敗する（購読は変わらない）
+	}{
+		{
+			name: "subscribe",
+			reqs: []string{`{"type":"subscribe","topics":["qsos","RIG:2"]}`},
+			want: []string{"qsos", "rig:2"},
+		},
+		{
+			name: "unsubscribe from all",
+			reqs: []string{`{"type":"unsubscribe","topics":["decodes"]}`},
+			want: []string{"adif", "pty", "qsos", "rig", "uploads"},
+		},
+		{
+			name: "unsubscribe rig with its ports",
+			reqs: []string{
+				`{"type":"subscribe","topics":["rig","rig:1","pty"]}`,
+				`{"type":"unsubscribe","topics":["rig"]}`,
+			},
+			want: []string{"pty"},
+		},
+		{
+			name: "unsubscribe a port",
+			reqs: []string{
+				`{"type":"subscribe","topics":["rig:1","rig:2"]}`,
+				`{"type":"unsubscribe","topics":["rig:1"]}`,
+			},
+			want: []string{"rig:2"},
+		},
+		{
+			name: "unsubscribe a port and rig",
+			reqs: []string{
+				`{"type":"subscribe","topics":["rig","rig:1"]}`,
+				`{"type":"unsubscribe","topics":["rig:1","rig"]}`,
+			},
+			want: []string{},
+		},
+		{
+			name: "unsubscribe a port of rig",
+			reqs: []string{
+				`{"type":"subscribe","topics":["rig"]}`,
+				`{"type":"unsubscribe","topics":["rig:1"]}`,
+			},
+			want:    []string{"rig"},
+			wantErr: true,
+		},
+		{
+			name:    "unsubscribe a port from all",
+			reqs:    []string{`{"type":"unsubscribe","topics":["rig:1"]}`},
+			want:    []string{"all"},
+			wantErr: true,
+		},
+		{
+			name:    "old logs topic",
+			reqs:    []string{`{"type":"subscribe","topics":["logs"]}`},
+			want:    []string{"all"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl := &wsClient{}
+			var reply Message
+			for _, r := range tt.reqs {
+				reply = handleSubscribe(cl, []byte(r))
+			}
+			if e, ok := reply.(*ErrorReply); ok != tt.wantErr {
+				t.Errorf("reply = %+v, wantErr %v", reply, tt.wantErr)
+			} else if !ok && !reflect.DeepEqual(reply.(*SubscribedReply).Topics, tt.want) {
+				t.Errorf("topics = %q, want %q", reply.(*SubscribedReply).Topics, tt.want)
+			} else if ok && e.Command == "" {
+				t.Errorf("error reply without command: %+v", e)
+			}
+			clientsMu.Lock()
+			got := cl.subscribedTopics()
+			clientsMu.Unlock()
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("subscribed = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newWSTestServer serves wsHandler on a local test server.
+func newWSTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(wsHandler))
+	t.Cleanup(func() {
+		disconnectClients("")
+		srv.Close()
+	})
+	return srv
+}
+
+// dialWS connects a client with the pairing token and waits until the
+// server has registered it.
+func dialWS(t *testing.T, srv *httptest.Server) *websocket.Conn {
+	t.Helper()
+	clientsMu.Lock()
+	n := len(clients)
+	clientsMu.Unlock()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws?token=" + testPairingToken
+	c, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	waitFor(t, "client registered", func() bool {
+		clientsMu.Lock()
+		defer clientsMu.Unlock()
+		return len(clients) > n
+	})
+	return c
+}
+
+// waitFor polls cond for up to 5 seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// readSeqs reads n messages and returns their seq numbers.
+func readSeqs(t *testing.T, c *websocket.Conn, n int) []uint64 {
+	t.Helper()
+	var seqs []uint64
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for len(seqs) < n {
+		_, b, err := c.ReadMessage()
+		if err != nil {
+			t.Fatalf("read after %d messages: %v", len(seqs), err)
+		}
+		var env Envelope
+		if err := json.Unmarshal(b, &env); err != nil {
+			t.Fatalf("decode %s: %v", b, err)
+		}
+		if env.Seq != 0 {
+			seqs = append(seqs, env.Seq)
+		}
+	}
+	return seqs
+}
+
+func testRigEvent(port int) Message {
+	return &RigConnectionEvent{Envelope: newEnvelope("rigConnected", ""), Port: port, Device: "test"}
+}
+
+// TestBroadcastConcurrentOrder broadcasts from several goroutines at once
+// (as the rig readers and UDP listeners do) and checks that every client
+// gets every event, in seq order.
+func TestBroadcastConcurrentOrder(t *testing.T) {
+	srv := newWSTestServer(t)
+	a := dialWS(t, srv)
+	b := dialWS(t, srv)
+
+	const senders, perSender = 48, 2 // broadcastChan に収まる数
+	var wg sync.WaitGroup
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < perSender; j++ {
+				broadcast(testRigEvent(i))
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, c := range []*websocket.Conn{a, b} {
+		seqs := readSeqs(t, c, senders*perSender)
+		for i := 1; i < len(seqs); i++ {
+			if seqs[i] != seqs[i-1]+1 {
+				t.Fatalf("events out of order or missing: %v", seqs)
+			}
+		}
+	}
+}
+
+// TestWSSlowClientEvicted checks that a client that stops reading is
+// disconnected once its queue is full, while the others keep receiving.
+func TestWSSlowClientEvicted(t *testing.T) {
+	srv := newWSTestServer(t)
+	fast := dialWS(t, srv)
+	_ = dialWS(t, srv) // 読み取らないクライアント
+
+	// fast は別 goroutine で読み続ける
+	var mu sync.Mutex
+	lastDevice := ""
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			_, b, err := fast.ReadMessage()
+			if err != nil {
+				readErr <- err
+				return
+			}
+			var ev RigConnectionEvent
+			if json.Unmarshal(b, &ev) == nil {
+				mu.Lock()
+				lastDevice = ev.Device
+				mu.Unlock()
+			}
+		}
+	}()
+
+	// 大きなイベントで送信キューとソケットのバッファを埋める
+	big := strings.Repeat("x", 16<<10)
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		clientsMu.Lock()
+		n := len(clients)
+		clientsMu.Unlock()
+		if n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("slow client was not disconnected")
+		}
+		for len(broadcastChan) > 50 {
+			time.Sleep(time.Millisecond) // 配信側で破棄されないよう待つ
+		}
+		broadcast(&RigConnectionEvent{Envelope: newEnvelope("rigConnected", ""), Device: big})
+	}
+
+	broadcast(&RigConnectionEvent{Envelope: newEnvelope("rigConnected", ""), Device: "last"})
+	waitFor(t, "last event on the fast client", func() bool {
+		select {
+		case err := <-readErr:
+			t.Fatalf("fast client lost: %v", err)
+		default:
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return lastDevice == "last"
+	})
+}
+
+// TestWSConcurrentClients runs requests from several clients while events
+// are broadcast, so that the race detector sees replies and broadcasts
+// queued for the same connection at once.
+func TestWSConcurrentClients(t *testing.T) {
+	srv := newWSTestServer(t)
+	const nClients, nRequests, nEvents = 4, 20, 50
+
+	conns := make([]*websocket.Conn, nClients)
+	for i := range conns {
+		conns[i] = dialWS(t, srv)
+	}
+
+	var wg sync.WaitGroup
+	for _, c := range conns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < nRequests; i++ {
+				if err := c.WriteJSON(map[string]any{"type": "getRigState"}); err != nil {
+					t.Errorf("write: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < nEvents; i++ {
+			broadcast(testRigEvent(i))
+		}
+	}()
+
+	for _, c := range conns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			replies, events := 0, 0
+			c.SetReadDeadline(time.Now().Add(10 * time.Second))
+			for replies < nRequests || events < nEvents {
+				_, b, err := c.ReadMessage()
+				if err != nil {
+					t.Errorf("read (%d replies, %d events): %v", replies, events, err)
+					return
+				}
+				var env Envelope
+				if err := json.Unmarshal(b, &env); err != nil {
+					t.Errorf("decode %s: %v", b, err)
+					return
+				}
+				switch env.Type {
+				case "rigStates":
+					replies++
+				case "rigConnected":
+					events++
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,634 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"time"
+)
+
+// WSJT-X NetworkMessage プロトコル（QDataStream, big endian）
+// https://sourceforge.net/p/wsjt/wsjtx/ci/master/tree/Network/NetworkMessage.hpp
+
+const wsjtxMagic uint32 = 0xADBCCBDA
+
+// wsjtxSchema is the schema version the bridge speaks (Qt 5.4 serialization).
+const wsjtxSchema uint32 = 3
+
+type WSJTXMessageType uint32
+
+const (
+	WSJTXHeartbeat           WSJTXMessageType = 0
+	WSJTXStatus              WSJTXMessageType = 1
+	WSJTXDecode              WSJTXMessageType = 2
+	WSJTXClear               WSJTXMessageType = 3
+	WSJTXReply               WSJTXMessageType = 4
+	WSJTXQSOLogged           WSJTXMessageType = 5
+	WSJTXClose               WSJTXMessageType = 6
+	WSJTXReplay              WSJTXMessageType = 7
+	WSJTXHaltTx              WSJTXMessageType = 8
+	WSJTXFreeText            WSJTXMessageType = 9
+	WSJTXWSPRDecode          WSJTXMessageType = 10
+	WSJTXLocation            WSJTXMessageType = 11
+	WSJTXLoggedADIF          WSJTXMessageType = 12
+	WSJTXHighlightCallsign   WSJTXMessageType = 13
+	WSJTXSwitchConfiguration WSJTXMessageType = 14
+	WSJTXConfigure           WSJTXMessageType = 15
+)
+
+var wsjtxTypeNames = map[WSJTXMessageType]string{
+	WSJTXHeartbeat:           "heartbeat",
+	WSJTXStatus:              "status",
+	WSJTXDecode:              "decode",
+	WSJTXClear:               "clear",
+	WSJTXReply:               "reply",
+	WSJTXQSOLogged:           "qso_logged",
+	WSJTXClose:               "close",
+	WSJTXReplay:              "replay",
+	WSJTXHaltTx:              "halt_tx",
+	WSJTXFreeText:            "free_text",
+	WSJTXWSPRDecode:          "wspr_decode",
+	WSJTXLocation:            "location",
+	WSJTXLoggedADIF:          "logged_adif",
+	WSJTXHighlightCallsign:   "highlight_callsign",
+	WSJTXSwitchConfiguration: "switch_configuration",
+	WSJTXConfigure:           "configure",
+}
+
+func (t WSJTXMessageType) String() string {
+	if name, ok := wsjtxTypeNames[t]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+var errWSJTXShort = errors.New("wsjtx: message truncated")
+
+// WSJTXMessage is a decoded WSJT-X datagram. Only the fields relevant to
+// the message Type are populated.
+type WSJTXMessage struct {
+	Type   WSJTXMessageType
+	Schema uint32
+	ID     string
+
+	Heartbeat *WSJTXHeartbeatMsg
+	Status    *WSJTXStatusMsg
+	Decode    *WSJTXDecodeMsg
+	Clear     *WSJTXClearMsg
+	Reply     *WSJTXReplyMsg
+	QSOLogged *WSJTXQSOLoggedMsg
+	HaltTx    *WSJTXHaltTxMsg
+	FreeText  *WSJTXFreeTextMsg
+	WSPR      *WSJTXWSPRDecodeMsg
+	Location  *WSJTXLocationMsg
+	ADIF      string // LoggedADIF
+	Highlight *WSJTXHighlightMsg
+	Config    *WSJTXConfigureMsg
+	SwitchTo  string // SwitchConfiguration
+}
+
+type WSJTXHeartbeatMsg struct {
+	MaxSchema uint32
+	Version   string
+	Revision  string
+}
+
+type WSJTXStatusMsg struct {
+	DialFreq             uint64
+	Mode                 string
+	DXCall               string
+	Report               string
+	TxMode               string
+	TxEnabled            bool
+	Transmitting         bool
+	Decoding             bool
+	RxDF                 uint32
+	TxDF                 uint32
+	DECall               string
+	DEGrid               string
+	DXGrid               string
+	TxWatchdog           bool
+	SubMode              string
+	FastMode             bool
+	SpecialOperationMode uint8
+	FrequencyTolerance   uint32
+	TRPeriod             uint32
+	ConfigurationName    string
+	TxMessage            string
+}
+
+type WSJTXDecodeMsg struct {
+	New           bool
+	Time          uint32 // ms since midnight UTC
+	SNR           int32
+	DeltaTime     float64
+	DeltaFreq     uint32
+	Mode          string
+	Message       string
+	LowConfidence bool
+	OffAir        bool
+}
+
+type WSJTXClearMsg struct {
+	Window uint8
+}
+
+type WSJTXReplyMsg struct {
+	Time          uint32
+	SNR           int32
+	DeltaTime     float64
+	DeltaFreq     uint32
+	Mode          string
+	Message       string
+	LowConfidence bool
+	Modifiers     uint8
+}
+
+type WSJTXQSOLoggedMsg struct {
+	TimeOff             time.Time
+	DXCall              string
+	DXGrid              string
+	TxFreq              uint64
+	Mode                string
+	ReportSent          string
+	ReportReceived      string
+	TxPower             string
+	Comments            string
+	Name                string
+	TimeOn              time.Time
+	OperatorCall        string
+	MyCall              string
+	MyGrid              string
+	ExchangeSent        string
+	ExchangeReceived    string
+	ADIFPropagationMode string
+}
+
+type WSJTXHaltTxMsg struct {
+	AutoTxOnly bool
+}
+
+type WSJTXFreeTextMsg struct {
+	Text string
+	Send bool
+}
+
+type WSJTXWSPRDecodeMsg struct {
+	New       bool
+	Time      uint32
+	SNR       int32
+	DeltaTime float64
+	Frequency uint64
+	Drift     int32
+	Callsign  string
+	Grid      string
+	Power     int32
+	OffAir    bool
+}
+
+type WSJTXLocationMsg struct {
+	Location string
+}
+
+// WSJTXColor mirrors a QColor serialized in RGB spec. Valid=false encodes
+// an invalid color, which WSJT-X uses to clear a highlight.
+type WSJTXColor struct {
+	Valid   bool
+	R, G, B uint8
+	A       uint8
+}
+
+type WSJTXHighlightMsg struct {
+	Callsign      string
+	Background    WSJTXColor
+	Foreground    WSJTXColor
+	HighlightLast bool
+}
+
+type WSJTXConfigureMsg struct {
+	Mode               string
+	FrequencyTolerance uint32
+	SubMode            string
+	FastMode           bool
+	TRPeriod           uint32
+	RxDF               uint32
+	DXCall             string
+	DXGrid             string
+	GenerateMessages   bool
+}
+
+// isWSJTXDatagram reports whether b starts with the WSJT-X magic number.
+func isWSJTXDatagram(b []byte) bool {
+	return len(b) >= 4 && binary.BigEndian.Uint32(b) == wsjtxMagic
+}
+
+// wsjtxTypeOf returns the message type of a WSJT-X datagram without decoding
+// the body. ok is false if b is not a WSJT-X datagram.
+func wsjtxTypeOf(b []byte) (WSJTXMessageType, bool) {
+	if !isWSJTXDatagram(b) || len(b) < 12 {
+		return 0, false
+	}
+	return WSJTXMessageType(binary.BigEndian.Uint32(b[8:12])), true
+}
+
+// decodeWSJTX decodes a single WSJT-X datagram.
+// Fields appended by newer WSJT-X versions are optional: a message that ends
+// early is accepted as long as the mandatory leading fields are present.
+func decodeWSJTX(b []byte) (*WSJTXMessage, error) {
+	r := &qdsReader{b: b}
+
+	if r.u32() != wsjtxMagic {
+		return nil, errors.New("wsjtx: bad magic")
+	}
+	m := &WSJTXMessage{}
+	m.Schema = r.u32()
+	m.Type = WSJTXMessageType(r.u32())
+	m.ID = r.str()
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	switch m.Type {
+	case WSJTXHeartbeat:
+		h := &WSJTXHeartbeatMsg{}
+		h.MaxSchema = r.u32()
+		h.Version = r.str()
+		h.Revision = r.str()
+		m.Heartbeat = h
+
+	case WSJTXStatus:
+		s := &WSJTXStatusMsg{}
+		s.DialFreq = r.u64()
+		s.Mode = r.str()
+		s.DXCall = r.str()
+		s.Report = r.str()
+		s.TxMode = r.str()
+		s.TxEnabled = r.bool()
+		s.Transmitting = r.bool()
+		s.Decoding = r.bool()
+		if r.err != nil {
+			return nil, r.err
+		}
+		r.optional(func() {
+			s.RxDF = r.u32()
+			s.TxDF = r.u32()
+			s.DECall = r.str()
+			s.DEGrid = r.str()
+			s.DXGrid = r.str()
+			s.TxWatchdog = r.bool()
+			s.SubMode = r.str()
+			s.FastMode = r.bool()
+			s.SpecialOperationMode = r.u8()
+			s.FrequencyTolerance = r.u32()
+			s.TRPeriod = r.u32()
+			s.ConfigurationName = r.str()
+			s.TxMessage = r.str()
+		})
+		m.Status = s
+
+	case WSJTXDecode:
+		d := &WSJTXDecodeMsg{}
+		d.New = r.bool()
+		d.Time = r.u32()
+		d.SNR = r.i32()
+		d.DeltaTime = r.f64()
+		d.DeltaFreq = r.u32()
+		d.Mode = r.str()
+		d.Message = r.str()
+		if r.err != nil {
+			return nil, r.err
+		}
+		r.optional(func() {
+			d.LowConfidence = r.bool()
+			d.OffAir = r.bool()
+		})
+		m.Decode = d
+
+	case WSJTXClear:
+		c := &WSJTXClearMsg{}
+		r.optional(func() { c.Window = r.u8() })
+		m.Clear = c
+
+	case WSJTXReply:
+		p := &WSJTXReplyMsg{}
+		p.Time = r.u32()
+		p.SNR = r.i32()
+		p.DeltaTime = r.f64()
+		p.DeltaFreq = r.u32()
+		p.Mode = r.str()
+		p.Message = r.str()
+		p.LowConfidence = r.bool()
+		if r.err != nil {
+			return nil, r.err
+		}
+		r.optional(func() { p.Modifiers = r.u8() })
+		m.Reply = p
+
+	case WSJTXQSOLogged:
+		q := &WSJTXQSOLoggedMsg{}
+		q.TimeOff = r.dateTime()
+		q.DXCall = r.str()
+		q.DXGrid = r.str()
+		q.TxFreq = r.u64()
+		q.Mode = r.str()
+		q.ReportSent = r.str()
+		q.ReportReceived = r.str()
+		q.TxPower = r.str()
+		q.Comments = r.str()
+		q.Name = r.str()
+		if r.err != nil {
+			return nil, r.err
+		}
+		r.optional(func() {
+			q.TimeOn = r.dateTime()
+			q.OperatorCall = r.str()
+			q.MyCall = r.str()
+			q.MyGrid = r.str()
+			q.ExchangeSent = r.str()
+			q.ExchangeReceived = r.str()
+			q.ADIFPropagationMode = r.str()
+		})
+		m.QSOLogged = q
+
+	case WSJTXClose, WSJTXReplay:
+		// ID のみ
+
+	case WSJTXHaltTx:
+		m.HaltTx = &WSJTXHaltTxMsg{AutoTxOnly: r.bool()}
+
+	case WSJTXFreeText:
+		f := &WSJTXFreeTextMsg{}
+		f.Text = r.str()
+		f.Send = r.bool()
+		m.FreeText = f
+
+	case WSJTXWSPRDecode:
+		w := &WSJTXWSPRDecodeMsg{}
+		w.New = r.bool()
+		w.Time = r.u32()
+		w.SNR = r.i32()
+		w.DeltaTime = r.f64()
+		w.Frequency = r.u64()
+		w.Drift = r.i32()
+		w.Callsign = r.str()
+		w.Grid = r.str()
+		w.Power = r.i32()
+		if r.err != nil {
+			return nil, r.err
+		}
+		r.optional(func() { w.OffAir = r.bool() })
+		m.WSPR = w
+
+	case WSJTXLocation:
+		m.Location = &WSJTXLocationMsg{Location: r.str()}
+
+	case WSJTXLoggedADIF:
+		m.ADIF = r.str()
+
+	case WSJTXHighlightCallsign:
+		h := &WSJTXHighlightMsg{}
+		h.Callsign = r.str()
+		h.Background = r.color()
+		h.Foreground = r.color()
+		h.HighlightLast = r.bool()
+		m.Highlight = h
+
+	case WSJTXSwitchConfiguration:
+		m.SwitchTo = r.str()
+
+	case WSJTXConfigure:
+		c := &WSJTXConfigureMsg{}
+		c.Mode = r.str()
+		c.FrequencyTolerance = r.u32()
+		c.SubMode = r.str()
+		c.FastMode = r.bool()
+		c.TRPeriod = r.u32()
+		c.RxDF = r.u32()
+		c.DXCall = r.str()
+		c.DXGrid = r.str()
+		c.GenerateMessages = r.bool()
+		m.Config = c
+
+	default:
+		return m, errors.New("wsjtx: unknown message type")
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+	return m, nil
+}
+
+// ---- QDataStream reader ----
+
+type qdsReader struct {
+	b   []byte
+	off int
+	err error
+}
+
+func (r *qdsReader) take(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if r.off+n > len(r.b) {
+		r.err = errWSJTXShort
+		return nil
+	}
+	p := r.b[r.off : r.off+n]
+	r.off += n
+	return p
+}
+
+// optional runs fn for trailing fields added in later schema revisions.
+// Running out of data inside fn is not an error.
+func (r *qdsReader) optional(fn func()) {
+	if r.err != nil || r.off >= len(r.b) {
+		return
+	}
+	fn()
+	if r.err == errWSJTXShort {
+		r.err = nil
+	}
+}
+
+func (r *qdsReader) u8() uint8 {
+	if p := r.take(1); p != nil {
+		return p[0]
+	}
+	return 0
+}
+
+func (r *qdsReader) bool() bool {
+	return r.u8() != 0
+}
+
+func (r *qdsReader) u16() uint16 {
+	if p := r.take(2); p != nil {
+		return binary.BigEndian.Uint16(p)
+	}
+	return 0
+}
+
+func (r *qdsReader) u32() uint32 {
+	if p := r.take(4); p != nil {
+		return binary.BigEndian.Uint32(p)
+	}
+	return 0
+}
+
+func (r *qdsReader) i32() int32 {
+	return int32(r.u32())
+}
+
+func (r *qdsReader) u64() uint64 {
+	if p := r.take(8); p != nil {
+		return binary.BigEndian.Uint64(p)
+	}
+	return 0
+}
+
+func (r *qdsReader) f64() float64 {
+	return math.Float64frombits(r.u64())
+}
+
+// str reads a QByteArray holding UTF-8. A length of 0xFFFFFFFF is a null string.
+func (r *qdsReader) str() string {
+	n := r.u32()
+	if r.err != nil || n == 0xFFFFFFFF {
+		return ""
+	}
+	if int64(n) > int64(len(r.b)-r.off) {
+		r.err = errWSJTXShort
+		return ""
+	}
+	return string(r.take(int(n)))
+}
+
+// dateTime reads a QDateTime: QDate (Julian day, qint64), QTime (ms, quint32)
+// and a timespec byte, followed by an offset when timespec is Qt::OffsetFromUTC.
+func (r *qdsReader) dateTime() time.Time {
+	jd := int64(r.u64())
+	ms := r.u32()
+	spec := r.u8()
+	offset := 0
+	switch spec {
+	case 2: // Qt::OffsetFromUTC
+		offset = int(r.i32())
+	case 3: // Qt::TimeZone（IANA ID を QByteArray で保持）
+		_ = r.str()
+	}
+	if r.err != nil || jd == 0 {
+		return time.Time{}
+	}
+
+	// ユリウス日 → Unix 日（1970-01-01 = JD 2440588）
+	days := jd - 2440588
+	t := time.Unix(days*86400, 0).UTC().Add(time.Duration(ms) * time.Millisecond)
+	if spec == 2 {
+		t = t.Add(-time.Duration(offset) * time.Second)
+	}
+	return t
+}
+
+// color reads a QColor. Only the RGB spec is interpreted; other specs are
+// returned as invalid.
+func (r *qdsReader) color() WSJTXColor {
+	spec := int8(r.u8())
+	a := r.u16()
+	c1 := r.u16()
+	c2 := r.u16()
+	c3 := r.u16()
+	_ = r.u16() // pad
+	if spec != 1 {
+		return WSJTXColor{}
+	}
+	return WSJTXColor{Valid: true, R: uint8(c1 >> 8), G: uint8(c2 >> 8), B: uint8(c3 >> 8), A: uint8(a >> 8)}
+}
+
+// ---- QDataStream writer ----
+
+type qdsWriter struct {
+	buf bytes.Buffer
+}
+
+// newWSJTXWriter starts an outgoing message with the common header.
+func newWSJTXWriter(schema uint32, t WSJTXMessageType, id string) *qdsWriter {
+	w := &qdsWriter{}
+	w.u32(wsjtxMagic)
+	w.u32(schema)
+	w.u32(uint32(t))
+	w.str(id)
+	return w
+}
+
+func (w *qdsWriter) u8(v uint8) {
+	w.buf.WriteByte(v)
+}
+
+func (w *qdsWriter) bool(v bool) {
+	if v {
+		w.u8(1)
+	} else {
+		w.u8(0)
+	}
+}
+
+func (w *qdsWriter) u16(v uint16) {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], v)
+	w.buf.Write(b[:])
+}
+
+func (w *qdsWriter) u32(v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	w.buf.Write(b[:])
+}
+
+func (w *qdsWriter) i32(v int32) {
+	w.u32(uint32(v))
+}
+
+func (w *qdsWriter) u64(v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	w.buf.Write(b[:])
+}
+
+func (w *qdsWriter) f64(v float64) {
+	w.u64(math.Float64bits(v))
+}
+
+func (w *qdsWriter) str(s string) {
+	w.u32(uint32(len(s)))
+	w.buf.WriteString(s)
+}
+
+func (w *qdsWriter) color(c WSJTXColor) {
+	if !c.Valid {
+		// Invalid spec
+		w.u8(0)
+		w.u16(0xFFFF)
+		w.u16(0)
+		w.u16(0)
+		w.u16(0)
+		w.u16(0)
+		return
+	}
+	a := c.A
+	if a == 0 {
+		a = 0xFF
+	}
+	w.u8(1) // Rgb spec
+	w.u16(uint16(a) * 0x101)
+	w.u16(uint16(c.R) * 0x101)
+	w.u16(uint16(c.G) * 0x101)
+	w.u16(uint16(c.B) * 0x101)
+	w.u16(0)
+}
+
+func (w *qdsWriter) bytes() []byte {
+	return w.buf.Bytes()
+}
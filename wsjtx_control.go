@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// wsjtxPeer is a WSJT-X/JTDX instance the bridge has heard from.
+// Commands are sent back from the socket the peer's datagrams arrived on,
+// which is what WSJT-X expects.
+type wsjtxPeer struct {
+	ID       string
+	Addr     *net.UDPAddr
+	Conn     *net.UDPConn
+	Schema   uint32
+	LastSeen time.Time
+}
+
+var wsjtxPeers = make(map[string]*wsjtxPeer)
+var wsjtxLastPeerID string
+var wsjtxPeersMu sync.Mutex
+
+// rememberWSJTXPeer records the sender of a Heartbeat, Status or Decode message.
+func rememberWSJTXPeer(m *WSJTXMessage, conn *net.UDPConn, addr *net.UDPAddr) {
+	if conn == nil || addr == nil {
+		return
+	}
+
+	wsjtxPeersMu.Lock()
+	defer wsjtxPeersMu.Unlock()
+
+	p, ok := wsjtxPeers[m.ID]
+	if !ok {
+		p = &wsjtxPeer{ID: m.ID, Schema: 2}
+		wsjtxPeers[m.ID] = p
+		log.Printf("[WSJTX] peer: id=%s addr=%s", m.ID, addr)
+	}
+	p.Addr = addr
+	p.Conn = conn
+	p.LastSeen = time.Now()
+
+	// Heartbeat でネゴシエーション（双方がサポートする最大スキーマ）
+	if m.Heartbeat != nil {
+		p.Schema = m.Heartbeat.MaxSchema
+		if p.Schema > wsjtxSchema {
+			p.Schema = wsjtxSchema
+		}
+		if p.Schema == 0 {
+			p.Schema = 2
+		}
+	}
+
+	wsjtxLastPeerID = m.ID
+}
+
+// lookupWSJTXPeer returns the peer with the given id, or the most recently
+// heard peer when id is empty.
+func lookupWSJTXPeer(id string) (*wsjtxPeer, error) {
+	wsjtxPeersMu.Lock()
+	defer wsjtxPeersMu.Unlock()
+
+	if id == "" {
+		id = wsjtxLastPeerID
+	}
+	p, ok := wsjtxPeers[id]
+	if !ok {
+		return nil, errors.New("no WSJT-X instance heard yet")
+	}
+	cp := *p
+	return &cp, nil
+}
+
+// sendToWSJTX sends an encoded message to the given peer.
+func sendToWSJTX(p *wsjtxPeer, b []byte) error {
+	_, err := p.Conn.WriteToUDP(b, p.Addr)
+	return err
+}
+
+// WSJTXCommand is the WebSocket request body for all wsjtx* commands.
+// Fields not used by a given command are ignored.
+type WSJTXCommand struct {
+	Command        // type: "wsjtxReply" など
+	ID      string `json:"id"` // 省略時は最後に受信したインスタンス
+
+	// wsjtxReply（wsjtx_decode イベントの値をそのまま返す）
+	Time          uint32  `json:"time"`
+	SNR           int32   `json:"snr"`
+	DeltaTime     float64 `json:"deltaTime"`
+	DeltaFreq     uint32  `json:"deltaFreq"`
+	Mode          string  `json:"mode"`
+	Message       string  `json:"message"`
+	LowConfidence bool    `json:"lowConfidence"`
+	Modifiers     uint8   `json:"modifiers"`
+
+	// wsjtxHaltTx
+	AutoTxOnly bool `json:"autoTxOnly"`
+
+	// wsjtxFreeText
+	Text string `json:"text"`
+	Send bool   `json:"send"`
+
+	// wsjtxLocation
+	Location string `json:"location"`
+
+	// wsjtxHighlightCallsign（色は "#RRGGBB"、空文字でハイライト解除）
+	Callsign      string `json:"callsign"`
+	Background    string `json:"background"`
+	Foreground    string `json:"foreground"`
+	HighlightLast bool   `json:"highlightLast"`
+}
+
+// handleWSJTXCommand encodes a wsjtx* WebSocket command as the corresponding
+// WSJT-X message and sends it to the target instance. It returns the JSON
+// response to send back to the client.
+func handleWSJTXCommand(msg []byte) Message {
+	var cmd WSJTXCommand
+	if err := json.Unmarshal(msg, &cmd); err != nil {
+		return wsjtxCommandError("", err)
+	}
+
+	p, err := lookupWSJTXPeer(cmd.ID)
+	if err != nil {
+		return wsjtxCommandError(cmd.Type, err)
+	}
+
+	b, err := encodeWSJTXCommand(p, &cmd)
+	if err != nil {
+		return wsjtxCommandError(cmd.Type, err)
+	}
+
+	if err := sendToWSJTX(p, b); err != nil {
+		log.Printf("[WSJTX] send error: %s → %s: %v", cmd.Type, p.ID, err)
+		return wsjtxCommandError(cmd.Type, err)
+	}
+	log.Printf("[WSJTX] sent %s → %s (%s)", cmd.Type, p.ID, p.Addr)
+
+	return &WSJTXAckReply{
+		Envelope: newEnvelope("wsjtxAck", ""),
+		Command:  cmd.Type,
+		ID:       p.ID,
+	}
+}
+
+// encodeWSJTXCommand builds the WSJT-X datagram for a WebSocket command.
+func encodeWSJTXCommand(p *wsjtxPeer, cmd *WSJTXCommand) ([]byte, error) {
+	switch cmd.Type {
+	case "wsjtxReply":
+		if cmd.Message == "" {
+			return nil, errors.New("message is required")
+		}
+		w := newWSJTXWriter(p.Schema, WSJTXReply, p.ID)
+		w.u32(cmd.Time)
+		w.i32(cmd.SNR)
+		w.f64(cmd.DeltaTime)
+		w.u32(cmd.DeltaFreq)
+		w.str(cmd.Mode)
+		w.str(cmd.Message)
+		w.bool(cmd.LowConfidence)
+		w.u8(cmd.Modifiers)
+		return w.bytes(), nil
+
+	case "wsjtxHaltTx":
+		w := newWSJTXWriter(p.Schema, WSJTXHaltTx, p.ID)
+		w.bool(cmd.AutoTxOnly)
+		return w.bytes(), nil
+
+	case "wsjtxFreeText":
+		w := newWSJTXWriter(p.Schema, WSJTXFreeText, p.ID)
+		w.str(cmd.Text)
+		w.bool(cmd.Send)
+		return w.bytes(), nil
+
+	case "wsjtxLocation":
+		if cmd.Location == "" {
+			return nil, errors.New("location is required")
+		}
+		w := newWSJTXWriter(p.Schema, WSJTXLocation, p.ID)
+		w.str(cmd.Location)
+		return w.bytes(), nil
+
+	case "wsjtxHighlightCallsign":
+		if cmd.Callsign == "" {
+			return nil, errors.New("callsign is required")
+		}
+		bg, err := parseWSJTXColor(cmd.Background)
+		if err != nil {
+			return nil, err
+		}
+		fg, err := parseWSJTXColor(cmd.Foreground)
+		if err != nil {
+			return nil, err
+		}
+		w := newWSJTXWriter(p.Schema, WSJTXHighlightCallsign, p.ID)
+		w.str(strings.ToUpper(cmd.Callsign))
+		w.color(bg)
+		w.color(fg)
+		w.bool(cmd.HighlightLast)
+		return w.bytes(), nil
+	}
+
+	return nil, fmt.Errorf("unknown command: %s", cmd.Type)
+}
+
+// parseWSJTXColor parses "#RRGGBB" (or "RRGGBB"). An empty string is an
+// invalid color, which tells WSJT-X to clear the highlight.
+func parseWSJTXColor(s string) (WSJTXColor, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "#")
+	if s == "" {
+		return WSJTXColor{}, nil
+	}
+	if len(s) != 6 {
+		return WSJTXColor{}, fmt.Errorf("invalid color: %q", s)
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return WSJTXColor{}, fmt.Errorf("invalid color: %q", s)
+	}
+	return WSJTXColor{Valid: true, R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v)}, nil
+}
+
+func wsjtxCommandError(command string, err error) Message {
+	return newErrorReply(command, err)
+}
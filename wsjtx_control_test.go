@@ -0,0 +1,142 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEncodeWSJTXCommand(t *testing.T) {
+	peer := &wsjtxPeer{ID: "WSJT-X", Schema: 3}
+	tests := []struct {
+		name string
+		peer *wsjtxPeer
+		typ  string
+		cmd  WSJTXCommand
+		hex  string
+		want *WSJTXMessage // 送ったデータグラムを decodeWSJTX で読んだ結果
+	}{
+		{
+			name: "reply",
+			typ:  "wsjtxReply",
+			cmd: WSJTXCommand{Time: 45015000, SNR: -12, DeltaTime: 0.2, DeltaFreq: 1234,
+				Mode: "~", Message: "CQ K1ABC FN42", Modifiers: 0x02},
+			hex: `adbccbda 00000003 00000004 00000006 57534a542d58
+				02aedfd8 fffffff4 3fc999999999999a 000004d2 00000001 7e
+				0000000d 4351204b3141424320464e3432 00 02`,
+			want: &WSJTXMessage{Type: WSJTXReply, Schema: 3, ID: "WSJT-X",
+				Reply: &WSJTXReplyMsg{Time: 45015000, SNR: -12, DeltaTime: 0.2, DeltaFreq: 1234,
+					Mode: "~", Message: "CQ K1ABC FN42", Modifiers: 0x02}},
+		},
+		{
+			name: "halt tx",
+			typ:  "wsjtxHaltTx",
+			cmd:  WSJTXCommand{AutoTxOnly: true},
+			hex:  `adbccbda 00000003 00000008 00000006 57534a542d58 01`,
+			want: &WSJTXMessage{Type: WSJTXHaltTx, Schema: 3, ID: "WSJT-X",
+				HaltTx: &WSJTXHaltTxMsg{AutoTxOnly: true}},
+		},
+		{
+			name: "free text",
+			typ:  "wsjtxFreeText",
+			cmd:  WSJTXCommand{Text: "73 GL", Send: true},
+			hex:  `adbccbda 00000003 00000009 00000006 57534a542d58 00000005 373320474c 01`,
+			want: &WSJTXMessage{Type: WSJTXFreeText, Schema: 3, ID: "WSJT-X",
+				FreeText: &WSJTXFreeTextMsg{Text: "73 GL", Send: true}},
+		},
+		{
+			name: "location to a schema 2 peer",
+			typ:  "wsjtxLocation",
+			peer: &wsjtxPeer{ID: "JTDX", Schema: 2},
+			cmd:  WSJTXCommand{Location: "PM95"},
+			hex:  `adbccbda 00000002 0000000b 00000004 4a544458 00000004 504d3935`,
+			want: &WSJTXMessage{Type: WSJTXLocation, Schema: 2, ID: "JTDX",
+				Location: &WSJTXLocationMsg{Location: "PM95"}},
+		},
+		{
+			name: "highlight callsign",
+			typ:  "wsjtxHighlightCallsign",
+			cmd:  WSJTXCommand{Callsign: "k1abc", Background: "#ff0000", Foreground: "", HighlightLast: true},
+			hex: `adbccbda 00000003 0000000d 00000006 57534a542d58 00000005 4b31414243
+				01 ffff ffff 0000 0000 0000
+				00 ffff 0000 0000 0000 0000
+				01`,
+			want: &WSJTXMessage{Type: WSJTXHighlightCallsign, Schema: 3, ID: "WSJT-X",
+				Highlight: &WSJTXHighlightMsg{Callsign: "K1ABC",
+					Background:    WSJTXColor{Valid: true, R: 0xFF, A: 0xFF},
+					HighlightLast: true}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.peer
+			if p == nil {
+				p = peer
+			}
+			cmd := tt.cmd
+			cmd.Type = tt.typ
+
+			b, err := encodeWSJTXCommand(p, &cmd)
+			if err != nil {
+				t.Fatalf("encodeWSJTXCommand: %v", err)
+			}
+			if want := datagram(t, tt.hex); !reflect.DeepEqual(b, want) {
+				t.Errorf("encodeWSJTXCommand:\n got % x\nwant % x", b, want)
+			}
+			m, err := decodeWSJTX(b)
+			if err != nil {
+				t.Fatalf("decodeWSJTX: %v", err)
+			}
+			if !reflect.DeepEqual(m, tt.want) {
+				t.Errorf("decodeWSJTX:\n got %+v\nwant %+v", m, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodeWSJTXCommandErrors(t *testing.T) {
+	peer := &wsjtxPeer{ID: "WSJT-X", Schema: 3}
+	tests := []struct {
+		typ string
+		cmd WSJTXCommand
+	}{
+		{"wsjtxReply", WSJTXCommand{Mode: "~"}},
+		{"wsjtxLocation", WSJTXCommand{}},
+		{"wsjtxHighlightCallsign", WSJTXCommand{Background: "#ff0000"}},
+		{"wsjtxHighlightCallsign", WSJTXCommand{Callsign: "K1ABC", Background: "red"}},
+		{"wsjtxHighlightCallsign", WSJTXCommand{Callsign: "K1ABC", Foreground: "#ff00"}},
+		{"wsjtxConfigure", WSJTXCommand{}},
+	}
+	for _, tt := range tests {
+		cmd := tt.cmd
+		cmd.Type = tt.typ
+		if b, err := encodeWSJTXCommand(peer, &cmd); err == nil {
+			t.Errorf("encodeWSJTXCommand(%s %+v) = % x, want error", tt.typ, tt.cmd, b)
+		}
+	}
+}
+
+func TestParseWSJTXColor(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    WSJTXColor
+		wantErr bool
+	}{
+		{in: "", want: WSJTXColor{}},
+		{in: "  ", want: WSJTXColor{}},
+		{in: "#FF8000", want: WSJTXColor{Valid: true, R: 0xFF, G: 0x80}},
+		{in: "0a0b0c", want: WSJTXColor{Valid: true, R: 0x0A, G: 0x0B, B: 0x0C}},
+		{in: "#fff", wantErr: true},
+		{in: "#gg0000", wantErr: true},
+		{in: "#ff00001", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseWSJTXColor(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseWSJTXColor(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseWSJTXColor(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// datagram decodes a hex dump of a WSJT-X datagram (spaces and newlines
+// are ignored).
+func datagram(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.Join(strings.Fields(s), ""))
+	if err != nil {
+		t.Fatalf("bad hex: %v", err)
+	}
+	return b
+}
+
+// WSJT-X 2.6 の NetworkMessage 形式で送られるデータグラム
+const (
+	wsjtxHeartbeatHex = `adbccbda 00000003 00000000 00000006 57534a542d58
+		00000003 00000005 322e362e31 00000006 616637643462`
+
+	wsjtxStatusHex = `adbccbda 00000003 00000001 00000006 57534a542d58
+		0000000000d6c090 00000003 465438 00000005 4a41314142 00000003 2d3130
+		00000003 465438 01 00 01 000005dc 000004b0
+		00000005 4b31414243 00000004 464e3432 00000004 504d3935 00
+		ffffffff 00 00 ffffffff ffffffff 00000007 44656661756c74
+		00000010 4a41314142204b3141424320464e3432`
+
+	// スキーマ 2 の古い WSJT-X（Decoding まで）
+	wsjtxStatusShortHex = `adbccbda 00000002 00000001 00000006 57534a542d58
+		00000000006bf0d0 00000003 465434 00000000 00000000 00000003 465434 00 00 00`
+
+	wsjtxDecodeHex = `adbccbda 00000003 00000002 00000006 57534a542d58
+		01 02aedfd8 fffffff4 3fc999999999999a 000004d2 00000001 7e
+		0000000d 4351204b3141424320464e3432 00 00`
+
+	wsjtxQSOLoggedHex = `adbccbda 00000003 00000005 00000006 57534a542d58
+		0000000000258a98 02afca38 01
+		00000005 4a41314142 00000004 504d3935 0000000000d6c562 00000003 465438
+		00000003 2d3130 00000003 2d3132 00000003 313030 00000003 746e78 00000004 5461726f
+		0000000000258a98 02aedfd8 01
+		00000000 00000005 4b31414243 00000004 464e3432 00000000 00000000 00000000`
+
+	// 時刻が UTC+9 のオフセット付き、TimeOn 以降なし
+	wsjtxQSOLoggedOffsetHex = `adbccbda 00000003 00000005 00000006 57534a542d58
+		0000000000258a98 02afca38 02 00007e90
+		00000005 4a41314142 00000000 00000000006bf0d0 00000003 465438
+		00000000 00000000 00000000 00000000 00000000`
+
+	wsjtxLoggedADIFHex = `adbccbda 00000003 0000000c 00000006 57534a542d58
+		0000004c 0a3c616469665f7665723a353e332e312e300a3c70726f6772616d69643a363e57534a542d580a
+		3c454f483e0a3c63616c6c3a353e4a4131414220 3c6d6f64653a333e46543820 3c454f523e`
+
+	wsjtxWSPRHex = `adbccbda 00000003 0000000a 00000006 57534a542d58
+		01 0036ee80 ffffffe7 3fe0000000000000 0000000000d71a9a ffffffff
+		00000005 4b31414243 00000004 464e3432 00000025`
+
+	// Window のない古い Clear
+	wsjtxClearHex = `adbccbda 00000003 00000003 00000006 57534a542d58`
+)
+
+func TestDecodeWSJTX(t *testing.T) {
+	tests := []struct {
+		name string
+		hex  string
+		want *WSJTXMessage
+	}{
+		{
+			name: "heartbeat",
+			hex:  wsjtxHeartbeatHex,
+			want: &WSJTXMessage{Type: WSJTXHeartbeat, Schema: 3, ID: "WSJT-X",
+				Heartbeat: &WSJTXHeartbeatMsg{MaxSchema: 3, Version: "2.6.1", Revision: "af7d4b"}},
+		},
+		{
+			name: "status",
+			hex:  wsjtxStatusHex,
+			want: &WSJTXMessage{Type: WSJTXStatus, Schema: 3, ID: "WSJT-X",
+				Status: &WSJTXStatusMsg{
+					DialFreq: 14074000, Mode: "FT8", DXCall: "JA1AB", Report: "-10", TxMode: "FT8",
+					TxEnabled: true, Decoding: true, RxDF: 1500, TxDF: 1200,
+					DECall: "K1ABC", DEGrid: "FN42", DXGrid: "PM95",
+					FrequencyTolerance: 0xFFFFFFFF, TRPeriod: 0xFFFFFFFF,
+					ConfigurationName: "Default", TxMessage: "JA1AB K1ABC FN42",
+				}},
+		},
+		{
+			name: "status without optional fields",
+			hex:  wsjtxStatusShortHex,
+			want: &WSJTXMessage{Type: WSJTXStatus, Schema: 2, ID: "WSJT-X",
+				Status: &WSJTXStatusMsg{DialFreq: 7074000, Mode: "FT4", TxMode: "FT4"}},
+		},
+		{
+			name: "decode",
+			hex:  wsjtxDecodeHex,
+			want: &WSJTXMessage{Type: WSJTXDecode, Schema: 3, ID: "WSJT-X",
+				Decode: &WSJTXDecodeMsg{New: true, Time: 45015000, SNR: -12, DeltaTime: 0.2,
+					DeltaFreq: 1234, Mode: "~", Message: "CQ K1ABC FN42"}},
+		},
+		{
+			name: "qso logged",
+			hex:  wsjtxQSOLoggedHex,
+			want: &WSJTXMessage{Type: WSJTXQSOLogged, Schema: 3, ID: "WSJT-X",
+				QSOLogged: &WSJTXQSOLoggedMsg{
+					TimeOff: time.Date(2024, 1, 2, 12, 31, 15, 0, time.UTC),
+					DXCall:  "JA1AB", DXGrid: "PM95", TxFreq: 14075234, Mode: "FT8",
+					ReportSent: "-10", ReportReceived: "-12", TxPower: "100",
+					Comments: "tnx", Name: "Taro",
+					TimeOn: time.Date(2024, 1, 2, 12, 30, 15, 0, time.UTC),
+					MyCall: "K1ABC", MyGrid: "FN42",
+				}},
+		},
+		{
+			name: "qso logged with UTC offset",
+			hex:  wsjtxQSOLoggedOffsetHex,
+			want: &WSJTXMessage{Type: WSJTXQSOLogged, Schema: 3, ID: "WSJT-X",
+				QSOLogged: &WSJTXQSOLoggedMsg{
+					TimeOff: time.Date(2024, 1, 2, 3, 31, 15, 0, time.UTC),
+					DXCall:  "JA1AB", TxFreq: 7074000, Mode: "FT8",
+				}},
+		},
+		{
+			name: "logged ADIF",
+			hex:  wsjtxLoggedADIFHex,
+			want: &WSJTXMessage{Type: WSJTXLoggedADIF, Schema: 3, ID: "WSJT-X",
+				ADIF: "\n<adif_ver:5>3.1.0\n<programid:6>WSJT-X\n<EOH>\n<call:5>JA1AB <mode:3>FT8 <EOR>"},
+		},
+		{
+			name: "WSPR decode",
+			hex:  wsjtxWSPRHex,
+			want: &WSJTXMessage{Type: WSJTXWSPRDecode, Schema: 3, ID: "WSJT-X",
+				WSPR: &WSJTXWSPRDecodeMsg{New: true, Time: 3600000, SNR: -25, DeltaTime: 0.5,
+					Frequency: 14097050, Drift: -1, Callsign: "K1ABC", Grid: "FN42", Power: 37}},
+		},
+		{
+			name: "clear without window",
+			hex:  wsjtxClearHex,
+			want: &WSJTXMessage{Type: WSJTXClear, Schema: 3, ID: "WSJT-X", Clear: &WSJTXClearMsg{}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeWSJTX(datagram(t, tt.hex))
+			if err != nil {
+				t.Fatalf("decodeWSJTX: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decodeWSJTX:\n got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeWSJTXErrors(t *testing.T) {
+	decode := datagram(t, wsjtxDecodeHex)
+	tests := []struct {
+		name string
+		b    []byte
+	}{
+		{"empty", nil},
+		{"bad magic", append([]byte{0xAD, 0xBC, 0xCB, 0xDB}, decode[4:]...)},
+		{"truncated header", decode[:14]},
+		{"truncated before optional fields", decode[:len(decode)-20]},
+		{"string longer than datagram", datagram(t, `adbccbda 00000003 00000009 00000006 57534a542d58 00000010 6869`)},
+		{"unknown type", datagram(t, `adbccbda 00000003 00000063 00000006 57534a542d58`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if m, err := decodeWSJTX(tt.b); err == nil {
+				t.Errorf("decodeWSJTX = %+v, want error", m)
+			}
+		})
+	}
+}
+
+func TestWSJTXTypeOf(t *testing.T) {
+	if typ, ok := wsjtxTypeOf(datagram(t, wsjtxQSOLoggedHex)); !ok || typ != WSJTXQSOLogged {
+		t.Errorf("wsjtxTypeOf = %v, %v; want qso_logged", typ, ok)
+	}
+	if _, ok := wsjtxTypeOf([]byte("<call:5>JA1AB <eor>")); ok {
+		t.Error("wsjtxTypeOf accepted an ADIF datagram")
+	}
+}